@@ -9,6 +9,13 @@
 // and cannot be used nor started alone at the moment.
 // You can read more on how to enable and start Application Security for Go at
 // https://docs.datadoghq.com/security_platform/application_security/getting_started/go
+//
+// Requests are blocked by the security rules having a blocking action, according to the
+// rules data they use, such as the lists of IP addresses and user IDs to block. Both the
+// rules and their data can be provided with the DD_APPSEC_RULES environment variable, and
+// keep being enforced when remote configuration is unavailable (e.g. in air-gapped
+// environments). Only the updates of the blocked IP addresses and user IDs at run time
+// require remote configuration.
 package appsec
 
 import (
@@ -17,11 +24,43 @@ import (
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/httpsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/sharedsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 )
 
+// Bypass returns a copy of the given context disabling the security monitoring of the
+// HTTP request it is used for. It must be set on the request context before the APM
+// tracer middleware monitors the request, for instance by a preceding middleware
+// function authenticating trusted internal traffic:
+//
+//	r = r.WithContext(appsec.Bypass(r.Context()))
+//
+// The request is still traced, but neither the security rules nor the blocking
+// checks of MonitorParsedHTTPBody and SetUser are applied to it.
+func Bypass(ctx context.Context) context.Context {
+	return instrumentation.WithBypass(ctx)
+}
+
+// RequiredAddresses returns the sorted list of the WAF addresses used by the security
+// rules currently in use, or nil when AppSec isn't running. It allows checking which
+// parts of the requests and responses are needed by the rules, for instance whether
+// the request body address "server.request.body" is, before disabling body analysis.
+func RequiredAddresses() []string {
+	return appsec.RequiredAddresses()
+}
+
+// Status returns whether AppSec is enabled and healthy, along with a human-readable
+// description of its status, such as the number of security rules loaded by the WAF
+// and the remote configuration connectivity, so that it can be reported by a health
+// or readiness endpoint. AppSec is healthy when it is running with a WAF having loaded
+// security rules. The remote configuration connectivity doesn't affect its health,
+// since the security rules in use keep being enforced without it.
+func Status() (enabled, healthy bool, details string) {
+	return appsec.Status()
+}
+
 // MonitorParsedHTTPBody runs the security monitoring rules on the given *parsed*
 // HTTP request body. The given context must be the HTTP request context as returned
 // by the Context() method of an HTTP request. Calls to this function are ignored if
@@ -0,0 +1,133 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023 Datadog, Inc.
+
+package appsec
+
+import (
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation"
+)
+
+type (
+	// StartOption configures Application Security when given to the tracer with
+	// tracer.WithAppSecOptions:
+	//
+	//	tracer.Start(tracer.WithAppSecOptions(appsec.WithBodyAnalysis(false)))
+	StartOption = appsec.StartOption
+
+	// SecurityEvent is a security event detected by the WAF in a request, as
+	// reported to the event sink set by WithEventSink.
+	SecurityEvent = appsec.SecurityEvent
+	// SecurityEventMatch is a request value that matched a security rule.
+	SecurityEventMatch = appsec.SecurityEventMatch
+	// SecurityEventRequest is the metadata of the request a security event was
+	// detected in.
+	SecurityEventRequest = appsec.SecurityEventRequest
+
+	// EventEnricher is the function set by WithEventEnricher.
+	EventEnricher = instrumentation.EventEnricher
+	// TagSetter is the span given to the EventEnricher.
+	TagSetter = instrumentation.TagSetter
+
+	// WAFErrorBehavior is what happens to the requests when the WAF fails to
+	// run, as set by WithWAFErrorBehavior.
+	WAFErrorBehavior = appsec.WAFErrorBehavior
+)
+
+const (
+	// WAFFailOpen lets the requests proceed when the WAF fails to run (default).
+	WAFFailOpen = appsec.WAFFailOpen
+	// WAFFailClosed blocks the requests when the WAF fails to run.
+	WAFFailClosed = appsec.WAFFailClosed
+)
+
+// WithBodyAnalysis enables or disables the analysis of the request body by the
+// WAF, taking precedence over the DD_APPSEC_BODY_ANALYSIS_ENABLED environment
+// variable. Disabling it reduces the WAF execution time for services handling
+// large bodies, but attacks only present in the request body will no longer be
+// detected nor blocked.
+func WithBodyAnalysis(enabled bool) StartOption {
+	return appsec.WithBodyAnalysis(enabled)
+}
+
+// WithMinimalMode restricts AppSec to the blocking of requests with the minimum
+// per-request overhead: only the security rules having a blocking action are
+// run, and neither the request body, nor the response status code and headers,
+// are passed to the WAF. Attacks detected by the other security rules are
+// therefore no longer reported.
+func WithMinimalMode() StartOption {
+	return appsec.WithMinimalMode()
+}
+
+// WithCollectedHeaders sets the allowlist of the request headers collected
+// into the security events, in place of the default list of collected
+// headers, in order to reduce the size of the security events and the exposure
+// of personal data. The client IP header set with DD_TRACE_CLIENT_IP_HEADER is
+// always collected, and the response headers are not affected.
+func WithCollectedHeaders(headers ...string) StartOption {
+	return appsec.WithCollectedHeaders(headers...)
+}
+
+// WithMaxCollectedHeadersSize caps the total size, in bytes, of the names and
+// values of the request headers collected into the security events. The
+// headers going over the size, considered in alphabetical order, are not
+// collected. Zero means no limit (default).
+func WithMaxCollectedHeadersSize(size int) StartOption {
+	return appsec.WithMaxCollectedHeadersSize(size)
+}
+
+// WithEventEnricher sets the function called with the request context when
+// security events were produced for an HTTP request, before they get reported
+// in the service entry span, so that it can add span tags correlating them with
+// application-specific data found in the request context, such as tenant IDs.
+// The function must be safe for concurrent use.
+func WithEventEnricher(fn EventEnricher) StartOption {
+	return appsec.WithEventEnricher(fn)
+}
+
+// WithWAFSampleRate sets the fraction of the requests monitored by the WAF,
+// between 0 and 1, in order to reduce the AppSec overhead of latency-sensitive
+// services. The requests that are not sampled are not protected at all: attacks
+// in them are neither detected nor blocked, including the IP addresses and user
+// IDs blocked by your denylists. Rates outside of [0, 1] are ignored.
+func WithWAFSampleRate(rate float64) StartOption {
+	return appsec.WithWAFSampleRate(rate)
+}
+
+// WithRulesFromFiles sets the security rules to the merge of the rules files at
+// the given paths, taking precedence over DD_APPSEC_RULES. The first file is
+// the base ruleset and the next ones are edits applied in order, whose entries
+// replace the base entries having the same ID. AppSec doesn't start when a file
+// cannot be read or parsed.
+func WithRulesFromFiles(paths ...string) StartOption {
+	return appsec.WithRulesFromFiles(paths...)
+}
+
+// WithMonitoringEventKeepRate sets the fraction of the traces with
+// monitoring-only security events that are force-kept, between 0 and 1, in
+// order to limit the trace volume sent under heavy attacks. The traces of the
+// blocked requests are always kept, and the other ones are left to the regular
+// trace sampling, which may drop them along with their security events. Rates
+// outside of [0, 1] are ignored.
+func WithMonitoringEventKeepRate(rate float64) StartOption {
+	return appsec.WithMonitoringEventKeepRate(rate)
+}
+
+// WithEventSink sets the function called with every security event reported to
+// Datadog, so that they can be forwarded to other security systems, such as a
+// SIEM. It is called sequentially from a dedicated goroutine, out of the request
+// path, and the events are dropped when it is too slow to keep up with them.
+// The pending events are reported when the tracer stops.
+func WithEventSink(fn func(event SecurityEvent)) StartOption {
+	return appsec.WithEventSink(fn)
+}
+
+// WithWAFErrorBehavior sets what happens to the requests when the WAF fails to
+// run due to an internal error, which is different from a WAF timeout:
+// WAFFailOpen lets them proceed unprotected (default), while WAFFailClosed
+// blocks them when the WAF fails before the handler responds.
+func WithWAFErrorBehavior(b WAFErrorBehavior) StartOption {
+	return appsec.WithWAFErrorBehavior(b)
+}
@@ -0,0 +1,218 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023 Datadog, Inc.
+
+package appsec_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/appsec"
+	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	privateAppsec "gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/httpmem"
+)
+
+// agentSpan is a span received by the test agent.
+type agentSpan struct {
+	Meta    map[string]string  `json:"meta"`
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+// startTracer starts the tracer with the given AppSec options, sending its
+// traces to a test agent, and returns the function stopping it and returning
+// the received spans.
+func startTracer(t *testing.T, opts ...appsec.StartOption) (stop func() []agentSpan) {
+	var (
+		mu    sync.Mutex
+		spans []agentSpan
+	)
+	srv, client := httpmem.ServerAndClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v0.4/traces" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		buf, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var js bytes.Buffer
+		_, err = msgp.UnmarshalAsJSON(&js, buf)
+		require.NoError(t, err)
+		var traces [][]agentSpan
+		require.NoError(t, json.Unmarshal(js.Bytes(), &traces))
+		mu.Lock()
+		for _, trace := range traces {
+			spans = append(spans, trace...)
+		}
+		mu.Unlock()
+	}))
+	tracer.Start(tracer.WithHTTPClient(client), tracer.WithAppSecOptions(opts...), tracer.WithLogStartup(false))
+	return func() []agentSpan {
+		tracer.Stop()
+		srv.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		return spans
+	}
+}
+
+// TestStartOptions checks that the AppSec options given to the tracer apply.
+func TestStartOptions(t *testing.T) {
+	t.Setenv("DD_APPSEC_ENABLED", "true")
+	t.Setenv("DD_INSTRUMENTATION_TELEMETRY_ENABLED", "false")
+	const attack = "<script>alert(1)</script>"
+
+	var (
+		enriched bool
+		events   []appsec.SecurityEvent
+	)
+	for _, tc := range []struct {
+		name     string
+		opts     []appsec.StartOption
+		body     bool   // send the attack in the request body rather than in the query
+		clientIP string // client IP address of the request, if set
+		status   int
+		detected bool
+		check    func(t *testing.T, span agentSpan)
+	}{
+		{
+			name:     "default",
+			detected: true,
+			check: func(t *testing.T, span agentSpan) {
+				// the security events force-keep the trace
+				require.Equal(t, float64(2), span.Metrics["_sampling_priority_v1"])
+			},
+		},
+		{name: "body", body: true, detected: true},
+		{name: "body-analysis-disabled", opts: []appsec.StartOption{appsec.WithBodyAnalysis(false)}, body: true},
+		{name: "minimal", opts: []appsec.StartOption{appsec.WithMinimalMode()}},
+		{
+			name:     "collected-headers",
+			opts:     []appsec.StartOption{appsec.WithCollectedHeaders("User-Agent")},
+			detected: true,
+			check: func(t *testing.T, span agentSpan) {
+				require.Equal(t, "Go-http-client/1.1", span.Meta["http.request.headers.user-agent"])
+				require.NotContains(t, span.Meta, "http.request.headers.host")
+			},
+		},
+		{
+			name:     "max-collected-headers-size",
+			opts:     []appsec.StartOption{appsec.WithMaxCollectedHeadersSize(1)},
+			detected: true,
+			check: func(t *testing.T, span agentSpan) {
+				require.NotContains(t, span.Meta, "http.request.headers.user-agent")
+			},
+		},
+		{
+			name: "event-enricher",
+			opts: []appsec.StartOption{appsec.WithEventEnricher(func(_ context.Context, span appsec.TagSetter, _ []json.RawMessage) {
+				enriched = true
+				span.SetTag("tenant", "acme")
+			})},
+			detected: true,
+			check: func(t *testing.T, span agentSpan) {
+				require.True(t, enriched)
+				require.Equal(t, "acme", span.Meta["tenant"])
+			},
+		},
+		{name: "not-sampled", opts: []appsec.StartOption{appsec.WithWAFSampleRate(0)}},
+		{
+			name:     "rules-from-files",
+			opts:     []appsec.StartOption{appsec.WithRulesFromFiles("../internal/appsec/testdata/blocking.json")},
+			clientIP: "1.2.3.4",
+			status:   http.StatusForbidden,
+			check: func(t *testing.T, span agentSpan) {
+				require.Contains(t, span.Meta["_dd.appsec.json"], "blk-001-001")
+			},
+		},
+		{
+			name:     "monitoring-event-keep-rate",
+			opts:     []appsec.StartOption{appsec.WithMonitoringEventKeepRate(0)},
+			detected: true,
+			check: func(t *testing.T, span agentSpan) {
+				// the security events no longer force-keep the trace
+				require.NotEqual(t, float64(2), span.Metrics["_sampling_priority_v1"])
+			},
+		},
+		{
+			name: "event-sink",
+			opts: []appsec.StartOption{appsec.WithEventSink(func(event appsec.SecurityEvent) {
+				events = append(events, event)
+			})},
+			detected: true,
+			check: func(t *testing.T, _ agentSpan) {
+				// stopping the tracer reports the pending security events to the sink
+				var ruleIDs []string
+				for _, e := range events {
+					ruleIDs = append(ruleIDs, e.RuleID)
+				}
+				require.Contains(t, ruleIDs, "crs-941-110")
+			},
+		},
+		{name: "waf-error-behavior", opts: []appsec.StartOption{appsec.WithWAFErrorBehavior(appsec.WAFFailClosed)}, detected: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stop := startTracer(t, tc.opts...)
+			if !privateAppsec.Enabled() {
+				stop()
+				t.Skip("AppSec needs to be enabled for this test")
+			}
+
+			mux := httptrace.NewServeMux()
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				if tc.body {
+					appsec.MonitorParsedHTTPBody(r.Context(), map[string]string{"x": attack})
+				}
+				w.Write([]byte("Hello World!\n"))
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+			// the trace of the first request monitored by the WAF is always kept
+			res, err := srv.Client().Get(srv.URL + "/first")
+			require.NoError(t, err)
+			res.Body.Close()
+
+			target := srv.URL + "/"
+			if !tc.body {
+				target += "?x=" + url.QueryEscape(attack)
+			}
+			req, err := http.NewRequest("GET", target, nil)
+			require.NoError(t, err)
+			if tc.clientIP != "" {
+				req.Header.Set("X-Forwarded-For", tc.clientIP)
+			}
+			res, err = srv.Client().Do(req)
+			require.NoError(t, err)
+			res.Body.Close()
+			spans := stop()
+
+			status := tc.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			require.Equal(t, status, res.StatusCode)
+			require.Len(t, spans, 2)
+			span := spans[1]
+			if strings.HasSuffix(span.Meta["http.url"], "/first") {
+				span = spans[0]
+			}
+			require.Equal(t, tc.detected, strings.Contains(span.Meta["_dd.appsec.json"], "crs-941-110"))
+			if tc.check != nil {
+				tc.check(t, span)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@
 package sarama_test
 
 import (
+	"context"
 	"log"
 
 	"github.com/Shopify/sarama"
@@ -82,3 +83,69 @@ func Example_consumer() {
 		consumed++
 	}
 }
+
+type consumerGroupHandler struct{}
+
+func (consumerGroupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (consumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		log.Printf("Consumed message offset %d\n", msg.Offset)
+		session.MarkMessage(msg, "")
+	}
+	return nil
+}
+
+func Example_consumerGroup() {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_11_0_0 // minimum version that supports headers which are required for tracing
+
+	group, err := sarama.NewConsumerGroup([]string{"localhost:9092"}, "some-group", cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer group.Close()
+
+	handler := saramatrace.WrapConsumerGroupHandler(consumerGroupHandler{}, saramatrace.WithGroupID("some-group"))
+	for {
+		if err := group.Consume(context.Background(), []string{"some-topic"}, handler); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func ExampleLinkProduceConsume() {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_11_0_0 // minimum version that supports headers which are required for tracing
+	cfg.Producer.Return.Successes = true
+
+	producer, err := sarama.NewSyncProducer([]string{"localhost:9092"}, cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer producer.Close()
+	producer = saramatrace.WrapSyncProducer(cfg, producer)
+
+	produced := &sarama.ProducerMessage{
+		Topic: "some-topic",
+		Value: sarama.StringEncoder("Hello World"),
+	}
+	if _, _, err := producer.SendMessage(produced); err != nil {
+		panic(err)
+	}
+
+	// the message is handed over in-process, without going through Kafka:
+	// copy the span context injected by the producer so that the span
+	// extracted from the consumed message is a child of the produce span.
+	consumed := &sarama.ConsumerMessage{
+		Topic: produced.Topic,
+		Value: []byte("Hello World"),
+	}
+	saramatrace.LinkProduceConsume(produced, consumed)
+
+	if spanctx, err := tracer.Extract(saramatrace.NewConsumerMessageCarrier(consumed)); err == nil {
+		span := tracer.StartSpan("process.message", tracer.ChildOf(spanctx))
+		defer span.Finish()
+	}
+}
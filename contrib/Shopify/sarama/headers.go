@@ -6,6 +6,9 @@
 package sarama
 
 import (
+	"strconv"
+	"strings"
+
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 
 	"github.com/Shopify/sarama"
@@ -94,3 +97,99 @@ func (c ConsumerMessageCarrier) Set(key, val string) {
 		Value: []byte(val),
 	})
 }
+
+// LinkProduceConsume copies the headers of the produced message into the headers
+// of the consumed message, replacing the ones having the same key. The span
+// context injected into the produced message by a traced producer, including
+// with a header key prefix, is then extracted from the consumed message by a
+// traced consumer, so that the consume span is a child of the produce span.
+// It allows linking both spans when the consumed message isn't received from
+// Kafka with its headers, such as with the sarama mocks in tests, or when
+// converting a sarama.ProducerMessage into a sarama.ConsumerMessage in-process.
+// The header keys and values are copied, so that the messages don't share them.
+func LinkProduceConsume(produced *sarama.ProducerMessage, consumed *sarama.ConsumerMessage) {
+	carrier := NewConsumerMessageCarrier(consumed)
+	for _, h := range produced.Headers {
+		carrier.Set(string(h.Key), string(h.Value))
+	}
+}
+
+// textMapCarrier is implemented by the message carriers.
+type textMapCarrier interface {
+	tracer.TextMapReader
+	tracer.TextMapWriter
+}
+
+// prefixedCarrier namespaces the header keys of the underlying carrier with a
+// prefix: the keys are prefixed when injecting, and only the prefixed keys are
+// read, with their prefix removed, when extracting.
+type prefixedCarrier struct {
+	prefix  string
+	carrier textMapCarrier
+}
+
+// ForeachKey iterates over every header having the carrier prefix.
+func (c prefixedCarrier) ForeachKey(handler func(key, val string) error) error {
+	return c.carrier.ForeachKey(func(key, val string) error {
+		if !strings.HasPrefix(key, c.prefix) {
+			return nil
+		}
+		return handler(strings.TrimPrefix(key, c.prefix), val)
+	})
+}
+
+// Set sets a header with the carrier prefix.
+func (c prefixedCarrier) Set(key, val string) {
+	c.carrier.Set(c.prefix+key, val)
+}
+
+// wrapCarrier returns the carrier to use to propagate the span contexts
+// according to the given config.
+func wrapCarrier(cfg *config, carrier textMapCarrier) textMapCarrier {
+	if cfg.headerPrefix == "" {
+		return carrier
+	}
+	return prefixedCarrier{prefix: cfg.headerPrefix, carrier: carrier}
+}
+
+// samplingPriority returns the sampling priority propagated with the Datadog
+// headers of the given carrier, if any.
+func samplingPriority(carrier textMapCarrier) (p int, ok bool) {
+	carrier.ForeachKey(func(key, val string) error {
+		if strings.EqualFold(key, tracer.DefaultPriorityHeader) {
+			if v, err := strconv.Atoi(val); err == nil {
+				p, ok = v, true
+			}
+		}
+		return nil
+	})
+	return p, ok
+}
+
+// headerTags returns the span start options tagging the span with the values of
+// the headers of the given carrier specified by WithHeaderTags. The values of
+// the headers found several times are joined with commas.
+func headerTags(cfg *config, carrier tracer.TextMapReader) []tracer.StartSpanOption {
+	if len(cfg.headerTags) == 0 {
+		return nil
+	}
+	var (
+		keys   []string
+		values = make(map[string][]string)
+	)
+	carrier.ForeachKey(func(key, val string) error {
+		if _, ok := cfg.headerTags[key]; !ok {
+			return nil
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = append(values[key], val)
+		return nil
+	})
+	opts := make([]tracer.StartSpanOption, 0, len(keys))
+	for _, k := range keys {
+		opts = append(opts, tracer.Tag(cfg.headerTags[k], strings.Join(values[k], ",")))
+	}
+	return opts
+}
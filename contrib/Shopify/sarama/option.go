@@ -7,9 +7,14 @@ package sarama
 
 import (
 	"math"
+	"os"
+	"strconv"
+	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/internal"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/namingschema"
+
+	"github.com/Shopify/sarama"
 )
 
 type config struct {
@@ -18,8 +23,27 @@ type config struct {
 	consumerOperationName string
 	producerOperationName string
 	analyticsRate         float64
+	metadataTag           func(metadata interface{}) (key string, value interface{}, ok bool)
+	initialOffset         string // initial offset of the partition consumer, empty when unknown
+	headerPrefix          string // prefix of the message header keys holding the span context
+	partitionCounts       *partitionCounts
+	consumerResourceNamer func(topic string, partition int32) string
+	resourceNamer         func(topic, op string) string
+	errCheck              func(err error) bool
+	inheritPriority       bool // force the sampling priority propagated by the producer on consumer spans
+	producerConfigTags    bool // tag the producer spans with the producer reliability configuration
+	// consumerProtocolVersion is the Kafka protocol version tagged on the consumer spans, if set.
+	consumerProtocolVersion string
+	messageSizeTag          bool   // tag the spans with the size of the message key and value
+	groupID                 string // consumer group ID tagged on the consumer spans, if set
+	// headerTags maps the keys of the message headers tagged on the spans to their tag name.
+	headerTags map[string]string
 }
 
+// envServiceName is the env var setting the default service name of the
+// Kafka spans, distinct from the service name of the application.
+const envServiceName = "DD_KAFKA_SERVICE"
+
 func defaults(cfg *config) {
 	cfg.consumerServiceName = namingschema.NewServiceNameSchema("", "kafka").GetName()
 	cfg.producerServiceName = namingschema.NewServiceNameSchema(
@@ -27,6 +51,10 @@ func defaults(cfg *config) {
 		"kafka",
 		namingschema.WithVersionOverride(namingschema.SchemaV0, "kafka"),
 	).GetName()
+	if svc := os.Getenv(envServiceName); svc != "" {
+		cfg.consumerServiceName = svc
+		cfg.producerServiceName = svc
+	}
 
 	cfg.consumerOperationName = namingschema.NewKafkaInboundOp().GetName()
 	cfg.producerOperationName = namingschema.NewKafkaOutboundOp().GetName()
@@ -42,7 +70,10 @@ func defaults(cfg *config) {
 // An Option is used to customize the config for the sarama tracer.
 type Option func(cfg *config)
 
-// WithServiceName sets the given service name for the intercepted client.
+// WithServiceName sets the given service name for the intercepted client. It
+// takes precedence over the DD_KAFKA_SERVICE env var, which sets the default
+// service name of the Kafka spans without code changes, and which itself takes
+// precedence over the global service name.
 func WithServiceName(name string) Option {
 	return func(cfg *config) {
 		cfg.consumerServiceName = name
@@ -72,3 +103,165 @@ func WithAnalyticsRate(rate float64) Option {
 		}
 	}
 }
+
+// WithMetadataTag specifies a function fn which extracts a span tag from the
+// Metadata field of produced messages. The tag is set on the producer span
+// only when fn reports ok.
+func WithMetadataTag(fn func(metadata interface{}) (key string, value interface{}, ok bool)) Option {
+	return func(cfg *config) {
+		cfg.metadataTag = fn
+	}
+}
+
+// WithHeaderKeyPrefix sets a prefix to the keys of the message headers used to
+// propagate the span context, so that they don't collide with the application
+// headers. Only the headers with this prefix are used to extract span contexts,
+// so the producers and consumers of a topic must use the same prefix.
+func WithHeaderKeyPrefix(prefix string) Option {
+	return func(cfg *config) {
+		cfg.headerPrefix = prefix
+	}
+}
+
+// WithPartitionCount enables tagging producer spans with the number of
+// partitions of the topic the message is sent to, as "kafka.partition_count".
+// The partition counts are read from the metadata of the given client, and
+// cached for 5 minutes per topic. When the client fails to get them, the tag
+// is skipped and the client is not queried again for that topic before 30
+// seconds.
+func WithPartitionCount(client sarama.Client) Option {
+	return func(cfg *config) {
+		cfg.partitionCounts = &partitionCounts{
+			client:   client,
+			counts:   make(map[string]partitionCount),
+			failures: make(map[string]time.Time),
+		}
+	}
+}
+
+// WithConsumerResourceNamer specifies a function fn which returns the resource
+// name of the consumer spans of the messages received from the given topic
+// partition. The resource name given by WithResourceNamer, or the default
+// "Consume Topic <topic>" one, is used when fn returns an empty string.
+func WithConsumerResourceNamer(fn func(topic string, partition int32) string) Option {
+	return func(cfg *config) {
+		cfg.consumerResourceNamer = fn
+	}
+}
+
+// WithResourceNamer specifies a function fn which returns the resource name of
+// the producer and consumer spans of the given topic, op being either
+// OperationProduce or OperationConsume. The default "<op> Topic <topic>"
+// resource name, such as "Produce Topic orders", is used when fn returns an
+// empty string. For consumer spans, the resource namer of
+// WithConsumerResourceNamer takes precedence.
+func WithResourceNamer(fn func(topic, op string) string) Option {
+	return func(cfg *config) {
+		cfg.resourceNamer = fn
+	}
+}
+
+// WithErrorCheck specifies a function fn which determines whether the passed
+// error returned by the producer should mark the producer span as an error,
+// such as to ignore the retriable errors like sarama.ErrNotLeaderForPartition.
+// The fn is called with the error returned by the SendMessage and SendMessages
+// methods of the sync producer, the latter being a sarama.ProducerErrors, and
+// with the Err field of the sarama.ProducerError received from the Errors
+// channel of the async producer. All errors are marked when it isn't set.
+func WithErrorCheck(fn func(err error) bool) Option {
+	return func(cfg *config) {
+		cfg.errCheck = fn
+	}
+}
+
+// WithInheritedSamplingPriority forces the sampling priority propagated in the
+// message headers by the producer on the consumer spans, so that the traces
+// going through Kafka are consistently kept or dropped end-to-end. Consumer
+// spans already inherit the sampling priority of the extracted span context
+// by default, but this option sets it as a manual decision, taking precedence
+// over any sampling rule of the consumer. Only the sampling priority propagated
+// with the Datadog headers is supported.
+func WithInheritedSamplingPriority() Option {
+	return func(cfg *config) {
+		cfg.inheritPriority = true
+	}
+}
+
+// WithProducerConfigTags tags the producer spans with the reliability
+// configuration of the producer, read from its sarama Config: the required
+// acknowledgements, as "kafka.required_acks" (0 for none, 1 for the leader
+// only, and -1 for all the in-sync replicas), the maximum number of retries, as
+// "kafka.max_retries", whether the producer is idempotent, providing
+// exactly-once delivery per partition, as "kafka.idempotent", and the Kafka
+// protocol version the producer uses, as "kafka.protocol_version".
+func WithProducerConfigTags() Option {
+	return func(cfg *config) {
+		cfg.producerConfigTags = true
+	}
+}
+
+// WithMessageSizeTag enables tagging the producer and consumer spans with the
+// size in bytes of the message key and value, as "messaging.kafka.message_size".
+// The size of produced messages is given by the Length method of their key and
+// value sarama.Encoder, which doesn't encode them again for the encoders of the
+// sarama package, such as sarama.StringEncoder and sarama.ByteEncoder, but may
+// do so for custom encoders. The headers aren't accounted for.
+func WithMessageSizeTag(on bool) Option {
+	return func(cfg *config) {
+		cfg.messageSizeTag = on
+	}
+}
+
+// WithGroupID tags the consumer spans with the given consumer group ID, as
+// "messaging.kafka.consumer_group". It is meant to be given to
+// WrapConsumerGroupHandler with the group ID the sarama.ConsumerGroup was
+// created with.
+func WithGroupID(groupID string) Option {
+	return func(cfg *config) {
+		cfg.groupID = groupID
+	}
+}
+
+// WithHeaderTags tags the producer and consumer spans with the values of the
+// message headers having the given keys, as "messaging.kafka.header.<key>",
+// such as the correlation IDs or routing metadata set by the application. The
+// header keys are case-sensitive, and the values of the headers found several
+// times in a message are joined with commas.
+// Warning: the headers given must have a low cardinality and must not hold
+// sensitive data, such as personal information or authorization tokens, which
+// would otherwise be sent to Datadog.
+func WithHeaderTags(headerKeys []string) Option {
+	return func(cfg *config) {
+		cfg.headerTags = make(map[string]string, len(headerKeys))
+		for _, k := range headerKeys {
+			cfg.headerTags[k] = "messaging.kafka.header." + k
+		}
+	}
+}
+
+// WithConsumerConfigTags tags the consumer spans with the configuration of the
+// consumer, read from the given sarama Config since sarama consumers don't
+// expose it: the Kafka protocol version the consumer uses, which determines
+// the protocol features available, as "kafka.protocol_version".
+func WithConsumerConfigTags(saramaConfig *sarama.Config) Option {
+	return func(cfg *config) {
+		if saramaConfig != nil {
+			cfg.consumerProtocolVersion = saramaConfig.Version.String()
+		}
+	}
+}
+
+// withInitialOffset sets the initial offset the partition consumer started
+// consuming from.
+func withInitialOffset(offset int64) Option {
+	return func(cfg *config) {
+		switch offset {
+		case sarama.OffsetOldest:
+			cfg.initialOffset = "oldest"
+		case sarama.OffsetNewest:
+			cfg.initialOffset = "newest"
+		default:
+			cfg.initialOffset = strconv.FormatInt(offset, 10)
+		}
+	}
+}
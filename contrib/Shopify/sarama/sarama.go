@@ -8,6 +8,8 @@ package sarama // import "gopkg.in/DataDog/dd-trace-go.v1/contrib/Shopify/sarama
 
 import (
 	"math"
+	"sync"
+	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
@@ -20,6 +22,22 @@ import (
 
 const componentName = "Shopify/sarama"
 
+// messageSizeTag is the tag holding the size in bytes of the message key and
+// value, set by WithMessageSizeTag.
+const messageSizeTag = "messaging.kafka.message_size"
+
+// The operations given to the resource namer of WithResourceNamer.
+const (
+	// OperationConsume is the operation of the consumer spans.
+	OperationConsume = "Consume"
+	// OperationProduce is the operation of the producer spans.
+	OperationProduce = "Produce"
+)
+
+// consumerGroupTag is the tag holding the consumer group ID, set by
+// WithGroupID.
+const consumerGroupTag = "messaging.kafka.consumer_group"
+
 func init() {
 	telemetry.LoadIntegration("Shopify/sarama")
 }
@@ -53,28 +71,7 @@ func WrapPartitionConsumer(pc sarama.PartitionConsumer, opts ...Option) sarama.P
 		var prev ddtrace.Span
 		for msg := range msgs {
 			// create the next span from the message
-			opts := []tracer.StartSpanOption{
-				tracer.ServiceName(cfg.consumerServiceName),
-				tracer.ResourceName("Consume Topic " + msg.Topic),
-				tracer.SpanType(ext.SpanTypeMessageConsumer),
-				tracer.Tag(ext.MessagingKafkaPartition, msg.Partition),
-				tracer.Tag("offset", msg.Offset),
-				tracer.Tag(ext.Component, componentName),
-				tracer.Tag(ext.SpanKind, ext.SpanKindConsumer),
-				tracer.Tag(ext.MessagingSystem, "kafka"),
-				tracer.Measured(),
-			}
-			if !math.IsNaN(cfg.analyticsRate) {
-				opts = append(opts, tracer.Tag(ext.EventSampleRate, cfg.analyticsRate))
-			}
-			// kafka supports headers, so try to extract a span context
-			carrier := NewConsumerMessageCarrier(msg)
-			if spanctx, err := tracer.Extract(carrier); err == nil {
-				opts = append(opts, tracer.ChildOf(spanctx))
-			}
-			next := tracer.StartSpan(cfg.consumerOperationName, opts...)
-			// reinject the span context so consumers can pick it up
-			tracer.Inject(next.Context(), carrier)
+			next := startConsumerSpan(cfg, msg)
 
 			wrapped.messages <- msg
 
@@ -93,6 +90,62 @@ func WrapPartitionConsumer(pc sarama.PartitionConsumer, opts ...Option) sarama.P
 	return wrapped
 }
 
+// startConsumerSpan starts the consumer span of the given message, as a child
+// of the span context extracted from the message headers, if any.
+func startConsumerSpan(cfg *config, msg *sarama.ConsumerMessage) ddtrace.Span {
+	resource := resourceName(cfg, msg.Topic, OperationConsume)
+	if cfg.consumerResourceNamer != nil {
+		if name := cfg.consumerResourceNamer(msg.Topic, msg.Partition); name != "" {
+			resource = name
+		}
+	}
+	opts := []tracer.StartSpanOption{
+		tracer.ServiceName(cfg.consumerServiceName),
+		tracer.ResourceName(resource),
+		tracer.SpanType(ext.SpanTypeMessageConsumer),
+		tracer.Tag(ext.MessagingKafkaPartition, msg.Partition),
+		tracer.Tag("offset", msg.Offset),
+		tracer.Tag(ext.Component, componentName),
+		tracer.Tag(ext.SpanKind, ext.SpanKindConsumer),
+		tracer.Tag(ext.MessagingSystem, "kafka"),
+		tracer.Measured(),
+	}
+	if !math.IsNaN(cfg.analyticsRate) {
+		opts = append(opts, tracer.Tag(ext.EventSampleRate, cfg.analyticsRate))
+	}
+	if cfg.initialOffset != "" {
+		opts = append(opts, tracer.Tag("kafka.initial_offset", cfg.initialOffset))
+	}
+	if cfg.groupID != "" {
+		opts = append(opts, tracer.Tag(consumerGroupTag, cfg.groupID))
+	}
+	if cfg.consumerProtocolVersion != "" {
+		opts = append(opts, tracer.Tag("kafka.protocol_version", cfg.consumerProtocolVersion))
+	}
+	if cfg.messageSizeTag {
+		opts = append(opts, tracer.Tag(messageSizeTag, len(msg.Key)+len(msg.Value)))
+	}
+	if msg.Value == nil {
+		// messages without value are deletion markers of compacted topics
+		opts = append(opts, tracer.Tag("kafka.tombstone", true))
+	}
+	opts = append(opts, headerTags(cfg, NewConsumerMessageCarrier(msg))...)
+	// kafka supports headers, so try to extract a span context
+	carrier := wrapCarrier(cfg, NewConsumerMessageCarrier(msg))
+	if spanctx, err := tracer.Extract(carrier); err == nil {
+		opts = append(opts, tracer.ChildOf(spanctx))
+		if cfg.inheritPriority {
+			if p, ok := samplingPriority(carrier); ok {
+				opts = append(opts, tracer.Tag(ext.SamplingPriority, p))
+			}
+		}
+	}
+	span := tracer.StartSpan(cfg.consumerOperationName, opts...)
+	// reinject the span context so consumers can pick it up
+	tracer.Inject(span.Context(), carrier)
+	return span
+}
+
 type consumer struct {
 	sarama.Consumer
 	opts []Option
@@ -105,7 +158,10 @@ func (c *consumer) ConsumePartition(topic string, partition int32, offset int64)
 	if err != nil {
 		return pc, err
 	}
-	return WrapPartitionConsumer(pc, c.opts...), nil
+	// limit the capacity of c.opts so that append copies it instead of sharing
+	// its backing array between concurrent calls
+	opts := append(c.opts[:len(c.opts):len(c.opts)], withInitialOffset(offset))
+	return WrapPartitionConsumer(pc, opts...), nil
 }
 
 // WrapConsumer wraps a sarama.Consumer wrapping any PartitionConsumer created
@@ -117,31 +173,148 @@ func WrapConsumer(c sarama.Consumer, opts ...Option) sarama.Consumer {
 	}
 }
 
+type consumerGroupHandler struct {
+	sarama.ConsumerGroupHandler
+	cfg *config
+}
+
+// ConsumeClaim invokes ConsumerGroupHandler.ConsumeClaim with a claim whose
+// received messages are traced.
+func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	wrapped := &consumerGroupClaim{
+		ConsumerGroupClaim: claim,
+		messages:           make(chan *sarama.ConsumerMessage),
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		wrapped.trace(h.cfg, done)
+	}()
+	defer func() {
+		// stop tracing the messages and finish the remaining span once the
+		// handler no longer consumes the claim
+		close(done)
+		<-stopped
+	}()
+	return h.ConsumerGroupHandler.ConsumeClaim(session, wrapped)
+}
+
+type consumerGroupClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+// Messages returns the read channel for the messages that are returned by
+// the broker.
+func (c *consumerGroupClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// trace forwards the messages of the claim, starting a span for each of them,
+// until the claim's messages channel is closed, or done is closed.
+func (c *consumerGroupClaim) trace(cfg *config, done <-chan struct{}) {
+	msgs := c.ConsumerGroupClaim.Messages()
+	var prev ddtrace.Span
+	defer func() {
+		// finish any remaining span
+		if prev != nil {
+			prev.Finish()
+		}
+	}()
+	for {
+		var msg *sarama.ConsumerMessage
+		select {
+		case m, ok := <-msgs:
+			if !ok {
+				close(c.messages)
+				return
+			}
+			msg = m
+		case <-done:
+			return
+		}
+		next := startConsumerSpan(cfg, msg)
+		select {
+		case c.messages <- msg:
+		case <-done:
+			next.Finish()
+			return
+		}
+		// if the next message was received, finish the previous span
+		if prev != nil {
+			prev.Finish()
+		}
+		prev = next
+	}
+}
+
+// WrapConsumerGroupHandler wraps a sarama.ConsumerGroupHandler causing each
+// message received by its ConsumeClaim method to be traced, like the messages
+// of WrapPartitionConsumer. The span of a message is finished when the next
+// message is received, or when ConsumeClaim returns. The Setup and Cleanup
+// methods are called as is. Use WithGroupID to tag the spans with the consumer
+// group ID, which sarama doesn't expose to the handler.
+func WrapConsumerGroupHandler(handler sarama.ConsumerGroupHandler, opts ...Option) sarama.ConsumerGroupHandler {
+	cfg := new(config)
+	defaults(cfg)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	log.Debug("contrib/Shopify/sarama: Wrapping Consumer Group Handler: %#v", cfg)
+	return &consumerGroupHandler{
+		ConsumerGroupHandler: handler,
+		cfg:                  cfg,
+	}
+}
+
 type syncProducer struct {
 	sarama.SyncProducer
-	version sarama.KafkaVersion
-	cfg     *config
+	saramaConfig *sarama.Config
+	cfg          *config
 }
 
 // SendMessage calls sarama.SyncProducer.SendMessage and traces the request.
 func (p *syncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
-	span := startProducerSpan(p.cfg, p.version, msg)
+	span := startProducerSpan(p.cfg, p.saramaConfig, msg, nil)
 	partition, offset, err = p.SyncProducer.SendMessage(msg)
-	finishProducerSpan(span, partition, offset, err)
+	finishProducerSpan(p.cfg, span, partition, offset, err)
 	return partition, offset, err
 }
 
 // SendMessages calls sarama.SyncProducer.SendMessages and traces the requests.
+// The whole call is traced by a "kafka.produce_batch" span, tagged with the
+// number of messages and of distinct topics of the batch, which is the parent
+// of the span of each message.
 func (p *syncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	batch := startProducerBatchSpan(p.cfg, msgs)
 	// although there's only one call made to the SyncProducer, the messages are
 	// treated individually, so we create a span for each one
 	spans := make([]ddtrace.Span, len(msgs))
 	for i, msg := range msgs {
-		spans[i] = startProducerSpan(p.cfg, p.version, msg)
+		spans[i] = startProducerSpan(p.cfg, p.saramaConfig, msg, batch.Context())
 	}
 	err := p.SyncProducer.SendMessages(msgs)
+	// the error returned when only some of the messages failed lists them with
+	// their own error, in which case the other messages were sent
+	var msgErrs map[*sarama.ProducerMessage]error
+	if perrs, ok := err.(sarama.ProducerErrors); ok {
+		msgErrs = make(map[*sarama.ProducerMessage]error, len(perrs))
+		for _, perr := range perrs {
+			msgErrs[perr.Msg] = perr.Err
+		}
+	}
 	for i, span := range spans {
-		finishProducerSpan(span, msgs[i].Partition, msgs[i].Offset, err)
+		msgErr := err
+		if msgErrs != nil {
+			msgErr = msgErrs[msgs[i]]
+		}
+		finishProducerSpan(p.cfg, span, msgs[i].Partition, msgs[i].Offset, msgErr)
+	}
+	if isProducerError(p.cfg, err) {
+		batch.Finish(tracer.WithError(err))
+	} else {
+		batch.Finish()
 	}
 	return err
 }
@@ -160,11 +333,19 @@ func WrapSyncProducer(saramaConfig *sarama.Config, producer sarama.SyncProducer,
 	}
 	return &syncProducer{
 		SyncProducer: producer,
-		version:      saramaConfig.Version,
+		saramaConfig: saramaConfig,
 		cfg:          cfg,
 	}
 }
 
+// WrapSyncProducerFromClient wraps a sarama.SyncProducer created from the given
+// client, such as with sarama.NewSyncProducerFromClient, so that all produced
+// messages are traced. The client configuration is used to know whether the
+// span context can be injected into the message headers.
+func WrapSyncProducerFromClient(client sarama.Client, producer sarama.SyncProducer, opts ...Option) sarama.SyncProducer {
+	return WrapSyncProducer(client.Config(), producer, opts...)
+}
+
 type asyncProducer struct {
 	sarama.AsyncProducer
 	input     chan *sarama.ProducerMessage
@@ -192,6 +373,14 @@ func (p *asyncProducer) Errors() <-chan *sarama.ProducerError {
 // or not successes will be returned. Tracing requires at least sarama.V0_11_0_0
 // version which is the first version that supports headers. Only spans of
 // successfully published messages have partition and offset tags set.
+//
+// In the default fire-and-forget mode, when Producer.Return.Successes isn't
+// enabled, the produce spans are finished as soon as the messages are handed
+// over to the underlying producer, since their delivery is never reported. They
+// thus don't measure the delivery and aren't tagged with the partition and the
+// offset, which aren't available in this mode. The spans of the messages whose
+// delivery wasn't reported yet when the producer is closed are finished when it
+// is closed.
 func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts ...Option) sarama.AsyncProducer {
 	cfg := new(config)
 	defaults(cfg)
@@ -213,13 +402,20 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 	}
 	go func() {
 		spans := make(map[uint64]ddtrace.Span)
+		defer func() {
+			// finish the spans of the messages whose delivery wasn't reported
+			// before the producer was closed, so that they don't leak
+			for _, span := range spans {
+				span.Finish()
+			}
+		}()
 		defer close(wrapped.input)
 		defer close(wrapped.successes)
 		defer close(wrapped.errors)
 		for {
 			select {
 			case msg := <-wrapped.input:
-				span := startProducerSpan(cfg, saramaConfig.Version, msg)
+				span := startProducerSpan(cfg, saramaConfig, msg, nil)
 				p.Input() <- msg
 				if saramaConfig.Producer.Return.Successes {
 					spanID := span.Context().SpanID()
@@ -227,7 +423,7 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 				} else {
 					// if returning successes isn't enabled, we just finish the
 					// span right away because there's no way to know when it will
-					// be done
+					// be done, nor to which partition and offset it was sent
 					span.Finish()
 				}
 			case msg, ok := <-p.Successes():
@@ -235,11 +431,11 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 					// producer was closed, so exit
 					return
 				}
-				if spanctx, spanFound := getSpanContext(msg); spanFound {
+				if spanctx, spanFound := getSpanContext(cfg, msg); spanFound {
 					spanID := spanctx.SpanID()
 					if span, ok := spans[spanID]; ok {
 						delete(spans, spanID)
-						finishProducerSpan(span, msg.Partition, msg.Offset, nil)
+						finishProducerSpan(cfg, span, msg.Partition, msg.Offset, nil)
 					}
 				}
 				wrapped.successes <- msg
@@ -248,11 +444,15 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 					// producer was closed
 					return
 				}
-				if spanctx, spanFound := getSpanContext(err.Msg); spanFound {
+				if spanctx, spanFound := getSpanContext(cfg, err.Msg); spanFound {
 					spanID := spanctx.SpanID()
 					if span, ok := spans[spanID]; ok {
 						delete(spans, spanID)
-						span.Finish(tracer.WithError(err))
+						if isProducerError(cfg, err.Err) {
+							span.Finish(tracer.WithError(err))
+						} else {
+							span.Finish()
+						}
 					}
 				}
 				wrapped.errors <- err
@@ -262,39 +462,189 @@ func WrapAsyncProducer(saramaConfig *sarama.Config, p sarama.AsyncProducer, opts
 	return wrapped
 }
 
-func startProducerSpan(cfg *config, version sarama.KafkaVersion, msg *sarama.ProducerMessage) ddtrace.Span {
-	carrier := NewProducerMessageCarrier(msg)
+// resourceName returns the resource name of the spans of the given operation
+// on the given topic, as given by the resource namer of the config, if any, or
+// "<op> Topic <topic>" otherwise.
+func resourceName(cfg *config, topic, op string) string {
+	if cfg.resourceNamer != nil {
+		if name := cfg.resourceNamer(topic, op); name != "" {
+			return name
+		}
+	}
+	return op + " Topic " + topic
+}
+
+// startProducerBatchSpan starts the span of a batch of messages sent at once,
+// as a child of the span context extracted from the headers of the first
+// message having one, if any.
+func startProducerBatchSpan(cfg *config, msgs []*sarama.ProducerMessage) ddtrace.Span {
+	topics := make(map[string]struct{})
+	for _, msg := range msgs {
+		topics[msg.Topic] = struct{}{}
+	}
 	opts := []tracer.StartSpanOption{
 		tracer.ServiceName(cfg.producerServiceName),
-		tracer.ResourceName("Produce Topic " + msg.Topic),
+		tracer.ResourceName("Produce Batch"),
 		tracer.SpanType(ext.SpanTypeMessageProducer),
 		tracer.Tag(ext.Component, componentName),
 		tracer.Tag(ext.SpanKind, ext.SpanKindProducer),
 		tracer.Tag(ext.MessagingSystem, "kafka"),
+		tracer.Tag("messaging.batch.message_count", len(msgs)),
+		tracer.Tag("messaging.kafka.topic_count", len(topics)),
 	}
 	if !math.IsNaN(cfg.analyticsRate) {
 		opts = append(opts, tracer.Tag(ext.EventSampleRate, cfg.analyticsRate))
 	}
-	// if there's a span context in the headers, use that as the parent
-	if spanctx, err := tracer.Extract(carrier); err == nil {
+	for _, msg := range msgs {
+		if spanctx, err := tracer.Extract(wrapCarrier(cfg, NewProducerMessageCarrier(msg))); err == nil {
+			opts = append(opts, tracer.ChildOf(spanctx))
+			break
+		}
+	}
+	return tracer.StartSpan("kafka.produce_batch", opts...)
+}
+
+// startProducerSpan starts the producer span of the given message, as a child
+// of the given parent if not nil, or of the span context extracted from the
+// message headers otherwise, if any.
+func startProducerSpan(cfg *config, saramaConfig *sarama.Config, msg *sarama.ProducerMessage, parent ddtrace.SpanContext) ddtrace.Span {
+	carrier := wrapCarrier(cfg, NewProducerMessageCarrier(msg))
+	opts := []tracer.StartSpanOption{
+		tracer.ServiceName(cfg.producerServiceName),
+		tracer.ResourceName(resourceName(cfg, msg.Topic, OperationProduce)),
+		tracer.SpanType(ext.SpanTypeMessageProducer),
+		tracer.Tag(ext.Component, componentName),
+		tracer.Tag(ext.SpanKind, ext.SpanKindProducer),
+		tracer.Tag(ext.MessagingSystem, "kafka"),
+		tracer.Tag("kafka.compression", saramaConfig.Producer.Compression.String()),
+	}
+	if !math.IsNaN(cfg.analyticsRate) {
+		opts = append(opts, tracer.Tag(ext.EventSampleRate, cfg.analyticsRate))
+	}
+	if cfg.metadataTag != nil {
+		if k, v, ok := cfg.metadataTag(msg.Metadata); ok {
+			opts = append(opts, tracer.Tag(k, v))
+		}
+	}
+	if cfg.producerConfigTags {
+		opts = append(opts,
+			tracer.Tag("kafka.required_acks", int(saramaConfig.Producer.RequiredAcks)),
+			tracer.Tag("kafka.max_retries", saramaConfig.Producer.Retry.Max),
+			tracer.Tag("kafka.idempotent", saramaConfig.Producer.Idempotent),
+			tracer.Tag("kafka.protocol_version", saramaConfig.Version.String()))
+	}
+	if cfg.messageSizeTag {
+		opts = append(opts, tracer.Tag(messageSizeTag, producerMessageSize(msg)))
+	}
+	if cfg.partitionCounts != nil {
+		if n, ok := cfg.partitionCounts.get(msg.Topic); ok {
+			opts = append(opts, tracer.Tag("kafka.partition_count", n))
+		}
+	}
+	opts = append(opts, headerTags(cfg, NewProducerMessageCarrier(msg))...)
+	if parent != nil {
+		opts = append(opts, tracer.ChildOf(parent))
+	} else if spanctx, err := tracer.Extract(carrier); err == nil {
+		// if there's a span context in the headers, use that as the parent
 		opts = append(opts, tracer.ChildOf(spanctx))
 	}
 	span := tracer.StartSpan(cfg.producerOperationName, opts...)
-	if version.IsAtLeast(sarama.V0_11_0_0) {
+	if saramaConfig.Version.IsAtLeast(sarama.V0_11_0_0) {
 		// re-inject the span context so consumers can pick it up
 		tracer.Inject(span.Context(), carrier)
 	}
 	return span
 }
 
-func finishProducerSpan(span ddtrace.Span, partition int32, offset int64, err error) {
-	span.SetTag(ext.MessagingKafkaPartition, partition)
-	span.SetTag("offset", offset)
+// partitionCountRetryInterval is how long the partition count of a topic is
+// not tagged after the client failed to get it, before getting it again.
+var partitionCountRetryInterval = 30 * time.Second
+
+// partitionCountTTL is how long the partition count of a topic is cached,
+// before getting it again to pick up the partitions added to the topic.
+var partitionCountTTL = 5 * time.Minute
+
+// partitionCount is the number of partitions of a topic, along with the time
+// it was got at.
+type partitionCount struct {
+	n  int
+	at time.Time
+}
+
+// partitionCounts caches the number of partitions of the topics, as known by
+// the client metadata, as well as the failures to get them.
+type partitionCounts struct {
+	client   sarama.Client
+	mu       sync.RWMutex
+	counts   map[string]partitionCount
+	failures map[string]time.Time // time of the last failure per topic
+}
+
+// get returns the number of partitions of the given topic. It doesn't query
+// the client again for partitionCountTTL after it got it, nor for
+// partitionCountRetryInterval after it failed to get it, so that producing to
+// topics with unavailable metadata isn't slowed down.
+func (p *partitionCounts) get(topic string) (int, bool) {
+	p.mu.RLock()
+	c, ok := p.counts[topic]
+	failed, hasFailed := p.failures[topic]
+	p.mu.RUnlock()
+	if ok && time.Since(c.at) < partitionCountTTL {
+		return c.n, true
+	}
+	if hasFailed && time.Since(failed) < partitionCountRetryInterval {
+		return 0, false
+	}
+	partitions, err := p.client.Partitions(topic)
+	if err != nil {
+		log.Debug("contrib/Shopify/sarama: failed to get the partitions of topic %s: %v", topic, err)
+		p.mu.Lock()
+		delete(p.counts, topic)
+		p.failures[topic] = time.Now()
+		p.mu.Unlock()
+		return 0, false
+	}
+	p.mu.Lock()
+	p.counts[topic] = partitionCount{n: len(partitions), at: time.Now()}
+	delete(p.failures, topic)
+	p.mu.Unlock()
+	return len(partitions), true
+}
+
+// producerMessageSize returns the size in bytes of the key and value of the
+// given message, as reported by the Length method of their encoders.
+func producerMessageSize(msg *sarama.ProducerMessage) int {
+	size := 0
+	if msg.Key != nil {
+		size += msg.Key.Length()
+	}
+	if msg.Value != nil {
+		size += msg.Value.Length()
+	}
+	return size
+}
+
+func finishProducerSpan(cfg *config, span ddtrace.Span, partition int32, offset int64, err error) {
+	// the partition and offset are meaningless when the message could not be sent
+	if err == nil {
+		span.SetTag(ext.MessagingKafkaPartition, partition)
+		span.SetTag("offset", offset)
+	}
+	if !isProducerError(cfg, err) {
+		err = nil
+	}
 	span.Finish(tracer.WithError(err))
 }
 
-func getSpanContext(msg *sarama.ProducerMessage) (ddtrace.SpanContext, bool) {
-	carrier := NewProducerMessageCarrier(msg)
+// isProducerError reports whether the given error returned by the producer
+// should mark the producer span as an error, according to the error check of
+// the config, if any.
+func isProducerError(cfg *config, err error) bool {
+	return err != nil && (cfg.errCheck == nil || cfg.errCheck(err))
+}
+
+func getSpanContext(cfg *config, msg *sarama.ProducerMessage) (ddtrace.SpanContext, bool) {
+	carrier := wrapCarrier(cfg, NewProducerMessageCarrier(msg))
 	spanctx, err := tracer.Extract(carrier)
 	if err != nil {
 		return nil, false
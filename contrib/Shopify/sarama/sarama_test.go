@@ -7,6 +7,10 @@ package sarama
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,6 +20,7 @@ import (
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 
 	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -138,6 +143,7 @@ func TestConsumer(t *testing.T) {
 
 		assert.Equal(t, int32(0), s.Tag(ext.MessagingKafkaPartition))
 		assert.Equal(t, int64(0), s.Tag("offset"))
+		assert.Equal(t, "0", s.Tag("kafka.initial_offset"))
 		assert.Equal(t, "kafka", s.Tag(ext.ServiceName))
 		assert.Equal(t, "Consume Topic test-topic", s.Tag(ext.ResourceName))
 		assert.Equal(t, "queue", s.Tag(ext.SpanType))
@@ -155,6 +161,7 @@ func TestConsumer(t *testing.T) {
 
 		assert.Equal(t, int32(0), s.Tag(ext.MessagingKafkaPartition))
 		assert.Equal(t, int64(1), s.Tag("offset"))
+		assert.Equal(t, "0", s.Tag("kafka.initial_offset"))
 		assert.Equal(t, "kafka", s.Tag(ext.ServiceName))
 		assert.Equal(t, "Consume Topic test-topic", s.Tag(ext.ResourceName))
 		assert.Equal(t, "queue", s.Tag(ext.SpanType))
@@ -165,6 +172,382 @@ func TestConsumer(t *testing.T) {
 	}
 }
 
+func TestConsumerTombstone(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	broker := sarama.NewMockBroker(t, 0)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("test-topic", 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("test-topic", 0, sarama.OffsetOldest, 0).
+			SetOffset("test-topic", 0, sarama.OffsetNewest, 1),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetMessage("test-topic", 0, 0, sarama.StringEncoder("hello")).
+			SetMessage("test-topic", 0, 1, sarama.ByteEncoder(nil)),
+	})
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.MinVersion
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	require.NoError(t, err)
+	defer client.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	require.NoError(t, err)
+	defer consumer.Close()
+
+	consumer = WrapConsumer(consumer)
+	partitionConsumer, err := consumer.ConsumePartition("test-topic", 0, 0)
+	require.NoError(t, err)
+	msg1 := <-partitionConsumer.Messages()
+	msg2 := <-partitionConsumer.Messages()
+	partitionConsumer.Close()
+	// wait for the channel to be closed
+	<-partitionConsumer.Messages()
+	require.NotNil(t, msg1.Value)
+	require.Nil(t, msg2.Value)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Nil(t, spans[0].Tag("kafka.tombstone"))
+	assert.Equal(t, true, spans[1].Tag("kafka.tombstone"))
+}
+
+func TestConsumerSamplingPriority(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "default"},
+		{name: "inherited", opts: []Option{WithInheritedSamplingPriority()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			// propagate a user keep sampling decision from the producer
+			parent := tracer.StartSpan("produce", tracer.Tag(ext.SamplingPriority, ext.PriorityUserKeep))
+			pmsg := &sarama.ProducerMessage{Topic: "test-topic"}
+			require.NoError(t, tracer.Inject(parent.Context(), NewProducerMessageCarrier(pmsg)))
+			parent.Finish()
+			headers := make([]*sarama.RecordHeader, len(pmsg.Headers))
+			for i := range pmsg.Headers {
+				headers[i] = &pmsg.Headers[i]
+			}
+
+			mc := mocks.NewConsumer(t, nil)
+			mc.ExpectConsumePartition("test-topic", 0, sarama.OffsetOldest).
+				YieldMessage(&sarama.ConsumerMessage{Topic: "test-topic", Headers: headers})
+			consumer := WrapConsumer(mc, tc.opts...)
+			pc, err := consumer.ConsumePartition("test-topic", 0, sarama.OffsetOldest)
+			require.NoError(t, err)
+			<-pc.Messages()
+			require.NoError(t, pc.Close())
+			// wait for the channel to be closed
+			<-pc.Messages()
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 2)
+			consume := spans[1]
+			assert.Equal(t, parent.Context().TraceID(), consume.TraceID())
+			assert.Equal(t, ext.PriorityUserKeep, consume.Tag(ext.SamplingPriority))
+		})
+	}
+}
+
+func TestConsumerResourceNamer(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		namer    func(topic string, partition int32) string
+		resource string
+	}{
+		{
+			name: "custom",
+			namer: func(topic string, partition int32) string {
+				return fmt.Sprintf("Consume Topic %s Partition %d", topic, partition)
+			},
+			resource: "Consume Topic test-topic Partition 0",
+		},
+		{
+			name:     "empty",
+			namer:    func(topic string, partition int32) string { return "" },
+			resource: "Consume Topic test-topic",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			broker := sarama.NewMockBroker(t, 0)
+			defer broker.Close()
+
+			broker.SetHandlerByMap(map[string]sarama.MockResponse{
+				"MetadataRequest": sarama.NewMockMetadataResponse(t).
+					SetBroker(broker.Addr(), broker.BrokerID()).
+					SetLeader("test-topic", 0, broker.BrokerID()),
+				"OffsetRequest": sarama.NewMockOffsetResponse(t).
+					SetOffset("test-topic", 0, sarama.OffsetOldest, 0).
+					SetOffset("test-topic", 0, sarama.OffsetNewest, 1),
+				"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+					SetMessage("test-topic", 0, 0, sarama.StringEncoder("hello")),
+			})
+			cfg := sarama.NewConfig()
+			cfg.Version = sarama.MinVersion
+			client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+			require.NoError(t, err)
+			defer client.Close()
+
+			consumer, err := sarama.NewConsumerFromClient(client)
+			require.NoError(t, err)
+			defer consumer.Close()
+
+			consumer = WrapConsumer(consumer, WithConsumerResourceNamer(tc.namer))
+			partitionConsumer, err := consumer.ConsumePartition("test-topic", 0, 0)
+			require.NoError(t, err)
+			<-partitionConsumer.Messages()
+			partitionConsumer.Close()
+			// wait for the channel to be closed
+			<-partitionConsumer.Messages()
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.resource, spans[0].Tag(ext.ResourceName))
+		})
+	}
+}
+
+func TestResourceNamer(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_11_0_0
+	cfg.Producer.Return.Successes = true
+
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		expected []string
+	}{
+		{
+			name:     "default",
+			expected: []string{"Produce Topic tenant1.orders", "Consume Topic tenant1.orders"},
+		},
+		{
+			name: "custom",
+			opts: []Option{WithResourceNamer(func(topic, op string) string {
+				return op + " " + topic[strings.IndexByte(topic, '.')+1:]
+			})},
+			expected: []string{"Produce orders", "Consume orders"},
+		},
+		{
+			name:     "empty",
+			opts:     []Option{WithResourceNamer(func(topic, op string) string { return "" })},
+			expected: []string{"Produce Topic tenant1.orders", "Consume Topic tenant1.orders"},
+		},
+		{
+			name: "consumer-resource-namer",
+			opts: []Option{
+				WithResourceNamer(func(topic, op string) string { return op + " orders" }),
+				WithConsumerResourceNamer(func(topic string, partition int32) string { return "Consume orders 0" }),
+			},
+			expected: []string{"Produce orders", "Consume orders 0"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			producer := mocks.NewSyncProducer(t, cfg)
+			producer.ExpectSendMessageAndSucceed()
+			wrapped := WrapSyncProducer(cfg, producer, tc.opts...)
+			_, _, err := wrapped.SendMessage(&sarama.ProducerMessage{Topic: "tenant1.orders", Value: sarama.StringEncoder("test")})
+			require.NoError(t, err)
+			require.NoError(t, wrapped.Close())
+
+			mc := mocks.NewConsumer(t, cfg)
+			mc.ExpectConsumePartition("tenant1.orders", 0, sarama.OffsetOldest).YieldMessage(&sarama.ConsumerMessage{Topic: "tenant1.orders", Value: []byte("test")})
+			pc, err := WrapConsumer(mc, tc.opts...).ConsumePartition("tenant1.orders", 0, sarama.OffsetOldest)
+			require.NoError(t, err)
+			<-pc.Messages()
+			require.NoError(t, pc.Close())
+			// wait for the channel to be closed
+			<-pc.Messages()
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 2)
+			for i, s := range spans {
+				assert.Equal(t, tc.expected[i], s.Tag(ext.ResourceName))
+			}
+		})
+	}
+}
+
+func TestHeaderTags(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_11_0_0
+	cfg.Producer.Return.Successes = true
+
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		expected map[string]interface{}
+	}{
+		{
+			name: "enabled",
+			opts: []Option{WithHeaderTags([]string{"correlation-id", "tenant", "missing"})},
+			expected: map[string]interface{}{
+				"messaging.kafka.header.correlation-id": "abc123",
+				"messaging.kafka.header.tenant":         "eu,us",
+				"messaging.kafka.header.missing":        nil,
+				"messaging.kafka.header.secret":         nil,
+			},
+		},
+		{
+			name: "disabled",
+			expected: map[string]interface{}{
+				"messaging.kafka.header.correlation-id": nil,
+				"messaging.kafka.header.tenant":         nil,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			headers := []sarama.RecordHeader{
+				{Key: []byte("correlation-id"), Value: []byte("abc123")},
+				{Key: []byte("tenant"), Value: []byte("eu")},
+				{Key: []byte("tenant"), Value: []byte("us")},
+				{Key: []byte("secret"), Value: []byte("s3cr3t")},
+			}
+			producer := mocks.NewSyncProducer(t, cfg)
+			producer.ExpectSendMessageAndSucceed()
+			wrapped := WrapSyncProducer(cfg, producer, tc.opts...)
+			_, _, err := wrapped.SendMessage(&sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("test"), Headers: headers})
+			require.NoError(t, err)
+			require.NoError(t, wrapped.Close())
+
+			msg := &sarama.ConsumerMessage{Topic: "my_topic", Value: []byte("test")}
+			for i := range headers {
+				msg.Headers = append(msg.Headers, &headers[i])
+			}
+			mc := mocks.NewConsumer(t, cfg)
+			mc.ExpectConsumePartition("my_topic", 0, sarama.OffsetOldest).YieldMessage(msg)
+			pc, err := WrapConsumer(mc, tc.opts...).ConsumePartition("my_topic", 0, sarama.OffsetOldest)
+			require.NoError(t, err)
+			<-pc.Messages()
+			require.NoError(t, pc.Close())
+			// wait for the channel to be closed
+			<-pc.Messages()
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 2)
+			for _, s := range spans {
+				for k, v := range tc.expected {
+					assert.Equal(t, v, s.Tag(k), k)
+				}
+			}
+		})
+	}
+}
+
+// testConsumerGroupClaim is a sarama.ConsumerGroupClaim yielding the messages
+// of its channel.
+type testConsumerGroupClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *testConsumerGroupClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// testConsumerGroupHandler is a sarama.ConsumerGroupHandler consuming at most
+// max messages of the claim, and counting the Setup and Cleanup calls.
+type testConsumerGroupHandler struct {
+	max      int
+	setup    int
+	cleanup  int
+	messages []*sarama.ConsumerMessage
+}
+
+func (h *testConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error {
+	h.setup++
+	return nil
+}
+
+func (h *testConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	h.cleanup++
+	return nil
+}
+
+func (h *testConsumerGroupHandler) ConsumeClaim(_ sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		h.messages = append(h.messages, msg)
+		if len(h.messages) == h.max {
+			break
+		}
+	}
+	return nil
+}
+
+func TestConsumerGroupHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		open     bool // whether the claim is still open once its messages are consumed
+		max      int
+		consumed int
+	}{
+		{name: "closed-claim", consumed: 2},
+		{name: "returned-handler", open: true, max: 2, consumed: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			parent := tracer.StartSpan("producer")
+			msg1 := &sarama.ConsumerMessage{Topic: "my_topic", Partition: 1, Offset: 10, Value: []byte("hello")}
+			require.NoError(t, tracer.Inject(parent.Context(), NewConsumerMessageCarrier(msg1)))
+			msg2 := &sarama.ConsumerMessage{Topic: "my_topic", Partition: 1, Offset: 11, Value: []byte("world")}
+			claim := &testConsumerGroupClaim{messages: make(chan *sarama.ConsumerMessage, 2)}
+			claim.messages <- msg1
+			claim.messages <- msg2
+			if !tc.open {
+				close(claim.messages)
+			}
+
+			handler := &testConsumerGroupHandler{max: tc.max}
+			wrapped := WrapConsumerGroupHandler(handler, WithGroupID("my_group"))
+			require.NoError(t, wrapped.Setup(nil))
+			require.NoError(t, wrapped.ConsumeClaim(nil, claim))
+			require.NoError(t, wrapped.Cleanup(nil))
+			assert.Equal(t, 1, handler.setup)
+			assert.Equal(t, 1, handler.cleanup)
+			require.Len(t, handler.messages, tc.consumed)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, tc.consumed)
+			for i, s := range spans {
+				spanctx, err := tracer.Extract(NewConsumerMessageCarrier(handler.messages[i]))
+				require.NoError(t, err)
+				assert.Equal(t, spanctx.SpanID(), s.SpanID(),
+					"span context should be injected into the consumer message headers")
+
+				assert.Equal(t, "kafka.consume", s.OperationName())
+				assert.Equal(t, "Consume Topic my_topic", s.Tag(ext.ResourceName))
+				assert.Equal(t, int32(1), s.Tag(ext.MessagingKafkaPartition))
+				assert.Equal(t, int64(10+i), s.Tag("offset"))
+				assert.Equal(t, "my_group", s.Tag("messaging.kafka.consumer_group"))
+				assert.Equal(t, ext.SpanKindConsumer, s.Tag(ext.SpanKind))
+			}
+			assert.Equal(t, parent.Context().TraceID(), spans[0].TraceID())
+			assert.Equal(t, parent.Context().SpanID(), spans[0].ParentID())
+		})
+	}
+}
+
 func TestSyncProducer(t *testing.T) {
 	mt := mocktracer.Start()
 	defer mt.Stop()
@@ -214,6 +597,319 @@ func TestSyncProducer(t *testing.T) {
 		assert.Equal(t, "Shopify/sarama", s.Tag(ext.Component))
 		assert.Equal(t, ext.SpanKindProducer, s.Tag(ext.SpanKind))
 		assert.Equal(t, "kafka", s.Tag(ext.MessagingSystem))
+		assert.Equal(t, "none", s.Tag("kafka.compression"))
+	}
+}
+
+func TestSyncProducerWithMetadataTag(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader := sarama.NewMockBroker(t, 2)
+	defer leader.Close()
+
+	metadataResponse := new(sarama.MetadataResponse)
+	metadataResponse.AddBroker(leader.Addr(), leader.BrokerID())
+	metadataResponse.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, nil, sarama.ErrNoError)
+	seedBroker.Returns(metadataResponse)
+
+	prodSuccess := new(sarama.ProduceResponse)
+	prodSuccess.AddTopicPartition("my_topic", 0, sarama.ErrNoError)
+	leader.Returns(prodSuccess)
+	leader.Returns(prodSuccess)
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.MinVersion
+	cfg.Producer.Return.Successes = true
+
+	producer, err := sarama.NewSyncProducer([]string{seedBroker.Addr()}, cfg)
+	require.NoError(t, err)
+	producer = WrapSyncProducer(cfg, producer, WithMetadataTag(func(metadata interface{}) (string, interface{}, bool) {
+		id, ok := metadata.(string)
+		return "correlation.id", id, ok
+	}))
+
+	_, _, err = producer.SendMessage(&sarama.ProducerMessage{
+		Topic:    "my_topic",
+		Value:    sarama.StringEncoder("test 1"),
+		Metadata: "test",
+	})
+	require.NoError(t, err)
+	_, _, err = producer.SendMessage(&sarama.ProducerMessage{
+		Topic: "my_topic",
+		Value: sarama.StringEncoder("test 2"),
+	})
+	require.NoError(t, err)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "test", spans[0].Tag("correlation.id"))
+	assert.NotContains(t, spans[1].Tags(), "correlation.id")
+}
+
+func TestSyncProducerWithPartitionCount(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader := sarama.NewMockBroker(t, 2)
+	defer leader.Close()
+
+	metadataResponse := new(sarama.MetadataResponse)
+	metadataResponse.AddBroker(leader.Addr(), leader.BrokerID())
+	for p := int32(0); p < 3; p++ {
+		metadataResponse.AddTopicPartition("my_topic", p, leader.BrokerID(), nil, nil, nil, sarama.ErrNoError)
+	}
+	seedBroker.Returns(metadataResponse)
+
+	prodSuccess := new(sarama.ProduceResponse)
+	prodSuccess.AddTopicPartition("my_topic", 0, sarama.ErrNoError)
+	leader.Returns(prodSuccess)
+	leader.Returns(prodSuccess)
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.MinVersion
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Partitioner = sarama.NewManualPartitioner
+
+	client, err := sarama.NewClient([]string{seedBroker.Addr()}, cfg)
+	require.NoError(t, err)
+	defer client.Close()
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	require.NoError(t, err)
+	producer = WrapSyncProducer(cfg, producer, WithPartitionCount(client))
+
+	for i := 0; i < 2; i++ {
+		_, _, err = producer.SendMessage(&sarama.ProducerMessage{
+			Topic: "my_topic",
+			Value: sarama.StringEncoder("test"),
+		})
+		require.NoError(t, err)
+	}
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	for _, s := range spans {
+		assert.Equal(t, 3, s.Tag("kafka.partition_count"))
+	}
+}
+
+// failingClient is a sarama client failing to get the partitions of the topics.
+type failingClient struct {
+	sarama.Client
+	calls int
+}
+
+func (c *failingClient) Partitions(string) ([]int32, error) {
+	c.calls++
+	return nil, sarama.ErrUnknownTopicOrPartition
+}
+
+func TestPartitionCountFailure(t *testing.T) {
+	client := new(failingClient)
+	cfg := new(config)
+	defaults(cfg)
+	WithPartitionCount(client)(cfg)
+
+	for i := 0; i < 3; i++ {
+		_, ok := cfg.partitionCounts.get("my_topic")
+		assert.False(t, ok)
+	}
+	assert.Equal(t, 1, client.calls, "the failure should be cached")
+
+	defer func(old time.Duration) { partitionCountRetryInterval = old }(partitionCountRetryInterval)
+	partitionCountRetryInterval = 0
+	_, ok := cfg.partitionCounts.get("my_topic")
+	assert.False(t, ok)
+	assert.Equal(t, 2, client.calls, "the partitions should be queried again after the retry interval")
+}
+
+// countingClient is a sarama client returning the given partitions of the topics.
+type countingClient struct {
+	sarama.Client
+	partitions []int32
+	calls      int
+}
+
+func (c *countingClient) Partitions(string) ([]int32, error) {
+	c.calls++
+	return c.partitions, nil
+}
+
+func TestPartitionCountTTL(t *testing.T) {
+	client := &countingClient{partitions: []int32{0, 1}}
+	cfg := new(config)
+	defaults(cfg)
+	WithPartitionCount(client)(cfg)
+
+	for i := 0; i < 3; i++ {
+		n, ok := cfg.partitionCounts.get("my_topic")
+		assert.True(t, ok)
+		assert.Equal(t, 2, n)
+	}
+	assert.Equal(t, 1, client.calls, "the partition count should be cached")
+
+	defer func(old time.Duration) { partitionCountTTL = old }(partitionCountTTL)
+	partitionCountTTL = 0
+	client.partitions = append(client.partitions, 2)
+	n, ok := cfg.partitionCounts.get("my_topic")
+	assert.True(t, ok)
+	assert.Equal(t, 3, n, "the added partitions should be picked up after the TTL")
+	assert.Equal(t, 2, client.calls)
+}
+
+func TestHeaderKeyPrefix(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	cfg := new(config)
+	defaults(cfg)
+	WithHeaderKeyPrefix("dd-")(cfg)
+
+	msg := &sarama.ProducerMessage{
+		Topic:   "my_topic",
+		Headers: []sarama.RecordHeader{{Key: []byte("x-datadog-trace-id"), Value: []byte("application-value")}},
+	}
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Version = sarama.V0_11_0_0
+	saramaConfig.Producer.Compression = sarama.CompressionGZIP
+	span := startProducerSpan(cfg, saramaConfig, msg, nil)
+	span.Finish()
+
+	headers := make(map[string]string)
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	assert.Equal(t, "application-value", headers["x-datadog-trace-id"])
+	assert.Equal(t, strconv.FormatUint(span.Context().TraceID(), 10), headers["dd-x-datadog-trace-id"])
+	assert.Equal(t, "gzip", mt.FinishedSpans()[0].Tag("kafka.compression"))
+
+	spanctx, ok := getSpanContext(cfg, msg)
+	require.True(t, ok)
+	assert.Equal(t, span.Context().SpanID(), spanctx.SpanID())
+}
+
+func TestSyncProducerError(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader := sarama.NewMockBroker(t, 2)
+	defer leader.Close()
+
+	metadataResponse := new(sarama.MetadataResponse)
+	metadataResponse.AddBroker(leader.Addr(), leader.BrokerID())
+	metadataResponse.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, nil, sarama.ErrNoError)
+	seedBroker.Returns(metadataResponse)
+
+	prodFailure := new(sarama.ProduceResponse)
+	prodFailure.AddTopicPartition("my_topic", 0, sarama.ErrInvalidMessage)
+	leader.Returns(prodFailure)
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.MinVersion
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Retry.Max = 0
+
+	producer, err := sarama.NewSyncProducer([]string{seedBroker.Addr()}, cfg)
+	require.NoError(t, err)
+	producer = WrapSyncProducer(cfg, producer)
+
+	_, _, err = producer.SendMessage(&sarama.ProducerMessage{
+		Topic: "my_topic",
+		Value: sarama.StringEncoder("test 1"),
+	})
+	require.Error(t, err)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	s := spans[0]
+	assert.Equal(t, "kafka.produce", s.OperationName())
+	assert.Equal(t, "Produce Topic my_topic", s.Tag(ext.ResourceName))
+	assert.Equal(t, err, s.Tag(ext.Error))
+	assert.NotContains(t, s.Tags(), ext.MessagingKafkaPartition)
+	assert.NotContains(t, s.Tags(), "offset")
+}
+
+func TestErrorCheck(t *testing.T) {
+	ignoreNotLeader := WithErrorCheck(func(err error) bool {
+		var errs sarama.ProducerErrors
+		if errors.As(err, &errs) {
+			err = errs[0].Err
+		}
+		return err != sarama.ErrNotLeaderForPartition
+	})
+	for _, tc := range []struct {
+		name    string
+		opts    []Option
+		err     error
+		errored bool
+	}{
+		{name: "default", err: sarama.ErrNotLeaderForPartition, errored: true},
+		{name: "ignored", opts: []Option{ignoreNotLeader}, err: sarama.ErrNotLeaderForPartition},
+		{name: "not-ignored", opts: []Option{ignoreNotLeader}, err: sarama.ErrInvalidMessage, errored: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := sarama.NewConfig()
+			cfg.Version = sarama.V0_11_0_0
+			cfg.Producer.Return.Successes = true
+			assertSpans := func(t *testing.T, mt mocktracer.Tracer, n int) {
+				spans := mt.FinishedSpans()
+				require.Len(t, spans, n)
+				for _, s := range spans {
+					if tc.errored {
+						assert.NotNil(t, s.Tag(ext.Error))
+					} else {
+						assert.Nil(t, s.Tag(ext.Error))
+					}
+				}
+			}
+
+			t.Run("SendMessage", func(t *testing.T) {
+				mt := mocktracer.Start()
+				defer mt.Stop()
+
+				producer := mocks.NewSyncProducer(t, cfg)
+				producer.ExpectSendMessageAndFail(tc.err)
+				wrapped := WrapSyncProducer(cfg, producer, tc.opts...)
+				_, _, err := wrapped.SendMessage(&sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("test")})
+				require.Equal(t, tc.err, err)
+				require.NoError(t, wrapped.Close())
+				assertSpans(t, mt, 1)
+			})
+
+			t.Run("SendMessages", func(t *testing.T) {
+				mt := mocktracer.Start()
+				defer mt.Stop()
+
+				producer := mocks.NewSyncProducer(t, cfg)
+				producer.ExpectSendMessageAndFail(tc.err)
+				wrapped := WrapSyncProducer(cfg, producer, tc.opts...)
+				err := wrapped.SendMessages([]*sarama.ProducerMessage{{Topic: "my_topic", Value: sarama.StringEncoder("test")}})
+				require.Error(t, err)
+				require.NoError(t, wrapped.Close())
+				// the message span and the batch span
+				assertSpans(t, mt, 2)
+			})
+
+			t.Run("AsyncProducer", func(t *testing.T) {
+				mt := mocktracer.Start()
+				defer mt.Stop()
+
+				producer := mocks.NewAsyncProducer(t, cfg)
+				producer.ExpectInputAndFail(tc.err)
+				wrapped := WrapAsyncProducer(cfg, producer, tc.opts...)
+				wrapped.Input() <- &sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("test")}
+				err := <-wrapped.Errors()
+				require.Equal(t, tc.err, err.Err)
+				require.NoError(t, wrapped.Close())
+				assertSpans(t, mt, 1)
+			})
+		})
 	}
 }
 
@@ -258,8 +954,12 @@ func TestSyncProducerSendMessages(t *testing.T) {
 	}
 	producer.SendMessages([]*sarama.ProducerMessage{msg1, msg2})
 	spans := mt.FinishedSpans()
-	assert.Len(t, spans, 2)
-	for _, s := range spans {
+	// the batch span is finished last
+	require.Len(t, spans, 3)
+	batch := spans[2]
+	assert.Equal(t, "kafka.produce_batch", batch.OperationName())
+	for _, s := range spans[:2] {
+		assert.Equal(t, batch.SpanID(), s.ParentID())
 		assert.Equal(t, "kafka", s.Tag(ext.ServiceName))
 		assert.Equal(t, "queue", s.Tag(ext.SpanType))
 		assert.Equal(t, "Produce Topic my_topic", s.Tag(ext.ResourceName))
@@ -271,31 +971,141 @@ func TestSyncProducerSendMessages(t *testing.T) {
 	}
 }
 
+func TestSyncProducerSendMessagesBatch(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_11_0_0
+	cfg.Producer.Return.Successes = true
+
+	for _, tc := range []struct {
+		name string
+		err  error
+	}{
+		{name: "success"},
+		{name: "error", err: sarama.ErrInvalidMessage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			parent := tracer.StartSpan("parent")
+			msgs := []*sarama.ProducerMessage{
+				{Topic: "orders", Value: sarama.StringEncoder("test 1")},
+				{Topic: "orders", Value: sarama.StringEncoder("test 2")},
+				{Topic: "payments", Value: sarama.StringEncoder("test 3")},
+			}
+			require.NoError(t, tracer.Inject(parent.Context(), NewProducerMessageCarrier(msgs[1])))
+			producer := mocks.NewSyncProducer(t, cfg)
+			for range msgs {
+				if tc.err != nil {
+					producer.ExpectSendMessageAndFail(tc.err)
+				} else {
+					producer.ExpectSendMessageAndSucceed()
+				}
+			}
+			wrapped := WrapSyncProducer(cfg, producer)
+			err := wrapped.SendMessages(msgs)
+			if tc.err != nil {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.NoError(t, wrapped.Close())
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 4)
+			batch := spans[3]
+			assert.Equal(t, "kafka.produce_batch", batch.OperationName())
+			assert.Equal(t, "Produce Batch", batch.Tag(ext.ResourceName))
+			assert.Equal(t, "kafka", batch.Tag(ext.ServiceName))
+			assert.Equal(t, ext.SpanKindProducer, batch.Tag(ext.SpanKind))
+			assert.Equal(t, 3, batch.Tag("messaging.batch.message_count"))
+			assert.Equal(t, 2, batch.Tag("messaging.kafka.topic_count"))
+			assert.Equal(t, parent.Context().SpanID(), batch.ParentID())
+			assert.Equal(t, parent.Context().TraceID(), batch.TraceID())
+			if tc.err != nil {
+				assert.NotNil(t, batch.Tag(ext.Error))
+			} else {
+				assert.Nil(t, batch.Tag(ext.Error))
+			}
+			for i, s := range spans[:3] {
+				assert.Equal(t, "kafka.produce", s.OperationName())
+				assert.Equal(t, "Produce Topic "+msgs[i].Topic, s.Tag(ext.ResourceName))
+				assert.Equal(t, batch.SpanID(), s.ParentID())
+				assert.Equal(t, batch.TraceID(), s.TraceID())
+			}
+		})
+	}
+}
+
+// partialFailureSyncProducer is a sarama.SyncProducer failing to send the
+// messages of the given topic.
+type partialFailureSyncProducer struct {
+	sarama.SyncProducer
+	failedTopic string
+}
+
+func (p *partialFailureSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var errs sarama.ProducerErrors
+	for i, msg := range msgs {
+		if msg.Topic == p.failedTopic {
+			errs = append(errs, &sarama.ProducerError{Msg: msg, Err: sarama.ErrInvalidMessage})
+			continue
+		}
+		msg.Partition = 1
+		msg.Offset = int64(i)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func TestSyncProducerSendMessagesPartialFailure(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	msgs := []*sarama.ProducerMessage{
+		{Topic: "orders", Value: sarama.StringEncoder("test 1")},
+		{Topic: "payments", Value: sarama.StringEncoder("test 2")},
+		{Topic: "orders", Value: sarama.StringEncoder("test 3")},
+	}
+	wrapped := WrapSyncProducer(sarama.NewConfig(), &partialFailureSyncProducer{failedTopic: "payments"})
+	err := wrapped.SendMessages(msgs)
+	require.Error(t, err)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 4)
+	assert.NotNil(t, spans[3].Tag(ext.Error))
+	// only the span of the failed message is marked as an error, with its own error
+	assert.Equal(t, sarama.ErrInvalidMessage, spans[1].Tag(ext.Error))
+	assert.Nil(t, spans[1].Tag(ext.MessagingKafkaPartition))
+	for i, s := range []mocktracer.Span{spans[0], spans[2]} {
+		assert.Nil(t, s.Tag(ext.Error))
+		assert.Equal(t, int32(1), s.Tag(ext.MessagingKafkaPartition))
+		assert.Equal(t, int64(i*2), s.Tag("offset"))
+	}
+}
+
 func TestAsyncProducer(t *testing.T) {
 	// the default for producers is a fire-and-forget model that doesn't return
 	// successes
 	t.Run("Without Successes", func(t *testing.T) {
-		t.Skip("Skipping test because sarama.MockBroker doesn't work with versions >= sarama.V0_11_0_0 " +
-			"https://github.com/Shopify/sarama/issues/1665")
 		mt := mocktracer.Start()
 		defer mt.Stop()
 
-		broker := newMockBroker(t)
-
 		cfg := sarama.NewConfig()
 		cfg.Version = sarama.V0_11_0_0
-		producer, err := sarama.NewAsyncProducer([]string{broker.Addr()}, cfg)
-		if err != nil {
-			t.Fatal(err)
-		}
-		producer = WrapAsyncProducer(nil, producer)
+		producer := mocks.NewAsyncProducer(t, cfg)
+		producer.ExpectInputAndSucceed()
+		wrapped := WrapAsyncProducer(cfg, producer)
 
 		msg1 := &sarama.ProducerMessage{
 			Topic: "my_topic",
 			Value: sarama.StringEncoder("test 1"),
 		}
-		producer.Input() <- msg1
+		wrapped.Input() <- msg1
 
+		// the span is finished once the message is handed over to the producer
 		waitForSpans(mt, 1, time.Second*10)
 
 		spans := mt.FinishedSpans()
@@ -306,12 +1116,44 @@ func TestAsyncProducer(t *testing.T) {
 			assert.Equal(t, "queue", s.Tag(ext.SpanType))
 			assert.Equal(t, "Produce Topic my_topic", s.Tag(ext.ResourceName))
 			assert.Equal(t, "kafka.produce", s.OperationName())
-			assert.Equal(t, int32(0), s.Tag(ext.MessagingKafkaPartition))
-			assert.Equal(t, int64(0), s.Tag("offset"))
+			assert.Nil(t, s.Tag(ext.MessagingKafkaPartition))
+			assert.Nil(t, s.Tag("offset"))
 			assert.Equal(t, "Shopify/sarama", s.Tag(ext.Component))
 			assert.Equal(t, ext.SpanKindProducer, s.Tag(ext.SpanKind))
 			assert.Equal(t, "kafka", s.Tag(ext.MessagingSystem))
 		}
+		require.NoError(t, wrapped.Close())
+	})
+
+	t.Run("Closed Before Successes", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		cfg := sarama.NewConfig()
+		cfg.Version = sarama.V0_11_0_0
+		// the mock producer doesn't return the successes, so that the
+		// delivery of the message is never reported to the wrapper
+		producer := mocks.NewAsyncProducer(t, cfg)
+		received := make(chan struct{})
+		producer.ExpectInputWithCheckerFunctionAndSucceed(func([]byte) error {
+			close(received)
+			return nil
+		})
+		wrappedCfg := sarama.NewConfig()
+		wrappedCfg.Version = sarama.V0_11_0_0
+		wrappedCfg.Producer.Return.Successes = true
+		wrapped := WrapAsyncProducer(wrappedCfg, producer)
+
+		wrapped.Input() <- &sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("test 1")}
+		// the mock producer closes its input channel when closed, so wait for
+		// the message to be forwarded to it first
+		<-received
+		require.NoError(t, wrapped.Close())
+
+		waitForSpans(mt, 1, time.Second*10)
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Nil(t, spans[0].Tag("offset"))
 	})
 
 	t.Run("With Successes", func(t *testing.T) {
@@ -356,6 +1198,224 @@ func TestAsyncProducer(t *testing.T) {
 	})
 }
 
+func TestProducerConfigTags(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_11_0_0
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Retry.Max = 7
+	cfg.Producer.Idempotent = true
+	cfg.Net.MaxOpenRequests = 1
+
+	assertTags := func(t *testing.T, s mocktracer.Span, enabled bool) {
+		if !enabled {
+			assert.Nil(t, s.Tag("kafka.required_acks"))
+			assert.Nil(t, s.Tag("kafka.max_retries"))
+			assert.Nil(t, s.Tag("kafka.idempotent"))
+			assert.Nil(t, s.Tag("kafka.protocol_version"))
+			return
+		}
+		assert.Equal(t, "0.11.0.0", s.Tag("kafka.protocol_version"))
+		assert.Equal(t, -1, s.Tag("kafka.required_acks"))
+		assert.Equal(t, 7, s.Tag("kafka.max_retries"))
+		assert.Equal(t, true, s.Tag("kafka.idempotent"))
+	}
+	for _, enabled := range []bool{true, false} {
+		var opts []Option
+		if enabled {
+			opts = append(opts, WithProducerConfigTags())
+		}
+		t.Run(fmt.Sprintf("sync/enabled=%t", enabled), func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			producer := mocks.NewSyncProducer(t, cfg)
+			producer.ExpectSendMessageAndSucceed()
+			wrapped := WrapSyncProducer(cfg, producer, opts...)
+			_, _, err := wrapped.SendMessage(&sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("test")})
+			require.NoError(t, err)
+			require.NoError(t, wrapped.Close())
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assertTags(t, spans[0], enabled)
+		})
+		t.Run(fmt.Sprintf("async/enabled=%t", enabled), func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			producer := mocks.NewAsyncProducer(t, cfg)
+			producer.ExpectInputAndSucceed()
+			wrapped := WrapAsyncProducer(cfg, producer, opts...)
+			wrapped.Input() <- &sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("test")}
+			<-wrapped.Successes()
+			require.NoError(t, wrapped.Close())
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assertTags(t, spans[0], enabled)
+		})
+	}
+}
+
+func TestConsumerConfigTags(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_1_0_0
+
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		expected interface{}
+	}{
+		{name: "enabled", opts: []Option{WithConsumerConfigTags(cfg)}, expected: "2.1.0"},
+		{name: "disabled"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			mc := mocks.NewConsumer(t, cfg)
+			mc.ExpectConsumePartition("test-topic", 0, sarama.OffsetOldest).YieldMessage(&sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("test")})
+			pc, err := WrapConsumer(mc, tc.opts...).ConsumePartition("test-topic", 0, sarama.OffsetOldest)
+			require.NoError(t, err)
+			<-pc.Messages()
+			require.NoError(t, pc.Close())
+			// wait for the channel to be closed
+			<-pc.Messages()
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.expected, spans[0].Tag("kafka.protocol_version"))
+		})
+	}
+}
+
+func TestConsumerInitialOffsetTag(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		offset   int64
+		expected string
+	}{
+		{name: "oldest", offset: sarama.OffsetOldest, expected: "oldest"},
+		{name: "newest", offset: sarama.OffsetNewest, expected: "newest"},
+		{name: "absolute", offset: 42, expected: "42"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			mc := mocks.NewConsumer(t, sarama.NewConfig())
+			mc.ExpectConsumePartition("test-topic", 0, tc.offset).YieldMessage(&sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("test")})
+			pc, err := WrapConsumer(mc).ConsumePartition("test-topic", 0, tc.offset)
+			require.NoError(t, err)
+			<-pc.Messages()
+			require.NoError(t, pc.Close())
+			// wait for the channel to be closed
+			<-pc.Messages()
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.expected, spans[0].Tag("kafka.initial_offset"))
+		})
+	}
+}
+
+func TestServiceNameEnv(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_11_0_0
+	cfg.Producer.Return.Successes = true
+
+	for _, tc := range []struct {
+		name     string
+		env      string
+		opts     []Option
+		expected string
+	}{
+		{name: "default", expected: "kafka"},
+		{name: "env", env: "kafka-orders", expected: "kafka-orders"},
+		{name: "option", env: "kafka-orders", opts: []Option{WithServiceName("orders")}, expected: "orders"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(envServiceName, tc.env)
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			producer := mocks.NewSyncProducer(t, cfg)
+			producer.ExpectSendMessageAndSucceed()
+			wrapped := WrapSyncProducer(cfg, producer, tc.opts...)
+			_, _, err := wrapped.SendMessage(&sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("test")})
+			require.NoError(t, err)
+			require.NoError(t, wrapped.Close())
+
+			mc := mocks.NewConsumer(t, cfg)
+			mc.ExpectConsumePartition("my_topic", 0, sarama.OffsetOldest).YieldMessage(&sarama.ConsumerMessage{Topic: "my_topic", Value: []byte("test")})
+			pc, err := WrapConsumer(mc, tc.opts...).ConsumePartition("my_topic", 0, sarama.OffsetOldest)
+			require.NoError(t, err)
+			<-pc.Messages()
+			require.NoError(t, pc.Close())
+			// wait for the channel to be closed
+			<-pc.Messages()
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 2)
+			for _, s := range spans {
+				assert.Equal(t, tc.expected, s.Tag(ext.ServiceName))
+			}
+		})
+	}
+}
+
+func TestMessageSizeTag(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_11_0_0
+	cfg.Producer.Return.Successes = true
+
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		expected interface{}
+	}{
+		{name: "enabled", opts: []Option{WithMessageSizeTag(true)}, expected: 9},
+		{name: "disabled", opts: []Option{WithMessageSizeTag(false)}},
+		{name: "default"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			producer := mocks.NewSyncProducer(t, cfg)
+			producer.ExpectSendMessageAndSucceed()
+			wrapped := WrapSyncProducer(cfg, producer, tc.opts...)
+			_, _, err := wrapped.SendMessage(&sarama.ProducerMessage{
+				Topic: "my_topic",
+				Key:   sarama.StringEncoder("key"),
+				Value: sarama.ByteEncoder("value1"),
+			})
+			require.NoError(t, err)
+			require.NoError(t, wrapped.Close())
+
+			mc := mocks.NewConsumer(t, cfg)
+			mc.ExpectConsumePartition("my_topic", 0, sarama.OffsetOldest).YieldMessage(&sarama.ConsumerMessage{
+				Topic: "my_topic",
+				Key:   []byte("key"),
+				Value: []byte("value1"),
+			})
+			pc, err := WrapConsumer(mc, tc.opts...).ConsumePartition("my_topic", 0, sarama.OffsetOldest)
+			require.NoError(t, err)
+			<-pc.Messages()
+			require.NoError(t, pc.Close())
+			// wait for the channel to be closed
+			<-pc.Messages()
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 2)
+			for _, s := range spans {
+				assert.Equal(t, tc.expected, s.Tag("messaging.kafka.message_size"))
+			}
+		})
+	}
+}
+
 func TestNamingSchema(t *testing.T) {
 	// first is producer and second is consumer span
 	wantServiceNameV0 := namingschematest.ServiceNameAssertions{
@@ -398,3 +1458,105 @@ func waitForSpans(mt mocktracer.Tracer, sz int, maxWait time.Duration) {
 		time.Sleep(time.Millisecond * 100)
 	}
 }
+
+func TestWrapSyncProducerFromClient(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_11_0_0
+	cfg.Producer.Return.Successes = true
+
+	producer := mocks.NewSyncProducer(t, cfg)
+	producer.ExpectSendMessageAndSucceed()
+	wrapped := WrapSyncProducerFromClient(&configClient{cfg: cfg}, producer)
+
+	msg := &sarama.ProducerMessage{
+		Topic: "my_topic",
+		Value: sarama.StringEncoder("test"),
+	}
+	_, _, err := wrapped.SendMessage(msg)
+	require.NoError(t, err)
+	require.NoError(t, producer.Close())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	// the client config version supports headers, so the span context was injected
+	spanctx, err := tracer.Extract(NewProducerMessageCarrier(msg))
+	require.NoError(t, err)
+	assert.Equal(t, spans[0].TraceID(), spanctx.TraceID())
+	assert.Equal(t, spans[0].SpanID(), spanctx.SpanID())
+}
+
+// configClient is a sarama.Client only implementing the Config method.
+type configClient struct {
+	sarama.Client
+	cfg *sarama.Config
+}
+
+func (c *configClient) Config() *sarama.Config { return c.cfg }
+
+func TestLinkProduceConsume(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "default"},
+		{name: "header-prefix", opts: []Option{WithHeaderKeyPrefix("x-")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			cfg := sarama.NewConfig()
+			cfg.Version = sarama.V0_11_0_0
+			cfg.Producer.Return.Successes = true
+			producer := mocks.NewSyncProducer(t, cfg)
+			producer.ExpectSendMessageAndSucceed()
+			pmsg := &sarama.ProducerMessage{
+				Topic:   "test-topic",
+				Value:   sarama.StringEncoder("test"),
+				Headers: []sarama.RecordHeader{{Key: []byte("app"), Value: []byte("test")}},
+			}
+			_, _, err := WrapSyncProducer(cfg, producer, tc.opts...).SendMessage(pmsg)
+			require.NoError(t, err)
+
+			cmsg := &sarama.ConsumerMessage{
+				Topic:   "test-topic",
+				Value:   []byte("test"),
+				Headers: []*sarama.RecordHeader{{Key: []byte("app"), Value: []byte("stale")}},
+			}
+			LinkProduceConsume(pmsg, cmsg)
+			// the headers are copied, and the existing ones replaced
+			require.Len(t, cmsg.Headers, len(pmsg.Headers))
+			app := func() string {
+				for _, h := range cmsg.Headers {
+					if string(h.Key) == "app" {
+						return string(h.Value)
+					}
+				}
+				return ""
+			}
+			assert.Equal(t, "test", app())
+			pmsg.Headers[0].Value[0] = 'T'
+			assert.Equal(t, "test", app())
+
+			mc := mocks.NewConsumer(t, nil)
+			mc.ExpectConsumePartition("test-topic", 0, sarama.OffsetOldest).YieldMessage(cmsg)
+			pc, err := WrapConsumer(mc, tc.opts...).ConsumePartition("test-topic", 0, sarama.OffsetOldest)
+			require.NoError(t, err)
+			<-pc.Messages()
+			require.NoError(t, pc.Close())
+			// wait for the channel to be closed
+			<-pc.Messages()
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 2)
+			produce, consume := spans[0], spans[1]
+			assert.Equal(t, "kafka.produce", produce.OperationName())
+			assert.Equal(t, "kafka.consume", consume.OperationName())
+			assert.Equal(t, produce.TraceID(), consume.TraceID())
+			assert.Equal(t, produce.SpanID(), consume.ParentID())
+		})
+	}
+}
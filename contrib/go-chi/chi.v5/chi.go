@@ -7,11 +7,16 @@
 package chi // import "gopkg.in/DataDog/dd-trace-go.v1/contrib/go-chi/chi.v5"
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/httptrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
@@ -24,6 +29,14 @@ import (
 
 const componentName = "go-chi/chi.v5"
 
+// statusClientClosedRequest is the non-standard status code reported for requests
+// cancelled by the client, as popularized by nginx.
+const statusClientClosedRequest = 499
+
+// tracedRequestKey is the context key holding the config of the middleware
+// tracing the request.
+type tracedRequestKey struct{}
+
 func init() {
 	telemetry.LoadIntegration(componentName)
 }
@@ -41,25 +54,77 @@ func Middleware(opts ...Option) func(next http.Handler) http.Handler {
 		tracer.Tag(ext.SpanKind, ext.SpanKindServer))
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if cfg.ignoreRequest(r) {
+			if hasIgnoredPrefix(cfg.ignorePrefixes, r.URL.Path) || cfg.ignoreRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if c, _ := r.Context().Value(tracedRequestKey{}).(*config); c == cfg {
+				// chi chains the router middlewares again around the custom NotFound
+				// and MethodNotAllowed handlers, which are then already traced
 				next.ServeHTTP(w, r)
 				return
 			}
-			opts := spanOpts
+			// limit the capacity of spanOpts so that append copies it instead of
+			// sharing its backing array between concurrent requests
+			start := cfg.clock.Now()
+			opts := append(spanOpts[:len(spanOpts):len(spanOpts)], tracer.StartTime(start))
 			if !math.IsNaN(cfg.analyticsRate) {
 				opts = append(opts, tracer.Tag(ext.EventSampleRate, cfg.analyticsRate))
 			}
+			if cfg.contentLength {
+				if opt, ok := httptrace.RequestContentLengthTag(r); ok {
+					opts = append(opts, opt)
+				}
+			}
+			if cfg.schemeTag != nil {
+				opts = append(opts, cfg.schemeTag(r))
+			}
 			span, ctx := httptrace.StartRequestSpan(r, opts...)
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			served := false
 			defer func() {
 				status := ww.Status()
-				var opts []tracer.FinishOption
-				if cfg.isStatusError(status) {
-					opts = []tracer.FinishOption{tracer.WithError(fmt.Errorf("%d: %s", status, http.StatusText(status)))}
+				if status == 0 && served {
+					// net/http implicitly sends 200 when the handler returns without sending the response
+					// header, which chi's response writer doesn't record when the handler only flushed it
+					status = http.StatusOK
+				}
+				finish := cfg.clock.Now()
+				opts := []tracer.FinishOption{tracer.FinishTime(finish)}
+				if cfg.contextTagger != nil {
+					for k, v := range cfg.contextTagger(r.Context()) {
+						span.SetTag(k, v)
+					}
+				}
+				if cfg.clientDisconnect && r.Context().Err() == context.Canceled {
+					span.SetTag(ext.ErrorType, "client_disconnect")
+					if !keepStatus(cfg, span, statusClientClosedRequest) {
+						setSamplingDecision(cfg, span, r, statusClientClosedRequest)
+						dropFastSpan(cfg, span, finish.Sub(start), false)
+					}
+					httptrace.FinishRequestSpan(span, statusClientClosedRequest, opts...)
+					return
+				}
+				isError := cfg.isStatusError(status)
+				if !keepStatus(cfg, span, status) {
+					setSamplingDecision(cfg, span, r, status)
+					dropFastSpan(cfg, span, finish.Sub(start), isError)
+				}
+				if isError {
+					opts = append(opts, tracer.WithError(fmt.Errorf("%d: %s", status, http.StatusText(status))))
 				}
 				httptrace.FinishRequestSpan(span, status, opts...)
 			}()
 
+			if cfg.applyDeadline {
+				var cancel context.CancelFunc
+				ctx, cancel = httptrace.ApplyRequestDeadline(ctx)
+				defer cancel()
+			}
+			ctx = context.WithValue(ctx, tracedRequestKey{}, cfg)
+			if cfg.middlewareSpans {
+				ctx = context.WithValue(ctx, middlewareSpansKey{}, true)
+			}
 			// pass the span through the request context
 			r = r.WithContext(ctx)
 
@@ -72,10 +137,30 @@ func Middleware(opts ...Option) func(next http.Handler) http.Handler {
 
 			// pass the span through the request context and serve the request to the next middleware
 			next.ServeHTTP(ww, r)
+			served = true
 
 			// set the resource name as we get it only once the handler is executed
-			resourceName := cfg.modifyResourceName(chi.RouteContext(r.Context()).RoutePattern())
-			span.SetTag(ext.HTTPRoute, resourceName)
+			rctx := chi.RouteContext(r.Context())
+			routePattern := rctx.RoutePattern()
+			for _, name := range cfg.pathParamTags {
+				if v := rctx.URLParam(name); v != "" {
+					span.SetTag("http.path_params."+name, v)
+				}
+			}
+			if cfg.tagMatchedRoute {
+				// chi only records the route pattern when a route was found
+				span.SetTag("chi.matched", routePattern != "")
+			}
+			var resourceName string
+			if routePattern == "" && cfg.unmatchedRoute != "" {
+				resourceName = cfg.unmatchedRoute
+			} else if status := ww.Status(); routePattern == "" && (status == http.StatusNotFound || status == http.StatusMethodNotAllowed) {
+				// the request was served by the NotFound or MethodNotAllowed handler of the router
+				resourceName = strconv.Itoa(status)
+			} else {
+				resourceName = cfg.modifyResourceName(routePattern)
+				span.SetTag(ext.HTTPRoute, resourceName)
+			}
 			if resourceName == "" {
 				resourceName = "unknown"
 			}
@@ -84,3 +169,49 @@ func Middleware(opts ...Option) func(next http.Handler) http.Handler {
 		})
 	}
 }
+
+// keepStatus sets the sampling priority of the request span to manual keep when
+// the given status is one of the kept statuses of the config, and reports
+// whether it did so.
+func keepStatus(cfg *config, span ddtrace.Span, status int) bool {
+	for _, r := range cfg.keepStatuses {
+		if status >= r.min && status <= r.max {
+			span.SetTag(ext.ManualKeep, true)
+			return true
+		}
+	}
+	return false
+}
+
+// setSamplingDecision sets the sampling priority of the request span according
+// to the sampling decision function of the config, if any.
+func setSamplingDecision(cfg *config, span ddtrace.Span, r *http.Request, status int) {
+	if cfg.samplingDecision == nil {
+		return
+	}
+	if cfg.samplingDecision(r, status) {
+		span.SetTag(ext.ManualKeep, true)
+	} else {
+		span.SetTag(ext.ManualDrop, true)
+	}
+}
+
+// dropFastSpan sets the sampling priority of the request span to manual drop
+// when the request isn't an error and was served faster than the minimum span
+// duration of the config, if any.
+func dropFastSpan(cfg *config, span ddtrace.Span, d time.Duration, isError bool) {
+	if cfg.minSpanDuration > 0 && !isError && d < cfg.minSpanDuration {
+		span.SetTag(ext.ManualDrop, true)
+	}
+}
+
+// hasIgnoredPrefix reports whether the given path starts with any of the
+// ignored prefixes.
+func hasIgnoredPrefix(prefixes []string, path string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -6,6 +6,7 @@
 package chi
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	pappsec "gopkg.in/DataDog/dd-trace-go.v1/appsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
@@ -132,6 +134,485 @@ func TestWithModifyResourceName(t *testing.T) {
 	assert.Equal(t, "GET /user/{id}", span.Tag(ext.ResourceName))
 }
 
+type testClock struct {
+	now  time.Time
+	step time.Duration
+}
+
+func (c *testClock) Now() time.Time {
+	now := c.now
+	c.now = c.now.Add(c.step)
+	return now
+}
+
+func TestUnmatchedRouteLabel(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		path     string
+		resource string
+	}{
+		{name: "default", path: "/unknown/123", resource: "GET 404"},
+		{
+			name:     "label",
+			opts:     []Option{WithUnmatchedRouteLabel("not_found")},
+			path:     "/unknown/123",
+			resource: "GET not_found",
+		},
+		{
+			name: "label-with-modifier",
+			opts: []Option{
+				WithUnmatchedRouteLabel("not_found"),
+				WithModifyResourceName(func(string) string { return "/unknown/123" }),
+			},
+			path:     "/unknown/123",
+			resource: "GET not_found",
+		},
+		{
+			name:     "matched",
+			opts:     []Option{WithUnmatchedRouteLabel("not_found")},
+			path:     "/user/123",
+			resource: "GET /user/{id}",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			router := chi.NewRouter()
+			router.Use(Middleware(tc.opts...))
+			router.Get("/user/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+			r := httptest.NewRequest("GET", tc.path, nil)
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.resource, spans[0].Tag(ext.ResourceName))
+		})
+	}
+}
+
+func TestNotFoundMethodNotAllowed(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		method   string
+		path     string
+		custom   bool
+		status   int
+		resource string
+	}{
+		{name: "not-found", method: "GET", path: "/unknown", status: 404, resource: "GET 404"},
+		{name: "method-not-allowed", method: "POST", path: "/user/123", status: 405, resource: "POST 405"},
+		{name: "custom-not-found", method: "GET", path: "/unknown", custom: true, status: 404, resource: "GET 404"},
+		{name: "custom-method-not-allowed", method: "POST", path: "/user/123", custom: true, status: 405, resource: "POST 405"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			router := chi.NewRouter()
+			router.Use(Middleware())
+			router.Get("/user/{id}", func(w http.ResponseWriter, r *http.Request) {})
+			if tc.custom {
+				// chi chains the router middlewares around the custom handlers too
+				router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNotFound)
+				})
+				router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+				})
+			}
+
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, tc.status, w.Code)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.resource, spans[0].Tag(ext.ResourceName))
+			assert.Equal(t, strconv.Itoa(tc.status), spans[0].Tag(ext.HTTPCode))
+			assert.Nil(t, spans[0].Tag(ext.HTTPRoute))
+		})
+	}
+}
+
+func TestSamplingDecision(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	var decided []int
+	router := chi.NewRouter()
+	router.Use(Middleware(WithSamplingDecision(func(r *http.Request, status int) bool {
+		decided = append(decided, status)
+		return status >= 500
+	})))
+	router.Get("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	router.Get("/err", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	for _, path := range []string{"/ok", "/err"} {
+		r := httptest.NewRequest("GET", path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusInternalServerError}, decided)
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, true, spans[0].Tag(ext.ManualDrop))
+	assert.Nil(t, spans[0].Tag(ext.ManualKeep))
+	assert.Equal(t, true, spans[1].Tag(ext.ManualKeep))
+	assert.Nil(t, spans[1].Tag(ext.ManualDrop))
+}
+
+func TestKeepStatuses(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   []Option
+		status int
+		kept   bool
+	}{
+		{name: "status", opts: []Option{WithKeepStatuses([]int{429, 503})}, status: http.StatusTooManyRequests, kept: true},
+		{name: "other-status", opts: []Option{WithKeepStatuses([]int{429, 503})}, status: http.StatusInternalServerError},
+		{name: "range", opts: []Option{WithKeepStatusRange(500, 599)}, status: http.StatusInternalServerError, kept: true},
+		{name: "out-of-range", opts: []Option{WithKeepStatusRange(500, 599)}, status: http.StatusOK},
+		{name: "cumulative", opts: []Option{WithKeepStatusRange(500, 599), WithKeepStatuses([]int{404})}, status: http.StatusNotFound, kept: true},
+		{
+			name:   "over-sampling-decision",
+			opts:   []Option{WithKeepStatusRange(500, 599), WithSamplingDecision(func(r *http.Request, status int) bool { return false })},
+			status: http.StatusServiceUnavailable,
+			kept:   true,
+		},
+		{
+			name:   "over-min-span-duration",
+			opts:   []Option{WithKeepStatuses([]int{200}), WithMinSpanDuration(time.Hour)},
+			status: http.StatusOK,
+			kept:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			router := chi.NewRouter()
+			router.Use(Middleware(tc.opts...))
+			router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			})
+
+			r := httptest.NewRequest("GET", "/", nil)
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			if tc.kept {
+				assert.Equal(t, true, spans[0].Tag(ext.ManualKeep))
+				assert.Nil(t, spans[0].Tag(ext.ManualDrop))
+			} else {
+				assert.Nil(t, spans[0].Tag(ext.ManualKeep))
+			}
+		})
+	}
+}
+
+func TestContextTagger(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	type tenantKey struct{}
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, "acme")))
+		})
+	})
+	router.Use(Middleware(WithContextTagger(func(ctx context.Context) map[string]interface{} {
+		tags := map[string]interface{}{}
+		if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+			tags["tenant"] = tenant
+		}
+		// the route context is filled by the router while serving the request
+		if id := chi.RouteContext(ctx).URLParam("id"); id != "" {
+			tags["user.id"] = id
+		}
+		return tags
+	})))
+	router.Get("/user/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	r := httptest.NewRequest("GET", "/user/123", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "acme", spans[0].Tag("tenant"))
+	assert.Equal(t, "123", spans[0].Tag("user.id"))
+}
+
+func TestClock(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	router := chi.NewRouter()
+	router.Use(Middleware(withClock(&testClock{now: start, step: 42 * time.Millisecond})))
+	router.Get("/user/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	r := httptest.NewRequest("GET", "/user/123", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, start, spans[0].StartTime())
+	assert.Equal(t, 42*time.Millisecond, spans[0].FinishTime().Sub(spans[0].StartTime()))
+}
+
+func TestMinSpanDuration(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		path    string
+		step    time.Duration
+		dropped bool
+	}{
+		{name: "fast", path: "/ok", step: time.Millisecond, dropped: true},
+		{name: "slow", path: "/ok", step: 20 * time.Millisecond},
+		{name: "fast-error", path: "/err", step: time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			clock := &testClock{now: time.Now(), step: tc.step}
+			router := chi.NewRouter()
+			router.Use(Middleware(WithMinSpanDuration(10*time.Millisecond), withClock(clock)))
+			router.Get("/ok", func(w http.ResponseWriter, r *http.Request) {})
+			router.Get("/err", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			})
+
+			r := httptest.NewRequest("GET", tc.path, nil)
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			if tc.dropped {
+				assert.Equal(t, true, spans[0].Tag(ext.ManualDrop))
+			} else {
+				assert.Nil(t, spans[0].Tag(ext.ManualDrop))
+			}
+		})
+	}
+}
+
+func TestMatchedRouteTag(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	router := chi.NewRouter()
+	router.Use(Middleware(WithMatchedRouteTag()))
+	router.Get("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	for _, url := range []string{"/user/123", "/unknown"} {
+		r := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, true, spans[0].Tag("chi.matched"))
+	assert.Equal(t, false, spans[1].Tag("chi.matched"))
+}
+
+func TestWithTags(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		WithTags(map[string]interface{}{"team": "core", "version": "1.0"}),
+		WithSpanOptions(tracer.Tag("version", "2.0")),
+		WithTags(map[string]interface{}{"env": "prod"}),
+	))
+	router.Get("/user/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	r := httptest.NewRequest("GET", "/user/123", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "core", spans[0].Tag("team"))
+	assert.Equal(t, "2.0", spans[0].Tag("version"))
+	assert.Equal(t, "prod", spans[0].Tag("env"))
+}
+
+func TestPathParamTags(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	router := chi.NewRouter()
+	router.Use(Middleware(WithPathParamTags([]string{"tenant", "missing"})))
+	router.Get("/{tenant}/user/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	r := httptest.NewRequest("GET", "/acme/user/123", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	tags := spans[0].Tags()
+	assert.Equal(t, "acme", tags["http.path_params.tenant"])
+	assert.NotContains(t, tags, "http.path_params.id")
+	assert.NotContains(t, tags, "http.path_params.missing")
+}
+
+func TestRequestContentLength(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		expected interface{}
+	}{
+		{name: "enabled", opts: []Option{WithRequestContentLength(true)}, expected: int64(5)},
+		{name: "disabled", opts: []Option{WithRequestContentLength(false)}},
+		{name: "default"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			router := chi.NewRouter()
+			router.Use(Middleware(tc.opts...))
+			router.Post("/user", func(w http.ResponseWriter, r *http.Request) {})
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/user", strings.NewReader("hello")))
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.expected, spans[0].Tag("http.request.content_length"))
+		})
+	}
+}
+
+func TestSchemeTag(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		expected interface{}
+	}{
+		{name: "default", expected: "http"},
+		{name: "forwarded", opts: []Option{WithSchemeTag(true, "X-Forwarded-Proto")}, expected: "https"},
+		{name: "disabled", opts: []Option{WithSchemeTag(false, "")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			router := chi.NewRouter()
+			router.Use(Middleware(tc.opts...))
+			router.Get("/user", func(w http.ResponseWriter, r *http.Request) {})
+			r := httptest.NewRequest("GET", "/user", nil)
+			r.Header.Set("X-Forwarded-Proto", "https")
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.expected, spans[0].Tag("http.scheme"))
+		})
+	}
+}
+
+func TestImplicitStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{
+			name:    "write",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) },
+			status:  http.StatusOK,
+		},
+		{
+			name: "flush",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.(http.Flusher).Flush()
+				w.Write([]byte("ok"))
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:    "empty",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			status:  http.StatusOK,
+		},
+		{
+			name:    "explicit",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) },
+			status:  http.StatusNotFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			var checked []int
+			router := chi.NewRouter()
+			router.Use(Middleware(WithStatusCheck(func(status int) bool {
+				checked = append(checked, status)
+				return status < 200 || status >= 400
+			})))
+			router.Get("/", tc.handler)
+
+			r := httptest.NewRequest("GET", "/", nil)
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, strconv.Itoa(tc.status), spans[0].Tag(ext.HTTPCode))
+			assert.Equal(t, []int{tc.status}, checked)
+			assert.Equal(t, tc.status == http.StatusNotFound, spans[0].Tag(ext.Error) != nil)
+		})
+	}
+}
+
+func TestClientDisconnect(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		status   string
+		errType  interface{}
+		hasError bool
+	}{
+		{name: "default", status: "500", hasError: true},
+		{name: "enabled", opts: []Option{WithClientDisconnect()}, status: "499", errType: "client_disconnect"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			router := chi.NewRouter()
+			router.Use(Middleware(tc.opts...))
+			router.Get("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+				http.Error(w, r.Context().Err().Error(), http.StatusInternalServerError)
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			r := httptest.NewRequest("GET", "/user/123", nil).WithContext(ctx)
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.status, spans[0].Tag(ext.HTTPCode))
+			assert.Equal(t, tc.errType, spans[0].Tag(ext.ErrorType))
+			assert.Equal(t, tc.hasError, spans[0].Tag(ext.Error) != nil)
+		})
+	}
+}
+
 func TestError(t *testing.T) {
 	assertSpan := func(assert *assert.Assertions, spans []mocktracer.Span, code int) {
 		assert.Len(spans, 1)
@@ -334,6 +815,42 @@ func TestIgnoreRequest(t *testing.T) {
 	}
 }
 
+func TestIgnorePrefixes(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(Middleware(WithIgnorePrefixes([]string{"/debug/", "/metrics"})))
+	router.Mount("/debug", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("debug"))
+	}))
+	router.Get("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	})
+	router.Get("/debugger", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("debugger"))
+	})
+	router.Get("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	for path, shouldSkip := range map[string]bool{
+		"/ok":           false,
+		"/debugger":     false,
+		"/debug/pprof/": true,
+		"/debug/vars":   true,
+		"/metrics":      true,
+	} {
+		t.Run(path, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			r := httptest.NewRequest("GET", "http://localhost"+path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, shouldSkip, len(mt.FinishedSpans()) == 0)
+		})
+	}
+}
+
 func TestAppSec(t *testing.T) {
 	appsec.Start()
 	defer appsec.Stop()
@@ -439,3 +956,90 @@ func TestAppSec(t *testing.T) {
 		require.True(t, strings.Contains(event.(string), "crs-933-130"))
 	})
 }
+
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func slowMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestMiddlewareSpans(t *testing.T) {
+	newRouter := func(opts ...Option) (*chi.Mux, *bool) {
+		var handlerSpanIsRequestSpan bool
+		router := chi.NewRouter()
+		router.Use(Middleware(opts...))
+		router.Use(WrapMiddleware(slowMiddleware), WrapMiddleware(authMiddleware))
+		router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			span, _ := tracer.SpanFromContext(r.Context())
+			handlerSpanIsRequestSpan = span.(mocktracer.Span).OperationName() == "http.request"
+		})
+		return router, &handlerSpanIsRequestSpan
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		router, handlerSpanIsRequestSpan := newRouter(WithMiddlewareSpans())
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "secret")
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		assert.True(t, *handlerSpanIsRequestSpan)
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 3)
+		slow, auth, request := spans[0], spans[1], spans[2]
+		assert.Equal(t, "http.request", request.OperationName())
+		for _, s := range []mocktracer.Span{slow, auth} {
+			assert.Equal(t, "chi.middleware", s.OperationName())
+			assert.Equal(t, request.SpanID(), s.ParentID())
+			assert.Equal(t, "go-chi/chi.v5", s.Tag(ext.Component))
+		}
+		assert.Equal(t, "gopkg.in/DataDog/dd-trace-go.v1/contrib/go-chi/chi.v5.slowMiddleware", slow.Tag(ext.ResourceName))
+		assert.Equal(t, "gopkg.in/DataDog/dd-trace-go.v1/contrib/go-chi/chi.v5.authMiddleware", auth.Tag(ext.ResourceName))
+		assert.GreaterOrEqual(t, slow.FinishTime().Sub(slow.StartTime()), 10*time.Millisecond)
+		// the auth middleware span ends when it calls the next handler, before the request span
+		assert.True(t, auth.FinishTime().Before(request.FinishTime()))
+	})
+
+	t.Run("short-circuit", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		router, _ := newRouter(WithMiddlewareSpans())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 3)
+		assert.Equal(t, "chi.middleware", spans[1].OperationName())
+		assert.Equal(t, "401", spans[2].Tag(ext.HTTPCode))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		router, handlerSpanIsRequestSpan := newRouter()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "secret")
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		assert.True(t, *handlerSpanIsRequestSpan)
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "http.request", spans[0].OperationName())
+	})
+}
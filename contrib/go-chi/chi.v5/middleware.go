@@ -0,0 +1,80 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023 Datadog, Inc.
+
+package chi
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+type (
+	// middlewareSpansKey is the context key reporting whether the middleware spans are enabled by WithMiddlewareSpans.
+	middlewareSpansKey struct{}
+	// middlewareSpanKey is the context key holding the span of the middleware being executed.
+	middlewareSpanKey struct{}
+)
+
+// middlewareSpan is the span of a middleware, finished as soon as the middleware calls the next handler, or returns.
+type middlewareSpan struct {
+	span   ddtrace.Span
+	parent ddtrace.Span
+	once   sync.Once
+}
+
+func (s *middlewareSpan) finish() {
+	s.once.Do(func() { s.span.Finish() })
+}
+
+// WrapMiddleware wraps the given chi middleware so that its execution is traced
+// by a "chi.middleware" child span of the request span, whose resource name is
+// the name of the middleware function. The span covers the execution of the
+// middleware until it calls the next handler, or returns when it doesn't, so
+// that the slow middlewares of the chain can be found. Spans are only created
+// when the tracing Middleware, which must be registered before the wrapped
+// middlewares, is given WithMiddlewareSpans:
+//
+//	router.Use(chitrace.Middleware(chitrace.WithMiddlewareSpans()))
+//	router.Use(chitrace.WrapMiddleware(middleware.RealIP))
+func WrapMiddleware(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	name := "unknown"
+	if fn := runtime.FuncForPC(reflect.ValueOf(mw).Pointer()); fn != nil {
+		// the dots of the last element of the package path are escaped in function names
+		name = strings.ReplaceAll(fn.Name(), "%2e", ".")
+	}
+	return func(next http.Handler) http.Handler {
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s, ok := r.Context().Value(middlewareSpanKey{}).(*middlewareSpan); ok {
+				s.finish()
+				// restore the request span as the active span of the next handlers
+				r = r.WithContext(tracer.ContextWithSpan(r.Context(), s.parent))
+			}
+			next.ServeHTTP(w, r)
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			parent, ok := tracer.SpanFromContext(ctx)
+			if enabled, _ := ctx.Value(middlewareSpansKey{}).(bool); !enabled || !ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+			span, ctx := tracer.StartSpanFromContext(ctx, "chi.middleware",
+				tracer.ResourceName(name),
+				tracer.Tag(ext.Component, componentName),
+				tracer.Tag("chi.middleware.name", name))
+			s := &middlewareSpan{span: span, parent: parent}
+			defer s.finish()
+			h.ServeHTTP(w, r.WithContext(context.WithValue(ctx, middlewareSpanKey{}, s)))
+		})
+	}
+}
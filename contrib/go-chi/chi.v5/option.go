@@ -6,10 +6,14 @@
 package chi
 
 import (
+	"context"
 	"math"
 	"net/http"
+	"time"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/httptrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
 )
@@ -21,8 +25,38 @@ type config struct {
 	isStatusError      func(statusCode int) bool
 	ignoreRequest      func(r *http.Request) bool
 	modifyResourceName func(resourceName string) string
+	clock              clock // time source of the span start and finish times
+	tagMatchedRoute    bool  // tag whether the request matched a route
+	clientDisconnect   bool  // treat requests cancelled by the client distinctly
+	pathParamTags      []string
+	unmatchedRoute     string // resource label of the requests matching no route, if set
+	samplingDecision   func(r *http.Request, status int) (keep bool)
+	contextTagger      func(ctx context.Context) map[string]interface{}
+	middlewareSpans    bool          // enable the spans of the middlewares wrapped with WrapMiddleware
+	minSpanDuration    time.Duration // duration below which the spans of non-error requests are dropped, if set
+	ignorePrefixes     []string      // path prefixes of the requests not traced
+	keepStatuses       []statusRange // status codes of the requests whose spans are always kept
+	applyDeadline      bool          // apply the deadline of the request timeout header to the request context
+	contentLength      bool          // tag the request Content-Length declared by the client
+	// schemeTag returns the span option overriding the default scheme tag, if set
+	schemeTag func(r *http.Request) ddtrace.StartSpanOption
 }
 
+// statusRange is an inclusive range of HTTP status codes.
+type statusRange struct {
+	min, max int
+}
+
+// clock provides the current time. It allows tests to control the span
+// start and finish times.
+type clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
 // Option represents an option that can be passed to NewRouter.
 type Option func(*config)
 
@@ -39,6 +73,8 @@ func defaults(cfg *config) {
 	cfg.isStatusError = isServerError
 	cfg.ignoreRequest = func(_ *http.Request) bool { return false }
 	cfg.modifyResourceName = func(s string) string { return s }
+	cfg.clock = realClock{}
+	cfg.contentLength = httptrace.RequestContentLengthEnabled()
 }
 
 // WithServiceName sets the given service name for the router.
@@ -49,10 +85,22 @@ func WithServiceName(name string) Option {
 }
 
 // WithSpanOptions applies the given set of options to the spans started
-// by the router.
+// by the router, after the ones given by previous options.
 func WithSpanOptions(opts ...ddtrace.StartSpanOption) Option {
 	return func(cfg *config) {
-		cfg.spanOpts = opts
+		cfg.spanOpts = append(cfg.spanOpts, opts...)
+	}
+}
+
+// WithTags sets the given tags on the spans started by the router. The tags
+// are applied along with the span options given by WithSpanOptions, in the
+// order the options are given, so that later options override the keys set
+// by earlier ones.
+func WithTags(tags map[string]interface{}) Option {
+	return func(cfg *config) {
+		for k, v := range tags {
+			cfg.spanOpts = append(cfg.spanOpts, tracer.Tag(k, v))
+		}
 	}
 }
 
@@ -105,3 +153,149 @@ func WithModifyResourceName(fn func(resourceName string) string) Option {
 		cfg.modifyResourceName = fn
 	}
 }
+
+// withClock sets the time source used for the span start and finish times.
+func withClock(c clock) Option {
+	return func(cfg *config) {
+		cfg.clock = c
+	}
+}
+
+// WithMatchedRouteTag enables tagging spans with "chi.matched", reporting whether the
+// request matched a route of the router or was served by its NotFound handler.
+// This allows telling apart unmatched routes from 404 responses returned by handlers.
+func WithMatchedRouteTag() Option {
+	return func(cfg *config) {
+		cfg.tagMatchedRoute = true
+	}
+}
+
+// WithClientDisconnect enables treating requests whose context was cancelled, usually
+// because the client disconnected, distinctly from server errors: their spans are
+// tagged with the status code 499 and error.type "client_disconnect", and they are
+// not marked as errors regardless of the response status written by the handler.
+func WithClientDisconnect() Option {
+	return func(cfg *config) {
+		cfg.clientDisconnect = true
+	}
+}
+
+// WithRequestDeadline applies the deadline resulting from the timeout header
+// specified by the DD_TRACE_HTTP_DEADLINE_HEADER env var, such as grpc-timeout,
+// to the request context. The deadline is tagged on the request span regardless.
+func WithRequestDeadline() Option {
+	return func(cfg *config) {
+		cfg.applyDeadline = true
+	}
+}
+
+// WithRequestContentLength enables or disables tagging the spans with the request
+// Content-Length declared by the client, as "http.request.content_length". It
+// defaults to the DD_TRACE_HTTP_REQUEST_CONTENT_LENGTH_ENABLED env var.
+func WithRequestContentLength(on bool) Option {
+	return func(cfg *config) {
+		cfg.contentLength = on
+	}
+}
+
+// WithSchemeTag enables or disables the "http.scheme" tag, enabled by default,
+// found in the given forwarded proto header, such as X-Forwarded-Proto, if set.
+// It overrides DD_TRACE_HTTP_SCHEME_ENABLED and DD_TRACE_HTTP_FORWARDED_PROTO_HEADER.
+func WithSchemeTag(enabled bool, forwardedProtoHeader string) Option {
+	return func(cfg *config) {
+		cfg.schemeTag = func(r *http.Request) ddtrace.StartSpanOption {
+			return httptrace.SchemeTag(r, enabled, forwardedProtoHeader)
+		}
+	}
+}
+
+// WithPathParamTags specifies the route parameters whose values are added as
+// "http.path_params.<name>" span tags once the request is routed. Parameters
+// missing from the matched route are not tagged.
+func WithPathParamTags(params []string) Option {
+	return func(cfg *config) {
+		cfg.pathParamTags = params
+	}
+}
+
+// WithUnmatchedRouteLabel sets the resource name of the requests matching no route
+// of the router to the request method followed by the given label, such as
+// "GET not_found". The resource name modifier given by WithModifyResourceName
+// isn't applied to them, so that all unmatched requests aggregate under a single
+// resource per method. Without a label, the requests served by the NotFound or
+// MethodNotAllowed handlers of the router are named after their status code,
+// such as "GET 404".
+func WithUnmatchedRouteLabel(label string) Option {
+	return func(cfg *config) {
+		cfg.unmatchedRoute = label
+	}
+}
+
+// WithSamplingDecision specifies a function fn which decides whether the trace
+// of a request should be kept or dropped, according to the request and its
+// response status code, once the request is served. The decision is applied by
+// setting the sampling priority of the span to manual keep or manual drop. It
+// only affects the local sampling priority of the trace: a trace whose sampling
+// priority was already propagated to other services, or decided by an upstream
+// service, may still be kept or dropped by them and by the backend.
+func WithSamplingDecision(fn func(r *http.Request, status int) (keep bool)) Option {
+	return func(cfg *config) {
+		cfg.samplingDecision = fn
+	}
+}
+
+// WithContextTagger specifies a function fn returning span tags derived from the
+// request context, such as the authenticated user. It is called once the request
+// is served, with the context of the request received by the tracing middleware.
+func WithContextTagger(fn func(ctx context.Context) map[string]interface{}) Option {
+	return func(cfg *config) {
+		cfg.contextTagger = fn
+	}
+}
+
+// WithMiddlewareSpans enables the "chi.middleware" child spans of the middlewares
+// wrapped with WrapMiddleware and registered after the tracing Middleware.
+func WithMiddlewareSpans() Option {
+	return func(cfg *config) {
+		cfg.middlewareSpans = true
+	}
+}
+
+// WithMinSpanDuration drops the spans of the requests served in less than the given
+// duration, unless they are errors according to WithStatusCheck. It takes precedence
+// over WithSamplingDecision, and is disabled when zero.
+func WithMinSpanDuration(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.minSpanDuration = d
+	}
+}
+
+// WithIgnorePrefixes disables tracing the requests whose URL path starts with
+// any of the given prefixes, such as the subtree of a router mounted at
+// "/debug". The prefixes are matched as is, before routing, so that "/debug"
+// also matches "/debugger": use "/debug/" to only match the mounted subtree.
+// It is a shorthand for the common case of WithIgnoreRequest, which applies too.
+func WithIgnorePrefixes(prefixes []string) Option {
+	return func(cfg *config) {
+		cfg.ignorePrefixes = append(cfg.ignorePrefixes, prefixes...)
+	}
+}
+
+// WithKeepStatuses forces keeping the spans of the requests served with any of the
+// given status codes, such as 503, regardless of the sampling rules and of the
+// WithSamplingDecision and WithMinSpanDuration options.
+func WithKeepStatuses(statuses []int) Option {
+	return func(cfg *config) {
+		for _, s := range statuses {
+			cfg.keepStatuses = append(cfg.keepStatuses, statusRange{min: s, max: s})
+		}
+	}
+}
+
+// WithKeepStatusRange is like WithKeepStatuses for the status codes between
+// from and to inclusive, such as 500 and 599 for all the server errors.
+func WithKeepStatusRange(from, to int) Option {
+	return func(cfg *config) {
+		cfg.keepStatuses = append(cfg.keepStatuses, statusRange{min: from, max: to})
+	}
+}
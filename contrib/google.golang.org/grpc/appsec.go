@@ -33,7 +33,7 @@ func appsecUnaryHandlerMiddleware(span ddtrace.Span, handler grpc.UnaryHandler)
 			if len(events) == 0 {
 				return
 			}
-			setAppSecEventsTags(ctx, span, events)
+			setAppSecEventsTags(ctx, span, events, op.KeepEvents())
 		}()
 
 		if op.Error != nil {
@@ -65,7 +65,7 @@ func appsecStreamHandlerMiddleware(span ddtrace.Span, handler grpc.StreamHandler
 			if len(events) == 0 {
 				return
 			}
-			setAppSecEventsTags(stream.Context(), span, events)
+			setAppSecEventsTags(stream.Context(), span, events, op.KeepEvents())
 		}()
 
 		if op.Error != nil {
@@ -97,9 +97,9 @@ func (ss appsecServerStream) Context() context.Context {
 }
 
 // Set the AppSec tags when security events were found.
-func setAppSecEventsTags(ctx context.Context, span ddtrace.Span, events []json.RawMessage) {
+func setAppSecEventsTags(ctx context.Context, span ddtrace.Span, events []json.RawMessage, keep bool) {
 	md, _ := metadata.FromIncomingContext(ctx)
-	grpcsec.SetSecurityEventTags(span, events, md)
+	grpcsec.SetSecurityEventTags(span, events, keep, md)
 }
 
 func setClientIP(ctx context.Context, span ddtrace.Span, md metadata.MD) netip.Addr {
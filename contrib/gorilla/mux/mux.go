@@ -12,6 +12,7 @@ import (
 
 	"github.com/gorilla/mux"
 
+	internalhttptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/httptrace"
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
@@ -153,7 +154,7 @@ func headerTagsFromRequest(req *http.Request) ddtrace.StartSpanOption {
 	return func(cfg *ddtrace.StartSpanConfig) {
 		for k := range req.Header {
 			if !strings.HasPrefix(strings.ToLower(k), "x-datadog-") {
-				cfg.Tags["http.request.headers."+k] = strings.Join(req.Header.Values(k), ",")
+				cfg.Tags["http.request.headers."+k] = internalhttptrace.HeaderTagValue(strings.Join(req.Header.Values(k), ","))
 			}
 		}
 	}
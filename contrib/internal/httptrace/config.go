@@ -6,8 +6,10 @@
 package httptrace
 
 import (
+	"net/http"
 	"os"
 	"regexp"
+	"strings"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/internal"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
@@ -22,8 +24,34 @@ const (
 	envQueryStringRegexp = "DD_TRACE_OBFUSCATION_QUERY_STRING_REGEXP"
 	// envTraceClientIPEnabled is the name of the env var used to specify whether or not to collect client ip in span tags
 	envTraceClientIPEnabled = "DD_TRACE_CLIENT_IP_ENABLED"
+	// envRawMethodEnabled is the name of the env var used to keep the HTTP method span tag as sent by the client
+	// instead of normalizing it to uppercase.
+	envRawMethodEnabled = "DD_TRACE_HTTP_RAW_METHOD_ENABLED"
+	// envDeadlineHeader is the name of the env var used to specify the request header holding the timeout set by the
+	// client, such as grpc-timeout, to tag the request span with the resulting deadline.
+	envDeadlineHeader = "DD_TRACE_HTTP_DEADLINE_HEADER"
+	// envHeaderTagMaxLength is the name of the env var used to specify the maximum length in bytes of the header tag
+	// values, beyond which they are truncated. A negative value disables the truncation.
+	envHeaderTagMaxLength = "DD_TRACE_HEADER_TAGS_MAX_LENGTH"
+	// envCacheHeaders is the name of the env var used to specify the default comma-separated list of response headers,
+	// such as X-Cache or Age, telling whether the response was served from a cache, to tag the span with the cache
+	// status. The integrations can override it with their own option.
+	envCacheHeaders = "DD_TRACE_HTTP_CACHE_HEADERS"
+	// envRequestContentLengthEnabled is the name of the env var used to tag the request spans with the request
+	// Content-Length declared by the client by default. The integrations can override it with their own option.
+	envRequestContentLengthEnabled = "DD_TRACE_HTTP_REQUEST_CONTENT_LENGTH_ENABLED"
+	// envSchemeEnabled is the name of the env var used to specify whether or not to tag the request spans with the
+	// request scheme by default. The integrations can override it with their own option.
+	envSchemeEnabled = "DD_TRACE_HTTP_SCHEME_ENABLED"
+	// envForwardedProtoHeader is the name of the env var used to specify the request header holding the scheme of the
+	// requests received by a TLS-terminating proxy, such as X-Forwarded-Proto, taking precedence over the scheme of
+	// the connection, by default. The integrations can override it with their own option.
+	envForwardedProtoHeader = "DD_TRACE_HTTP_FORWARDED_PROTO_HEADER"
 )
 
+// defaultHeaderTagMaxLength is the maximum length of the header tag values if `envHeaderTagMaxLength` is not set.
+const defaultHeaderTagMaxLength = 1024
+
 // defaultQueryStringRegexp is the regexp used for query string obfuscation if `envQueryStringRegexp` is empty.
 var defaultQueryStringRegexp = regexp.MustCompile("(?i)(?:p(?:ass)?w(?:or)?d|pass(?:_?phrase)?|secret|(?:api_?|private_?|public_?|access_?|secret_?)key(?:_?id)?|token|consumer_?(?:id|key|secret)|sign(?:ed|ature)?|auth(?:entication|orization)?)(?:(?:\\s|%20)*(?:=|%3D)[^&]+|(?:\"|%22)(?:\\s|%20)*(?::|%3A)(?:\\s|%20)*(?:\"|%22)(?:%2[^2]|%[^2]|[^\"%])+(?:\"|%22))|bearer(?:\\s|%20)+[a-z0-9\\._\\-]|token(?::|%3A)[a-z0-9]{13}|gh[opsu]_[0-9a-zA-Z]{36}|ey[I-L](?:[\\w=-]|%3D)+\\.ey[I-L](?:[\\w=-]|%3D)+(?:\\.(?:[\\w.+\\/=-]|%3D|%2F|%2B)+)?|[\\-]{5}BEGIN(?:[a-z\\s]|%20)+PRIVATE(?:\\s|%20)KEY[\\-]{5}[^\\-]+[\\-]{5}END(?:[a-z\\s]|%20)+PRIVATE(?:\\s|%20)KEY|ssh-rsa(?:\\s|%20)*(?:[a-z0-9\\/\\.+]|%2F|%5C|%2B){100,}")
 
@@ -31,6 +59,13 @@ type config struct {
 	queryStringRegexp *regexp.Regexp // specifies the regexp to use for query string obfuscation.
 	queryString       bool           // reports whether the query string should be included in the URL span tag.
 	traceClientIP     bool
+	rawMethod         bool     // reports whether the HTTP method span tag should be kept as sent by the client.
+	deadlineHeader    string   // specifies the request header holding the client timeout, if any.
+	headerTagMaxLen   int      // maximum length of the header tag values, negative when unlimited.
+	cacheHeaders      []string // specifies the default response headers telling the cache status, if any.
+	contentLength     bool     // reports whether the declared request Content-Length should be tagged by default.
+	scheme            bool     // reports whether the request scheme should be tagged.
+	forwardedProto    string   // specifies the request header holding the scheme forwarded by a proxy, if any.
 }
 
 func newConfig() config {
@@ -38,6 +73,13 @@ func newConfig() config {
 		queryString:       !internal.BoolEnv(envQueryStringDisabled, false),
 		queryStringRegexp: defaultQueryStringRegexp,
 		traceClientIP:     internal.BoolEnv(envTraceClientIPEnabled, false),
+		rawMethod:         internal.BoolEnv(envRawMethodEnabled, false),
+		deadlineHeader:    os.Getenv(envDeadlineHeader),
+		headerTagMaxLen:   internal.IntEnv(envHeaderTagMaxLength, defaultHeaderTagMaxLength),
+		cacheHeaders:      readHeaderListEnv(envCacheHeaders),
+		contentLength:     internal.BoolEnv(envRequestContentLengthEnabled, false),
+		scheme:            internal.BoolEnv(envSchemeEnabled, true),
+		forwardedProto:    http.CanonicalHeaderKey(strings.TrimSpace(os.Getenv(envForwardedProtoHeader))),
 	}
 	if s, ok := os.LookupEnv(envQueryStringRegexp); !ok {
 		return c
@@ -51,3 +93,14 @@ func newConfig() config {
 	}
 	return c
 }
+
+// readHeaderListEnv returns the canonical header names of the comma-separated list of the given env var.
+func readHeaderListEnv(env string) []string {
+	var headers []string
+	for _, h := range strings.Split(os.Getenv(env), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, http.CanonicalHeaderKey(h))
+		}
+	}
+	return headers
+}
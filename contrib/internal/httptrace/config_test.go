@@ -16,6 +16,8 @@ func TestConfig(t *testing.T) {
 	defaultCfg := config{
 		queryString:       true,
 		queryStringRegexp: defaultQueryStringRegexp,
+		headerTagMaxLen:   defaultHeaderTagMaxLength,
+		scheme:            true,
 	}
 	for _, tc := range []struct {
 		name string
@@ -39,13 +41,91 @@ func TestConfig(t *testing.T) {
 			env:  map[string]string{envQueryStringDisabled: "true"},
 			cfg: config{
 				queryStringRegexp: defaultQueryStringRegexp,
+				headerTagMaxLen:   defaultHeaderTagMaxLength,
+				scheme:            true,
+			},
+		},
+		{
+			name: "raw-method",
+			env:  map[string]string{envRawMethodEnabled: "true"},
+			cfg: config{
+				queryString:       true,
+				queryStringRegexp: defaultQueryStringRegexp,
+				rawMethod:         true,
+				headerTagMaxLen:   defaultHeaderTagMaxLength,
+				scheme:            true,
+			},
+		},
+		{
+			name: "deadline-header",
+			env:  map[string]string{envDeadlineHeader: "grpc-timeout"},
+			cfg: config{
+				queryString:       true,
+				queryStringRegexp: defaultQueryStringRegexp,
+				deadlineHeader:    "grpc-timeout",
+				headerTagMaxLen:   defaultHeaderTagMaxLength,
+				scheme:            true,
+			},
+		},
+		{
+			name: "header-tag-max-length",
+			env:  map[string]string{envHeaderTagMaxLength: "64"},
+			cfg: config{
+				queryString:       true,
+				queryStringRegexp: defaultQueryStringRegexp,
+				headerTagMaxLen:   64,
+				scheme:            true,
+			},
+		},
+		{
+			name: "cache-headers",
+			env:  map[string]string{envCacheHeaders: "x-cache, ,Age"},
+			cfg: config{
+				queryString:       true,
+				queryStringRegexp: defaultQueryStringRegexp,
+				headerTagMaxLen:   defaultHeaderTagMaxLength,
+				scheme:            true,
+				cacheHeaders:      []string{"X-Cache", "Age"},
+			},
+		},
+		{
+			name: "request-content-length",
+			env:  map[string]string{envRequestContentLengthEnabled: "true"},
+			cfg: config{
+				queryString:       true,
+				queryStringRegexp: defaultQueryStringRegexp,
+				headerTagMaxLen:   defaultHeaderTagMaxLength,
+				scheme:            true,
+				contentLength:     true,
+			},
+		},
+		{
+			name: "disable-scheme",
+			env:  map[string]string{envSchemeEnabled: "false"},
+			cfg: config{
+				queryString:       true,
+				queryStringRegexp: defaultQueryStringRegexp,
+				headerTagMaxLen:   defaultHeaderTagMaxLength,
+			},
+		},
+		{
+			name: "forwarded-proto-header",
+			env:  map[string]string{envForwardedProtoHeader: " x-forwarded-proto "},
+			cfg: config{
+				queryString:       true,
+				queryStringRegexp: defaultQueryStringRegexp,
+				headerTagMaxLen:   defaultHeaderTagMaxLength,
+				scheme:            true,
+				forwardedProto:    "X-Forwarded-Proto",
 			},
 		},
 		{
 			name: "disable-query-obf",
 			env:  map[string]string{envQueryStringRegexp: ""},
 			cfg: config{
-				queryString: true,
+				queryString:     true,
+				headerTagMaxLen: defaultHeaderTagMaxLength,
+				scheme:          true,
 			},
 		},
 	} {
@@ -57,21 +137,39 @@ func TestConfig(t *testing.T) {
 			c := newConfig()
 			require.Equal(t, tc.cfg.queryStringRegexp, c.queryStringRegexp)
 			require.Equal(t, tc.cfg.queryString, c.queryString)
+			require.Equal(t, tc.cfg.rawMethod, c.rawMethod)
+			require.Equal(t, tc.cfg.deadlineHeader, c.deadlineHeader)
+			require.Equal(t, tc.cfg.headerTagMaxLen, c.headerTagMaxLen)
+			require.Equal(t, tc.cfg.cacheHeaders, c.cacheHeaders)
+			require.Equal(t, tc.cfg.contentLength, c.contentLength)
+			require.Equal(t, tc.cfg.scheme, c.scheme)
+			require.Equal(t, tc.cfg.forwardedProto, c.forwardedProto)
 		})
 	}
 }
 
 func cleanEnv() func() {
 	env := map[string]string{
-		envQueryStringDisabled: os.Getenv(envQueryStringDisabled),
-		envQueryStringRegexp:   os.Getenv(envQueryStringRegexp),
+		envQueryStringDisabled:         os.Getenv(envQueryStringDisabled),
+		envQueryStringRegexp:           os.Getenv(envQueryStringRegexp),
+		envRawMethodEnabled:            os.Getenv(envRawMethodEnabled),
+		envDeadlineHeader:              os.Getenv(envDeadlineHeader),
+		envHeaderTagMaxLength:          os.Getenv(envHeaderTagMaxLength),
+		envCacheHeaders:                os.Getenv(envCacheHeaders),
+		envRequestContentLengthEnabled: os.Getenv(envRequestContentLengthEnabled),
+		envSchemeEnabled:               os.Getenv(envSchemeEnabled),
+		envForwardedProtoHeader:        os.Getenv(envForwardedProtoHeader),
 	}
 	for k := range env {
 		os.Unsetenv(k)
 	}
 	return func() {
 		for k, v := range env {
-			os.Setenv(k, v)
+			if v == "" {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, v)
+			}
 		}
 	}
 }
@@ -10,9 +10,12 @@ package httptrace
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
@@ -24,13 +27,83 @@ import (
 var cfg = newConfig()
 
 // StartRequestSpan starts an HTTP request span with the standard list of HTTP request span tags (http.method, http.url,
-// http.useragent). Any further span start option can be added with opts.
+// http.useragent). Any further span start option can be added with opts. The span is a child of the span context
+// extracted from the request headers by the global propagator, unless opts already specify a parent.
 func StartRequestSpan(r *http.Request, opts ...ddtrace.StartSpanOption) (tracer.Span, context.Context) {
+	var startCfg ddtrace.StartSpanConfig
+	for _, fn := range opts {
+		fn(&startCfg)
+	}
+	var parent ddtrace.SpanContext
+	if startCfg.Parent == nil {
+		if spanctx, err := tracer.Extract(tracer.HTTPHeadersCarrier(r.Header)); err == nil {
+			parent = spanctx
+		}
+	}
+	return startRequestSpan(r, parent, opts)
+}
+
+// StartRequestSpanWithPropagator is like StartRequestSpan, except that the span is a child of the span context
+// extracted from the request headers by the given propagator. The global propagator isn't used, even when p fails
+// to extract a span context.
+func StartRequestSpanWithPropagator(r *http.Request, p tracer.Propagator, opts ...ddtrace.StartSpanOption) (tracer.Span, context.Context) {
+	var parent ddtrace.SpanContext
+	if spanctx, err := p.Extract(tracer.HTTPHeadersCarrier(r.Header)); err == nil {
+		parent = spanctx
+	}
+	return startRequestSpan(r, parent, opts)
+}
+
+// startRequestSpan starts the HTTP request span as a child of the given parent, if not nil.
+func startRequestSpan(r *http.Request, parent ddtrace.SpanContext, opts []ddtrace.StartSpanOption) (tracer.Span, context.Context) {
+	deadline, hasDeadline := RequestDeadline(r)
+	opts = requestSpanOptions(r, deadline, hasDeadline, opts)
+	if parent != nil {
+		opts = append(opts, tracer.ChildOf(parent))
+	}
+	span, ctx := tracer.StartSpanFromContext(r.Context(), "http.request", opts...)
+	if hasDeadline {
+		ctx = context.WithValue(ctx, deadlineKey{}, deadline)
+	}
+	return span, ctx
+}
+
+// SetRequestSpanTags sets the standard list of HTTP request span tags set by StartRequestSpan on the given span, along
+// with the tags of the given span start options. It allows enriching an existing span instead of starting a new one.
+// Empty service and resource names are ignored so that the span keeps its own. It returns the request context, holding
+// the request deadline for ApplyRequestDeadline like the context returned by StartRequestSpan.
+func SetRequestSpanTags(s tracer.Span, r *http.Request, opts ...ddtrace.StartSpanOption) context.Context {
+	deadline, hasDeadline := RequestDeadline(r)
+	var cfg ddtrace.StartSpanConfig
+	for _, fn := range requestSpanOptions(r, deadline, hasDeadline, opts) {
+		fn(&cfg)
+	}
+	for k, v := range cfg.Tags {
+		if (k == ext.ServiceName || k == ext.ResourceName) && v == "" {
+			continue
+		}
+		s.SetTag(k, v)
+	}
+	if !hasDeadline {
+		return r.Context()
+	}
+	return context.WithValue(r.Context(), deadlineKey{}, deadline)
+}
+
+// requestSpanOptions returns the span start options of the standard list of HTTP request span tags followed by opts.
+// The deadline of the request is tagged when hasDeadline is true.
+func requestSpanOptions(r *http.Request, deadline time.Time, hasDeadline bool, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	method := r.Method
+	if !cfg.rawMethod {
+		// HTTP methods are case-sensitive but clients may still send non-uppercase standard methods, which would
+		// otherwise be reported as distinct values.
+		method = strings.ToUpper(method)
+	}
 	// Append our span options before the given ones so that the caller can "overwrite" them.
 	// TODO(): rework span start option handling (https://github.com/DataDog/dd-trace-go/issues/1352)
 	opts = append([]ddtrace.StartSpanOption{
 		tracer.SpanType(ext.SpanTypeWeb),
-		tracer.Tag(ext.HTTPMethod, r.Method),
+		tracer.Tag(ext.HTTPMethod, method),
 		tracer.Tag(ext.HTTPURL, urlFromRequest(r)),
 		tracer.Tag(ext.HTTPUserAgent, r.UserAgent()),
 		tracer.Measured(),
@@ -40,8 +113,14 @@ func StartRequestSpan(r *http.Request, opts ...ddtrace.StartSpanOption) (tracer.
 			tracer.Tag("http.host", r.Host),
 		}, opts...)
 	}
-	if spanctx, err := tracer.Extract(tracer.HTTPHeadersCarrier(r.Header)); err == nil {
-		opts = append(opts, tracer.ChildOf(spanctx))
+	if cfg.scheme {
+		// the integrations can override it with SchemeTag
+		opts = append([]ddtrace.StartSpanOption{
+			tracer.Tag("http.scheme", RequestScheme(r, cfg.forwardedProto)),
+		}, opts...)
+	}
+	if hasDeadline {
+		opts = append(opts, tracer.Tag("http.request.deadline", deadline.UTC().Format(time.RFC3339Nano)))
 	}
 	if cfg.traceClientIP {
 		ipTags, _ := httpsec.ClientIPTags(r.Header, true, r.RemoteAddr)
@@ -49,23 +128,225 @@ func StartRequestSpan(r *http.Request, opts ...ddtrace.StartSpanOption) (tracer.
 			opts = append(opts, tracer.Tag(k, v))
 		}
 	}
-	return tracer.StartSpanFromContext(r.Context(), "http.request", opts...)
+	return opts
 }
 
 // FinishRequestSpan finishes the given HTTP request span and sets the expected response-related tags such as the status
 // code. Any further span finish option can be added with opts.
 func FinishRequestSpan(s tracer.Span, status int, opts ...tracer.FinishOption) {
-	var statusStr string
+	FinishRequestSpanWithStatusCheck(s, status, nil, opts...)
+}
+
+// FinishRequestSpanWithStatusCheck is like FinishRequestSpan, except that the span is marked as an error when the given
+// isStatusError function reports its status code as an error, instead of when it is a 5xx status code. It allows the
+// integrations to honor their status check option. A nil isStatusError defaults to the 5xx status codes.
+func FinishRequestSpanWithStatusCheck(s tracer.Span, status int, isStatusError func(statusCode int) bool, opts ...tracer.FinishOption) {
+	setResponseSpanTags(s, status, isStatusError)
+	s.Finish(opts...)
+}
+
+// SetResponseSpanTags sets the expected response-related tags set by FinishRequestSpan, such as the status code, on the
+// given span without finishing it.
+func SetResponseSpanTags(s tracer.Span, status int) {
+	setResponseSpanTags(s, status, nil)
+}
+
+func setResponseSpanTags(s tracer.Span, status int, isStatusError func(statusCode int) bool) {
 	if status == 0 {
-		statusStr = "200"
-	} else {
-		statusStr = strconv.Itoa(status)
+		status = http.StatusOK
+	}
+	if isStatusError == nil {
+		isStatusError = isServerError
 	}
+	statusStr := strconv.Itoa(status)
 	s.SetTag(ext.HTTPCode, statusStr)
-	if status >= 500 && status < 600 {
+	if isStatusError(status) {
 		s.SetTag(ext.Error, fmt.Errorf("%s: %s", statusStr, http.StatusText(status)))
 	}
-	s.Finish(opts...)
+}
+
+// isServerError reports whether the given status code is a 5xx server error.
+func isServerError(statusCode int) bool {
+	return statusCode >= 500 && statusCode < 600
+}
+
+// SetResponseCacheTag tags the given span with "http.cache" set to "hit" or "miss" according to the given response
+// headers, such as X-Cache or Age, specified by the integration. The first of them present in the response decides: an
+// Age header means a cache hit, and any other header is a hit or a miss when its value contains "hit" or "miss" (e.g.
+// "HIT from cloudfront"). The span is left untouched when no header is specified, or when the cache status cannot be
+// told.
+func SetResponseCacheTag(s tracer.Span, cacheHeaders []string, h http.Header) {
+	if status, ok := cacheStatus(cacheHeaders, h); ok {
+		s.SetTag("http.cache", status)
+	}
+}
+
+// DefaultCacheHeaders returns the canonical names of the response headers specified by the DD_TRACE_HTTP_CACHE_HEADERS
+// env var, used by default by the integrations tagging the response cache status. The returned slice must not be
+// modified.
+func DefaultCacheHeaders() []string {
+	return cfg.cacheHeaders
+}
+
+// cacheStatus returns the cache status of the response with the given headers, according to the given cache headers.
+func cacheStatus(cacheHeaders []string, h http.Header) (string, bool) {
+	for _, name := range cacheHeaders {
+		v := h.Get(name)
+		if v == "" {
+			continue
+		}
+		if http.CanonicalHeaderKey(name) == "Age" {
+			if _, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64); err == nil {
+				return "hit", true
+			}
+			continue
+		}
+		v = strings.ToLower(v)
+		if strings.Contains(v, "hit") {
+			return "hit", true
+		}
+		if strings.Contains(v, "miss") {
+			return "miss", true
+		}
+	}
+	return "", false
+}
+
+// RequestContentLengthTag returns the span start option tagging the request span with the request Content-Length
+// declared by the client, as "http.request.content_length". It is available before the request body is read, but
+// reflects the declared length rather than the number of bytes actually read. It reports false when the length is
+// unknown, such as for chunked requests. The integrations add it when their own option enables it.
+func RequestContentLengthTag(r *http.Request) (ddtrace.StartSpanOption, bool) {
+	// a zero length is also used by net/http when the request has no body and no Content-Length header
+	if r.ContentLength < 0 || (r.ContentLength == 0 && r.Header.Get("Content-Length") == "") {
+		return nil, false
+	}
+	return tracer.Tag("http.request.content_length", r.ContentLength), true
+}
+
+// RequestContentLengthEnabled reports whether the DD_TRACE_HTTP_REQUEST_CONTENT_LENGTH_ENABLED env var is true, which
+// is the default of the integration options tagging the request spans with RequestContentLengthTag.
+func RequestContentLengthEnabled() bool {
+	return cfg.contentLength
+}
+
+// RequestScheme returns the scheme of the request, "http" or "https". The scheme found in the given request header, such
+// as X-Forwarded-Proto, takes precedence when valid, so that the scheme of the requests received by a TLS-terminating
+// proxy is reported. Otherwise, the scheme of the connection is returned.
+func RequestScheme(r *http.Request, forwardedProtoHeader string) string {
+	if forwardedProtoHeader != "" {
+		// proxies may append their own value to the list of forwarded values, the first one being the client's
+		v := r.Header.Get(forwardedProtoHeader)
+		if i := strings.IndexByte(v, ','); i >= 0 {
+			v = v[:i]
+		}
+		switch v = strings.ToLower(strings.TrimSpace(v)); v {
+		case "http", "https":
+			return v
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// SchemeTag returns the span start option overriding the "http.scheme" tag set by default by StartRequestSpan, according
+// to the DD_TRACE_HTTP_SCHEME_ENABLED and DD_TRACE_HTTP_FORWARDED_PROTO_HEADER env vars. The tag is removed when enabled
+// is false, and is otherwise set to the scheme returned by RequestScheme with the given forwarded proto header.
+func SchemeTag(r *http.Request, enabled bool, forwardedProtoHeader string) ddtrace.StartSpanOption {
+	if !enabled {
+		return func(cfg *ddtrace.StartSpanConfig) {
+			delete(cfg.Tags, "http.scheme")
+		}
+	}
+	return tracer.Tag("http.scheme", RequestScheme(r, forwardedProtoHeader))
+}
+
+// RawPathTag returns the span start option tagging the request span with the concrete request path, without its query
+// string, as "http.path". It allows seeing the actual paths of the requests whose resource name is set to their
+// templated route, at the cost of a high cardinality, and should thus only be used when opted-in.
+func RawPathTag(r *http.Request) ddtrace.StartSpanOption {
+	return tracer.Tag("http.path", r.URL.Path)
+}
+
+// RequestDeadline returns the deadline resulting from the timeout found in the request header specified by the
+// DD_TRACE_HTTP_DEADLINE_HEADER env var, such as grpc-timeout. It reports false when the env var isn't set, or when
+// the header is missing or malformed. The integrations applying the deadline to the request context must use
+// ApplyRequestDeadline instead, which reuses the deadline tagged on the request span.
+func RequestDeadline(r *http.Request) (time.Time, bool) {
+	if cfg.deadlineHeader == "" {
+		return time.Time{}, false
+	}
+	timeout, ok := parseTimeout(r.Header.Get(cfg.deadlineHeader))
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Now().Add(timeout), true
+}
+
+// deadlineKey is the context key of the request deadline computed by StartRequestSpan and SetRequestSpanTags.
+type deadlineKey struct{}
+
+// ApplyRequestDeadline returns a copy of ctx expiring at the request deadline tagged on the request span, when the
+// context was returned by StartRequestSpan or SetRequestSpanTags for a request holding a timeout header. Otherwise, ctx
+// is returned as is. The returned cancel function must be called once the request is served.
+func ApplyRequestDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Value(deadlineKey{}).(time.Time)
+	if !ok {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// timeoutUnits are the units of the grpc-timeout header values.
+var timeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// parseTimeout parses the given timeout header value in the grpc-timeout format: an integer of at most 8 digits
+// followed by one of the H, M, S, m, u or n units, such as "5m" for 5 milliseconds. The time.ParseDuration format
+// isn't accepted as it gives a different meaning to the same values.
+func parseTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	unit, ok := timeoutUnits[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+	digits := v[:len(v)-1]
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			return 0, false
+		}
+	}
+	// Reject the values overflowing time.Duration, which 8 digits of hours or minutes can do
+	n, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || n > uint64(math.MaxInt64/unit) {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// HeaderTagValue returns the given header value to be used as a span tag value, truncated beyond the maximum length
+// specified by the DD_TRACE_HEADER_TAGS_MAX_LENGTH env var (1024 bytes by default) and suffixed with "..." in that
+// case. Integrations capturing request or response headers as span tags must use it to avoid oversized tags.
+func HeaderTagValue(v string) string {
+	max := cfg.headerTagMaxLen
+	if max < 0 || len(v) <= max {
+		return v
+	}
+	// Avoid cutting a multi-byte UTF-8 character in half
+	for max > 0 && !utf8.RuneStart(v[max]) {
+		max--
+	}
+	return v[:max] + "..."
 }
 
 // urlFromRequest returns the full URL from the HTTP request. If query params are collected, they are obfuscated granted
@@ -6,18 +6,23 @@
 package httptrace
 
 import (
+	"crypto/tls"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DataDog/appsec-internal-go/netip"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 )
 
@@ -34,6 +39,328 @@ func TestStartRequestSpan(t *testing.T) {
 
 // TestClientIP tests behavior of StartRequestSpan based on
 // the DD_TRACE_CLIENT_IP_ENABLED environment variable
+func TestMethodTag(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	oldConfig := cfg
+	defer func() { cfg = oldConfig }()
+
+	for _, tc := range []struct {
+		name      string
+		rawMethod bool
+		expected  string
+	}{
+		{name: "normalized", expected: "GET"},
+		{name: "raw", rawMethod: true, expected: "get"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer mt.Reset()
+			cfg.rawMethod = tc.rawMethod
+			r := httptest.NewRequest("get", "/somePath", nil)
+			s, _ := StartRequestSpan(r)
+			s.Finish()
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.expected, spans[0].Tag(ext.HTTPMethod))
+		})
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	oldConfig := cfg
+	defer func() { cfg = oldConfig }()
+
+	for _, tc := range []struct {
+		name     string
+		header   string
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{name: "unset", value: "5S"},
+		{name: "missing", header: "grpc-timeout"},
+		{name: "grpc-seconds", header: "grpc-timeout", value: "5S", expected: 5 * time.Second, ok: true},
+		{name: "grpc-millis", header: "grpc-timeout", value: "100m", expected: 100 * time.Millisecond, ok: true},
+		{name: "grpc-minutes-not-millis", header: "grpc-timeout", value: "5M", expected: 5 * time.Minute, ok: true},
+		{name: "grpc-not-duration", header: "grpc-timeout", value: "5m", expected: 5 * time.Millisecond, ok: true},
+		{name: "duration", header: "grpc-timeout", value: "1m30s"},
+		{name: "signed", header: "grpc-timeout", value: "+5S"},
+		{name: "too-long", header: "grpc-timeout", value: "123456789S"},
+		{name: "max-hours", header: "grpc-timeout", value: "2562047H", expected: 2562047 * time.Hour, ok: true},
+		{name: "overflow-hours", header: "grpc-timeout", value: "99999999H"},
+		{name: "malformed", header: "grpc-timeout", value: "soon"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer mt.Reset()
+			cfg.deadlineHeader = tc.header
+			r := httptest.NewRequest(http.MethodGet, "/somePath", nil)
+			r.Header.Set("grpc-timeout", tc.value)
+			start := time.Now()
+			deadline, ok := RequestDeadline(r)
+			require.Equal(t, tc.ok, ok)
+			s, ctx := StartRequestSpan(r)
+			s.Finish()
+			ctx, cancel := ApplyRequestDeadline(ctx)
+			defer cancel()
+			applied, hasDeadline := ctx.Deadline()
+			require.Equal(t, tc.ok, hasDeadline)
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			tag := spans[0].Tag("http.request.deadline")
+			if !tc.ok {
+				assert.Nil(t, tag)
+				return
+			}
+			assert.WithinDuration(t, start.Add(tc.expected), deadline, time.Second)
+			require.IsType(t, "", tag)
+			tagged, err := time.Parse(time.RFC3339Nano, tag.(string))
+			require.NoError(t, err)
+			// the context expires at the tagged deadline
+			assert.True(t, applied.Equal(tagged))
+		})
+	}
+}
+
+func TestHeaderTagValue(t *testing.T) {
+	oldConfig := cfg
+	defer func() { cfg = oldConfig }()
+
+	for _, tc := range []struct {
+		name     string
+		maxLen   int
+		value    string
+		expected string
+	}{
+		{name: "short", maxLen: 8, value: "abc", expected: "abc"},
+		{name: "exact", maxLen: 3, value: "abc", expected: "abc"},
+		{name: "truncated", maxLen: 3, value: "abcdef", expected: "abc..."},
+		{name: "multi-byte", maxLen: 2, value: "aéb", expected: "a..."},
+		{name: "unlimited", maxLen: -1, value: "abcdef", expected: "abcdef"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg.headerTagMaxLen = tc.maxLen
+			assert.Equal(t, tc.expected, HeaderTagValue(tc.value))
+		})
+	}
+}
+
+func TestSetResponseCacheTag(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		headers  []string
+		response http.Header
+		expected interface{}
+	}{
+		{name: "x-cache-hit", headers: []string{"X-Cache"}, response: http.Header{"X-Cache": {"HIT from cloudfront"}}, expected: "hit"},
+		{name: "x-cache-miss", headers: []string{"X-Cache"}, response: http.Header{"X-Cache": {"Miss from cloudfront"}}, expected: "miss"},
+		{name: "x-cache-unknown", headers: []string{"X-Cache"}, response: http.Header{"X-Cache": {"bypass"}}},
+		{name: "age", headers: []string{"X-Cache", "Age"}, response: http.Header{"Age": {"42"}}, expected: "hit"},
+		{name: "age-lowercase", headers: []string{"age"}, response: http.Header{"Age": {"42"}}, expected: "hit"},
+		{name: "bad-age", headers: []string{"Age"}, response: http.Header{"Age": {"yesterday"}}},
+		{name: "first-header", headers: []string{"X-Cache", "Age"}, response: http.Header{"X-Cache": {"MISS"}, "Age": {"42"}}, expected: "miss"},
+		{name: "no-header", headers: []string{"X-Cache"}, response: http.Header{}},
+		{name: "disabled", response: http.Header{"X-Cache": {"HIT"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+			span := tracer.StartSpan("test")
+			SetResponseCacheTag(span, tc.headers, tc.response)
+			span.Finish()
+			assert.Equal(t, tc.expected, mt.FinishedSpans()[0].Tag("http.cache"))
+		})
+	}
+}
+
+func TestFinishRequestSpanWithStatusCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		status        int
+		isStatusError func(statusCode int) bool
+		code          string
+		err           interface{}
+	}{
+		{name: "default-ok", status: 200, code: "200"},
+		{name: "default-unset", status: 0, code: "200"},
+		{name: "default-server-error", status: 503, code: "503", err: "503: Service Unavailable"},
+		{name: "client-errors", status: 404, isStatusError: func(code int) bool { return code >= 400 && code < 500 }, code: "404", err: "404: Not Found"},
+		{name: "client-errors-server-error", status: 500, isStatusError: func(code int) bool { return code >= 400 && code < 500 }, code: "500"},
+		{name: "unset-as-200", status: 0, isStatusError: func(code int) bool { return code == 200 }, code: "200", err: "200: OK"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			FinishRequestSpanWithStatusCheck(tracer.StartSpan("test"), tc.status, tc.isStatusError)
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.code, spans[0].Tag(ext.HTTPCode))
+			if tc.err == nil {
+				assert.Nil(t, spans[0].Tag(ext.Error))
+			} else {
+				err, ok := spans[0].Tag(ext.Error).(error)
+				require.True(t, ok)
+				assert.Equal(t, tc.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestRequestContentLengthTag(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		enabled  bool
+		r        func() *http.Request
+		expected interface{}
+	}{
+		{
+			name:     "body",
+			enabled:  true,
+			r:        func() *http.Request { return httptest.NewRequest("POST", "/", strings.NewReader("hello")) },
+			expected: int64(5),
+		},
+		{
+			name: "disabled",
+			r:    func() *http.Request { return httptest.NewRequest("POST", "/", strings.NewReader("hello")) },
+		},
+		{
+			name:    "no-body",
+			enabled: true,
+			r:       func() *http.Request { return httptest.NewRequest("GET", "/", nil) },
+		},
+		{
+			name:    "declared-zero",
+			enabled: true,
+			r: func() *http.Request {
+				r := httptest.NewRequest("POST", "/", nil)
+				r.Header.Set("Content-Length", "0")
+				return r
+			},
+			expected: int64(0),
+		},
+		{
+			name:    "chunked",
+			enabled: true,
+			r: func() *http.Request {
+				r := httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+				r.ContentLength = -1
+				return r
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+			r := tc.r()
+			var opts []ddtrace.StartSpanOption
+			if opt, ok := RequestContentLengthTag(r); ok && tc.enabled {
+				opts = append(opts, opt)
+			}
+			span, _ := StartRequestSpan(r, opts...)
+			span.Finish()
+			assert.Equal(t, tc.expected, mt.FinishedSpans()[0].Tag("http.request.content_length"))
+		})
+	}
+}
+
+func TestRequestScheme(t *testing.T) {
+	oldConfig := cfg
+	defer func() { cfg = oldConfig }()
+
+	for _, tc := range []struct {
+		name     string
+		enabled  bool
+		header   string
+		tls      bool
+		proto    string
+		expected interface{}
+	}{
+		{name: "http", enabled: true, expected: "http"},
+		{name: "https", enabled: true, tls: true, expected: "https"},
+		{name: "disabled", tls: true},
+		{name: "forwarded", enabled: true, header: "X-Forwarded-Proto", proto: "https", expected: "https"},
+		{name: "forwarded-list", enabled: true, header: "X-Forwarded-Proto", proto: "HTTPS, http", expected: "https"},
+		{name: "forwarded-downgrade", enabled: true, header: "X-Forwarded-Proto", tls: true, proto: "http", expected: "http"},
+		{name: "forwarded-invalid", enabled: true, header: "X-Forwarded-Proto", tls: true, proto: "ws", expected: "https"},
+		{name: "forwarded-missing", enabled: true, header: "X-Forwarded-Proto", expected: "http"},
+		{name: "header-not-configured", enabled: true, proto: "https", expected: "http"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+			cfg.scheme = tc.enabled
+			cfg.forwardedProto = tc.header
+
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if tc.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tc.proto)
+			}
+			span, _ := StartRequestSpan(r)
+			span.Finish()
+			assert.Equal(t, tc.expected, mt.FinishedSpans()[0].Tag("http.scheme"))
+		})
+	}
+}
+
+func TestSchemeTag(t *testing.T) {
+	oldConfig := cfg
+	defer func() { cfg = oldConfig }()
+
+	for _, tc := range []struct {
+		name     string
+		enabled  bool // default configuration
+		override func(r *http.Request) ddtrace.StartSpanOption
+		expected interface{}
+	}{
+		{
+			name:     "disable",
+			enabled:  true,
+			override: func(r *http.Request) ddtrace.StartSpanOption { return SchemeTag(r, false, "") },
+		},
+		{
+			name:     "forwarded",
+			enabled:  true,
+			override: func(r *http.Request) ddtrace.StartSpanOption { return SchemeTag(r, true, "X-Forwarded-Proto") },
+			expected: "https",
+		},
+		{
+			name:     "enable",
+			override: func(r *http.Request) ddtrace.StartSpanOption { return SchemeTag(r, true, "") },
+			expected: "http",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+			cfg.scheme = tc.enabled
+			cfg.forwardedProto = ""
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Forwarded-Proto", "https")
+			span, _ := StartRequestSpan(r, tc.override(r))
+			span.Finish()
+			assert.Equal(t, tc.expected, mt.FinishedSpans()[0].Tag("http.scheme"))
+		})
+	}
+}
+
+func TestRawPathTag(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	r := httptest.NewRequest("GET", "/users/123?token=secret", nil)
+	span, _ := StartRequestSpan(r, RawPathTag(r))
+	span.Finish()
+	assert.Equal(t, "/users/123", mt.FinishedSpans()[0].Tag("http.path"))
+}
+
 func TestTraceClientIPFlag(t *testing.T) {
 	mt := mocktracer.Start()
 	defer mt.Stop()
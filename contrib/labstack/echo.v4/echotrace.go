@@ -7,6 +7,7 @@
 package echo
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
@@ -46,7 +47,7 @@ func Middleware(opts ...Option) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			// If we have an ignoreRequestFunc, use it to see if we proceed with tracing
-			if cfg.ignoreRequestFunc != nil && cfg.ignoreRequestFunc(c) {
+			if (cfg.ignoreRequestFunc != nil && cfg.ignoreRequestFunc(c)) || isIgnoredRoute(cfg, c) {
 				if err := next(c); err != nil {
 					c.Error(err)
 					return err
@@ -62,6 +63,14 @@ func Middleware(opts ...Option) echo.MiddlewareFunc {
 			if !math.IsNaN(cfg.analyticsRate) {
 				opts = append(opts, tracer.Tag(ext.EventSampleRate, cfg.analyticsRate))
 			}
+			if cfg.contentLength {
+				if opt, ok := httptrace.RequestContentLengthTag(request); ok {
+					opts = append(opts, opt)
+				}
+			}
+			if cfg.schemeTag != nil {
+				opts = append(opts, cfg.schemeTag(request))
+			}
 
 			var finishOpts []tracer.FinishOption
 			if cfg.noDebugStack {
@@ -73,6 +82,11 @@ func Middleware(opts ...Option) echo.MiddlewareFunc {
 				span.Finish(finishOpts...)
 			}()
 
+			if cfg.applyDeadline {
+				var cancel context.CancelFunc
+				ctx, cancel = httptrace.ApplyRequestDeadline(ctx)
+				defer cancel()
+			}
 			// pass the span through the request context
 			c.SetRequest(request.WithContext(ctx))
 
@@ -91,6 +105,10 @@ func Middleware(opts ...Option) echo.MiddlewareFunc {
 				if errors.As(err, &echoErr) {
 					if cfg.isStatusError(echoErr.Code) {
 						finishOpts = append(finishOpts, tracer.WithError(err))
+					} else if echoErr.Code >= 400 && echoErr.Code < 500 {
+						// Client errors, such as binding and validation errors, aren't server faults but are
+						// still tagged for visibility.
+						span.SetTag("echo.error_kind", "client")
 					}
 					span.SetTag(ext.HTTPCode, strconv.Itoa(echoErr.Code))
 				} else {
@@ -115,3 +133,50 @@ func Middleware(opts ...Option) echo.MiddlewareFunc {
 		}
 	}
 }
+
+// isIgnoredRoute reports whether the route of the given request, as resolved by
+// the echo router, is one of the routes configured with WithIgnoreRoutes. The
+// name of the route is looked up once per Echo instance, method and path, so
+// that the routes registered after the first requests are still resolved.
+func isIgnoredRoute(cfg *config, c echo.Context) bool {
+	if len(cfg.ignoreRoutes) == 0 {
+		return false
+	}
+	path := c.Path()
+	if path == "" {
+		// The request wasn't routed yet
+		return false
+	}
+	if cfg.ignoreRoutes[path] {
+		return true
+	}
+	key := routeKey{echo: c.Echo(), method: c.Request().Method, path: path}
+	cfg.routesMu.RLock()
+	ignored, ok := cfg.ignoredRoutes[key]
+	cfg.routesMu.RUnlock()
+	if ok {
+		return ignored
+	}
+	for _, r := range key.echo.Routes() {
+		if r.Method == key.method && r.Path == path {
+			// Only the registered routes are cached, so that the cache stays
+			// bounded regardless of the methods sent by the clients
+			ignored = r.Name != "" && cfg.ignoreRoutes[r.Name]
+			cfg.routesMu.Lock()
+			if cfg.ignoredRoutes == nil {
+				cfg.ignoredRoutes = make(map[routeKey]bool)
+			}
+			cfg.ignoredRoutes[key] = ignored
+			cfg.routesMu.Unlock()
+			return ignored
+		}
+	}
+	return false
+}
+
+// routeKey identifies a route of an Echo instance.
+type routeKey struct {
+	echo   *echo.Echo
+	method string
+	path   string
+}
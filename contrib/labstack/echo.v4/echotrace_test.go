@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
@@ -331,6 +332,72 @@ func TestStatusError(t *testing.T) {
 	}
 }
 
+func TestClientError(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		isStatusError func(statusCode int) bool
+		handler       func(c echo.Context) error
+		errored       bool
+		errorKind     interface{}
+	}{
+		{
+			name: "http-error",
+			handler: func(c echo.Context) error {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
+			},
+			errorKind: "client",
+		},
+		{
+			name: "bind-error",
+			handler: func(c echo.Context) error {
+				var v struct {
+					ID int `json:"id"`
+				}
+				return c.Bind(&v)
+			},
+			errorKind: "client",
+		},
+		{
+			name:          "status-error",
+			isStatusError: func(statusCode int) bool { return statusCode >= 400 },
+			handler: func(c echo.Context) error {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid payload")
+			},
+			errored: true,
+		},
+		{
+			name: "server-error",
+			handler: func(c echo.Context) error {
+				return echo.NewHTTPError(http.StatusInternalServerError, "my error message")
+			},
+			errored: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			router := echo.New()
+			var opts []Option
+			if tt.isStatusError != nil {
+				opts = append(opts, WithStatusCheck(tt.isStatusError))
+			}
+			router.Use(Middleware(opts...))
+			router.POST("/users", tt.handler)
+			r := httptest.NewRequest("POST", "/users", strings.NewReader("{"))
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			span := spans[0]
+			assert.Equal(t, tt.errored, span.Tag(ext.Error) != nil)
+			assert.Equal(t, tt.errorKind, span.Tag("echo.error_kind"))
+		})
+	}
+}
+
 func TestGetSpanNotInstrumented(t *testing.T) {
 	assert := assert.New(t)
 	router := echo.New()
@@ -419,3 +486,109 @@ func TestIgnoreRequestFunc(t *testing.T) {
 	spans := mt.FinishedSpans()
 	assert.Len(spans, 0)
 }
+
+func TestRequestContentLength(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		expected interface{}
+	}{
+		{name: "enabled", opts: []Option{WithRequestContentLength(true)}, expected: int64(5)},
+		{name: "disabled", opts: []Option{WithRequestContentLength(false)}},
+		{name: "default"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			router := echo.New()
+			router.Use(Middleware(tc.opts...))
+			router.POST("/user", func(c echo.Context) error { return c.NoContent(200) })
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/user", strings.NewReader("hello")))
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.expected, spans[0].Tag("http.request.content_length"))
+		})
+	}
+}
+
+func TestSchemeTag(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		expected interface{}
+	}{
+		{name: "default", expected: "http"},
+		{name: "forwarded", opts: []Option{WithSchemeTag(true, "X-Forwarded-Proto")}, expected: "https"},
+		{name: "disabled", opts: []Option{WithSchemeTag(false, "")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			router := echo.New()
+			router.Use(Middleware(tc.opts...))
+			router.GET("/user", func(c echo.Context) error { return c.NoContent(200) })
+			r := httptest.NewRequest("GET", "/user", nil)
+			r.Header.Set("X-Forwarded-Proto", "https")
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.expected, spans[0].Tag("http.scheme"))
+		})
+	}
+}
+
+func TestIgnoreRoutes(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	router := echo.New()
+	router.Use(Middleware(WithIgnoreRoutes("/health", "metrics")))
+	router.GET("/health", func(c echo.Context) error { return c.NoContent(200) })
+	router.GET("/metrics", func(c echo.Context) error { return c.NoContent(200) }).Name = "metrics"
+	router.GET("/users/:id", func(c echo.Context) error { return c.NoContent(200) })
+
+	for _, path := range []string{"/health", "/metrics", "/users/123"} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, 200, w.Code)
+	}
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "GET /users/:id", spans[0].Tag(ext.ResourceName))
+
+	t.Run("late-routes", func(t *testing.T) {
+		mt.Reset()
+		mw := Middleware(WithIgnoreRoutes("metrics"))
+		routers := []*echo.Echo{echo.New(), echo.New()}
+		for _, router := range routers {
+			router.Use(mw)
+			router.GET("/users/:id", func(c echo.Context) error { return c.NoContent(200) })
+		}
+		serve := func(router *echo.Echo, method, path string) {
+			r := httptest.NewRequest(method, path, nil)
+			router.ServeHTTP(httptest.NewRecorder(), r)
+		}
+		serve(routers[0], "GET", "/users/123")
+		// the routes registered after the first request are resolved, per Echo instance
+		routers[0].GET("/metrics", func(c echo.Context) error { return c.NoContent(200) }).Name = "metrics"
+		routers[1].GET("/metrics", func(c echo.Context) error { return c.NoContent(200) })
+		routers[1].POST("/users/:id", func(c echo.Context) error { return c.NoContent(200) }).Name = "metrics"
+		serve(routers[0], "GET", "/metrics")
+		serve(routers[1], "GET", "/metrics")
+		serve(routers[1], "GET", "/users/123")
+		serve(routers[1], "POST", "/users/123")
+
+		spans := mt.FinishedSpans()
+		var resources []string
+		for _, s := range spans {
+			resources = append(resources, s.Tag(ext.ResourceName).(string))
+		}
+		assert.Equal(t, []string{"GET /users/:id", "GET /metrics", "GET /users/:id"}, resources)
+	})
+}
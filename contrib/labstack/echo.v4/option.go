@@ -7,9 +7,13 @@ package echo
 
 import (
 	"math"
+	"net/http"
+	"sync"
 
 	"github.com/labstack/echo/v4"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/httptrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
 )
 
@@ -18,13 +22,23 @@ type config struct {
 	analyticsRate     float64
 	noDebugStack      bool
 	ignoreRequestFunc IgnoreRequestFunc
+	ignoreRoutes      map[string]bool
+	routesMu          sync.RWMutex      // guards ignoredRoutes
+	ignoredRoutes     map[routeKey]bool // whether the routes resolved by isIgnoredRoute are ignored
 	isStatusError     func(statusCode int) bool
+	applyDeadline     bool
+	contentLength     bool
+	schemeTag         func(r *http.Request) ddtrace.StartSpanOption
 }
 
 // Option represents an option that can be passed to Middleware.
 type Option func(*config)
 
 // IgnoreRequestFunc determines if tracing will be skipped for a request.
+// The route of the request, such as its path template returned by c.Path(),
+// is only available when the middleware is registered with Echo.Use(), as the
+// middleware functions registered with Echo.Pre() run before routing. In that
+// case, c.Path() is empty and only the raw request path can be used.
 type IgnoreRequestFunc func(c echo.Context) bool
 
 func defaults(cfg *config) {
@@ -34,6 +48,7 @@ func defaults(cfg *config) {
 	}
 	cfg.analyticsRate = math.NaN()
 	cfg.isStatusError = isServerError
+	cfg.contentLength = httptrace.RequestContentLengthEnabled()
 }
 
 // WithServiceName sets the given service name for the system.
@@ -83,6 +98,52 @@ func WithIgnoreRequest(ignoreRequestFunc IgnoreRequestFunc) Option {
 	}
 }
 
+// WithIgnoreRoutes skips tracing the requests matching one of the given routes,
+// specified either by their path template (e.g. "/users/:id") or by their
+// route name (cf. echo.Route.Name). Routes are matched after routing, so
+// the middleware must be registered with Echo.Use() rather than Echo.Pre().
+// It can be combined with WithIgnoreRequest, in which case a request is not
+// traced as soon as either of them skips it.
+func WithIgnoreRoutes(routes ...string) Option {
+	return func(cfg *config) {
+		if cfg.ignoreRoutes == nil {
+			cfg.ignoreRoutes = make(map[string]bool, len(routes))
+		}
+		for _, r := range routes {
+			cfg.ignoreRoutes[r] = true
+		}
+	}
+}
+
+// WithRequestDeadline applies the deadline resulting from the timeout header
+// specified by the DD_TRACE_HTTP_DEADLINE_HEADER env var, such as grpc-timeout,
+// to the request context. The deadline is tagged on the request span regardless.
+func WithRequestDeadline() Option {
+	return func(cfg *config) {
+		cfg.applyDeadline = true
+	}
+}
+
+// WithRequestContentLength enables or disables tagging the spans with the request
+// Content-Length declared by the client, as "http.request.content_length". It
+// defaults to the DD_TRACE_HTTP_REQUEST_CONTENT_LENGTH_ENABLED env var.
+func WithRequestContentLength(on bool) Option {
+	return func(cfg *config) {
+		cfg.contentLength = on
+	}
+}
+
+// WithSchemeTag enables or disables the "http.scheme" tag, enabled by default,
+// found in the given forwarded proto header, such as X-Forwarded-Proto, if set.
+// It overrides DD_TRACE_HTTP_SCHEME_ENABLED and DD_TRACE_HTTP_FORWARDED_PROTO_HEADER.
+func WithSchemeTag(enabled bool, forwardedProtoHeader string) Option {
+	return func(cfg *config) {
+		cfg.schemeTag = func(r *http.Request) ddtrace.StartSpanOption {
+			return httptrace.SchemeTag(r, enabled, forwardedProtoHeader)
+		}
+	}
+}
+
 // WithStatusCheck specifies a function fn which reports whether the passed
 // statusCode should be considered an error.
 func WithStatusCheck(fn func(statusCode int) bool) Option {
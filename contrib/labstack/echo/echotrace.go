@@ -8,10 +8,8 @@ package echo
 
 import (
 	"errors"
-	"fmt"
 	"math"
 	"net/http"
-	"strconv"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/httptrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
@@ -44,9 +42,16 @@ func Middleware(opts ...Option) echo.MiddlewareFunc {
 	}
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			// If we have an ignoreRequestFunc, use it to see if we proceed with tracing
+			if cfg.ignoreRequestFunc != nil && cfg.ignoreRequestFunc(c) {
+				if err := next(c); err != nil {
+					c.Error(err)
+					return err
+				}
+				return nil
+			}
 			request := c.Request()
-			resource := request.Method + " " + c.Path()
-			opts := append(spanOpts, tracer.ResourceName(resource))
+			opts := append(spanOpts, tracer.ResourceName(resourceName(cfg, c)))
 
 			if !math.IsNaN(cfg.analyticsRate) {
 				opts = append(opts, tracer.Tag(ext.EventSampleRate, cfg.analyticsRate))
@@ -58,9 +63,9 @@ func Middleware(opts ...Option) echo.MiddlewareFunc {
 			}
 
 			span, ctx := httptrace.StartRequestSpan(request, opts...)
+			var status int
 			defer func() {
-				//httptrace.FinishRequestSpan(span, c.Response().Status, finishOpts...)
-				span.Finish(finishOpts...)
+				httptrace.FinishRequestSpanWithStatusCheck(span, status, cfg.isStatusError, finishOpts...)
 			}()
 
 			// pass the span through the request context
@@ -76,29 +81,36 @@ func Middleware(opts ...Option) echo.MiddlewareFunc {
 				// This is the best we can do.
 				var echoErr *echo.HTTPError
 				if errors.As(err, &echoErr) {
-					if cfg.isStatusError(echoErr.Code) {
-						finishOpts = append(finishOpts, tracer.WithError(err))
-					}
-					span.SetTag(ext.HTTPCode, strconv.Itoa(echoErr.Code))
+					status = echoErr.Code
 				} else {
 					// Any error that is not an *echo.HTTPError will be treated as an error with 500 status code.
-					if cfg.isStatusError(500) {
-						finishOpts = append(finishOpts, tracer.WithError(err))
-					}
-					span.SetTag(ext.HTTPCode, "500")
+					status = http.StatusInternalServerError
 				}
-			} else if status := c.Response().Status; status > 0 {
 				if cfg.isStatusError(status) {
-					finishOpts = append(finishOpts, tracer.WithError(fmt.Errorf("%d: %s", status, http.StatusText(status))))
+					// report the error itself rather than its status code
+					finishOpts = append(finishOpts, tracer.WithError(err))
 				}
-				span.SetTag(ext.HTTPCode, strconv.Itoa(status))
-			} else {
-				if cfg.isStatusError(200) {
-					finishOpts = append(finishOpts, tracer.WithError(fmt.Errorf("%d: %s", 200, http.StatusText(200))))
-				}
-				span.SetTag(ext.HTTPCode, "200")
+			} else if status = c.Response().Status; status == 0 {
+				status = http.StatusOK
 			}
 			return err
 		}
 	}
 }
+
+// resourceName returns the resource name of the span of the request of the
+// given context: the one returned by the resource namer of the config, if any,
+// or else the request method followed by the route of the request, such as
+// "GET /users/:id", falling back to the request path when the route is empty.
+func resourceName(cfg *config, c echo.Context) string {
+	if cfg.resourceNamer != nil {
+		if name := cfg.resourceNamer(c); name != "" {
+			return name
+		}
+	}
+	path := c.Path()
+	if path == "" {
+		path = c.Request().URL.Path
+	}
+	return c.Request().Method + " " + path
+}
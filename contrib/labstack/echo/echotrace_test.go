@@ -390,3 +390,148 @@ func TestNoDebugStack(t *testing.T) {
 	assert.Equal("labstack/echo", span.Tag(ext.Component))
 	assert.Equal(ext.SpanKindServer, span.Tag(ext.SpanKind))
 }
+
+func TestIgnoreRequest(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	router := echo.New()
+	router.Use(Middleware(WithIgnoreRequest(func(c echo.Context) bool {
+		return c.Path() == "/health" || c.Request().Header.Get("X-Scraper") != ""
+	})))
+	var traced []bool
+	handler := func(c echo.Context) error {
+		_, ok := tracer.SpanFromContext(c.Request().Context())
+		traced = append(traced, ok)
+		return nil
+	}
+	router.GET("/health", handler)
+	router.GET("/metrics", handler)
+	router.GET("/user/:id", handler)
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest("GET", "/health", nil),
+		func() *http.Request {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			r.Header.Set("X-Scraper", "prometheus")
+			return r
+		}(),
+		httptest.NewRequest("GET", "/user/123", nil),
+	} {
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	assert.Equal(t, []bool{false, false, true}, traced)
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "GET /user/:id", spans[0].Tag(ext.ResourceName))
+}
+
+func TestResourceName(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		pre      bool
+		path     string
+		resource string
+	}{
+		{name: "route", path: "/user/123", resource: "GET /user/:id"},
+		{name: "pre", pre: true, path: "/user/123", resource: "GET /user/123"},
+		{name: "not-found", path: "/unknown", resource: "GET /unknown"},
+		{
+			name:     "namer",
+			opts:     []Option{WithResourceNamer(func(c echo.Context) string { return "user " + c.Path() })},
+			path:     "/user/123",
+			resource: "user /user/:id",
+		},
+		{
+			name:     "namer-fallback",
+			opts:     []Option{WithResourceNamer(func(c echo.Context) string { return "" })},
+			path:     "/user/123",
+			resource: "GET /user/:id",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			router := echo.New()
+			if tc.pre {
+				router.Pre(Middleware(tc.opts...))
+			} else {
+				router.Use(Middleware(tc.opts...))
+			}
+			router.GET("/user/:id", func(c echo.Context) error { return nil })
+
+			r := httptest.NewRequest("GET", tc.path, nil)
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.resource, spans[0].Tag(ext.ResourceName))
+		})
+	}
+}
+
+func TestAnalyticsSettings(t *testing.T) {
+	assertRate := func(t *testing.T, mt mocktracer.Tracer, rate interface{}, opts ...Option) {
+		router := echo.New()
+		router.Use(Middleware(opts...))
+		router.GET("/user/:id", func(c echo.Context) error {
+			_, ok := tracer.SpanFromContext(c.Request().Context())
+			assert.True(t, ok)
+			return nil
+		})
+
+		r := httptest.NewRequest("GET", "/user/123", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, r)
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 1)
+		s := spans[0]
+		assert.Equal(t, rate, s.Tag(ext.EventSampleRate))
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		assertRate(t, mt, nil)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		assertRate(t, mt, 1.0, WithAnalytics(true))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		assertRate(t, mt, nil, WithAnalytics(false))
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		assertRate(t, mt, 0.23, WithAnalyticsRate(0.23))
+	})
+
+	t.Run("out-of-range", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		assertRate(t, mt, nil, WithAnalyticsRate(1.5))
+	})
+
+	t.Run("rate-override", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		assertRate(t, mt, 0.23, WithAnalytics(true), WithAnalyticsRate(0.23))
+	})
+}
@@ -10,18 +10,29 @@ import (
 
 	"gopkg.in/DataDog/dd-trace-go.v1/internal"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
+
+	"github.com/labstack/echo"
 )
 
 type config struct {
-	serviceName   string
-	analyticsRate float64
-	noDebugStack  bool
-	isStatusError func(statusCode int) bool
+	serviceName       string
+	analyticsRate     float64
+	noDebugStack      bool
+	ignoreRequestFunc IgnoreRequestFunc
+	resourceNamer     func(c echo.Context) string
+	isStatusError     func(statusCode int) bool
 }
 
 // Option represents an option that can be passed to Middleware.
 type Option func(*config)
 
+// IgnoreRequestFunc determines if tracing will be skipped for a request.
+// The route of the request, such as its path template returned by c.Path(),
+// is only available when the middleware is registered with Echo.Use(), as the
+// middleware functions registered with Echo.Pre() run before routing. In that
+// case, c.Path() is empty and only the raw request path can be used.
+type IgnoreRequestFunc func(c echo.Context) bool
+
 func defaults(cfg *config) {
 	cfg.serviceName = "echo"
 	if svc := globalconfig.ServiceName(); svc != "" {
@@ -74,6 +85,31 @@ func NoDebugStack() Option {
 	}
 }
 
+// WithIgnoreRequest sets a function which determines if tracing will be
+// skipped for a given request, such as the health checks or the metrics
+// scraping requests, according to its path, headers or method.
+func WithIgnoreRequest(ignoreRequestFunc IgnoreRequestFunc) Option {
+	return func(cfg *config) {
+		cfg.ignoreRequestFunc = ignoreRequestFunc
+	}
+}
+
+// WithResourceNamer specifies a function fn which returns the resource name of
+// the span of the request of the given context. The default resource name is
+// the request method followed by the route of the request, such as
+// "GET /users/:id", or by the request path when the route is empty. The route
+// is empty when the middleware is registered with Echo.Pre(), which runs
+// before routing. Note that echo doesn't set a route template for the requests
+// matching no route, such as the 404 responses: their route is either empty or
+// the raw request path, so that their resource name is of possibly high
+// cardinality, which fn can normalize. The default resource name is used when
+// fn returns an empty string.
+func WithResourceNamer(fn func(c echo.Context) string) Option {
+	return func(cfg *config) {
+		cfg.resourceNamer = fn
+	}
+}
+
 // WithStatusCheck specifies a function fn which reports whether the passed
 // statusCode should be considered an error.
 func WithStatusCheck(fn func(statusCode int) bool) Option {
@@ -0,0 +1,80 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023 Datadog, Inc.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// apiGatewayContextHeader is the header in which github.com/awslabs/aws-lambda-go-api-proxy
+	// serializes the API Gateway request context of the Lambda event.
+	apiGatewayContextHeader = "X-GoLambdaProxy-ApiGw-Context"
+	// apiGatewayAPIIDHeader is the header set by API Gateway to the ID of the API of the request.
+	apiGatewayAPIIDHeader = "X-Amzn-Apigateway-Api-Id"
+	// apiGatewayOperationName is the operation name of the spans of the requests received from API Gateway.
+	apiGatewayOperationName = "aws.apigateway.request"
+)
+
+// apiGateway holds the subset of the API Gateway request context used to tag request spans.
+// It covers both the REST (v1) and HTTP (v2) API request contexts.
+type apiGateway struct {
+	APIID        string `json:"apiId"`
+	Stage        string `json:"stage"`
+	RequestID    string `json:"requestId"`
+	DomainName   string `json:"domainName"`
+	ResourcePath string `json:"resourcePath"`
+	RouteKey     string `json:"routeKey"`
+}
+
+// apiGatewayContext returns the API Gateway request context of the given request, if any.
+func apiGatewayContext(r *http.Request) (apigw apiGateway, ok bool) {
+	if v := r.Header.Get(apiGatewayContextHeader); v != "" {
+		if err := json.Unmarshal([]byte(v), &apigw); err == nil && apigw.APIID != "" {
+			return apigw, true
+		}
+	}
+	if id := r.Header.Get(apiGatewayAPIIDHeader); id != "" {
+		return apiGateway{APIID: id}, true
+	}
+	return apiGateway{}, false
+}
+
+// route returns the API Gateway route of the request, without the HTTP method of HTTP API route keys.
+func (apigw apiGateway) route() string {
+	if apigw.ResourcePath != "" {
+		return apigw.ResourcePath
+	}
+	// HTTP API route keys are of the form "GET /path", or "$default"
+	_, route, _ := strings.Cut(apigw.RouteKey, " ")
+	return route
+}
+
+// spanOptions returns the span start options naming the request span after API Gateway and tagging
+// it with the API Gateway request context.
+func (apigw apiGateway) spanOptions() []ddtrace.StartSpanOption {
+	opts := []ddtrace.StartSpanOption{
+		tracer.Tag(ext.SpanName, apiGatewayOperationName),
+		tracer.Tag(ext.SpanKind, ext.SpanKindServer),
+		tracer.Tag("aws.apigateway.api_id", apigw.APIID),
+	}
+	for tag, v := range map[string]string{
+		"aws.apigateway.stage":       apigw.Stage,
+		"aws.apigateway.request_id":  apigw.RequestID,
+		"aws.apigateway.domain_name": apigw.DomainName,
+	} {
+		if v != "" {
+			opts = append(opts, tracer.Tag(tag, v))
+		}
+	}
+	return opts
+}
@@ -6,7 +6,9 @@
 package http_test
 
 import (
+	"encoding/json"
 	"net/http"
+	"time"
 
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 )
@@ -33,6 +35,11 @@ func ExampleTraceAndServe() {
 	http.ListenAndServe(":8080", mux)
 }
 
+func ExampleWrapHandlerFunc() {
+	http.HandleFunc("/", httptrace.WrapHandlerFunc(Index, "my-service", "GET /"))
+	http.ListenAndServe(":8080", nil)
+}
+
 func Index(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Hello World!\n"))
 }
@@ -50,6 +57,59 @@ func ExampleWrapClient() {
 	http.ListenAndServe(":8080", mux)
 }
 
+// ExampleInject provides an example of how to propagate the trace of an incoming request through an outgoing http
+// call sent by a client that isn't wrapped.
+func ExampleInject() {
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, "http://test.test", nil)
+		httptrace.Inject(r.Context(), req)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.Write([]byte(resp.Status))
+	})
+	http.ListenAndServe(":8080", mux)
+}
+
+// ExampleNewClient provides an example of how to create a traced http Client without modifying http.DefaultClient.
+func ExampleNewClient() {
+	c := httptrace.NewClient(httptrace.RTWithClientTimeout(10 * time.Second))
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, "http://test.test", nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.Write([]byte(resp.Status))
+	})
+	http.ListenAndServe(":8080", mux)
+}
+
+// ExampleStartDecodeSpan provides an example of how to see the decoding time of the request body separately from the
+// rest of the request.
+func ExampleStartDecodeSpan() {
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		span, _ := httptrace.StartDecodeSpan(r)
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		span.Finish()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("Hello World!\n"))
+	})
+	http.ListenAndServe(":8080", mux)
+}
+
 func traceMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, route := mux.Handler(r)
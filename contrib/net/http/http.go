@@ -7,8 +7,11 @@
 package http // import "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 
 import (
+	"context"
 	"net/http"
+	"net/http/httputil"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
@@ -54,10 +57,13 @@ func (mux *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	TraceAndServe(mux.ServeMux, w, r, &ServeConfig{
-		Service:  mux.cfg.serviceName,
-		Resource: resource,
-		SpanOpts: mux.cfg.spanOpts,
-		Route:    route,
+		Service:             mux.cfg.serviceName,
+		Resource:            resource,
+		SpanOpts:            mux.cfg.spanOpts,
+		Route:               route,
+		CacheHeaders:        mux.cfg.cacheHeaders,
+		RecordContentLength: mux.cfg.contentLength,
+		SchemeTag:           mux.cfg.schemeTag,
 	})
 }
 
@@ -82,10 +88,58 @@ func WrapHandler(h http.Handler, service, resource string, opts ...Option) http.
 		}
 
 		TraceAndServe(h, w, req, &ServeConfig{
-			Service:    service,
-			Resource:   resource,
-			FinishOpts: cfg.finishOpts,
-			SpanOpts:   cfg.spanOpts,
+			Service:             service,
+			Resource:            resource,
+			FinishOpts:          cfg.finishOpts,
+			SpanOpts:            cfg.spanOpts,
+			CacheHeaders:        cfg.cacheHeaders,
+			RecordContentLength: cfg.contentLength,
+			SchemeTag:           cfg.schemeTag,
 		})
 	})
 }
+
+// WrapHandlerFunc wraps an http.HandlerFunc with tracing using the given service and resource, as with WrapHandler.
+// If the WithResourceNamer option is provided as part of opts, it will take precedence over the resource argument.
+func WrapHandlerFunc(f http.HandlerFunc, service, resource string, opts ...Option) http.HandlerFunc {
+	return WrapHandler(f, service, resource, opts...).ServeHTTP
+}
+
+// WrapReverseProxy wraps a reverse proxy with tracing using the given service and resource. The incoming requests are
+// traced as with WrapHandler and the proxied requests are traced as with WrapRoundTripper, with the given
+// RoundTripperOptions. The proxied request spans are children of the incoming request span and the trace context is
+// propagated to the upstream server.
+// Note that WrapReverseProxy modifies the Transport of the given proxy.
+func WrapReverseProxy(p *httputil.ReverseProxy, service, resource string, opts ...RoundTripperOption) http.Handler {
+	if p.Transport == nil {
+		p.Transport = http.DefaultTransport
+	}
+	// The reverse proxy sends the upstream request with the context of the incoming request, which holds the span
+	// started by the wrapping handler.
+	p.Transport = WrapRoundTripper(p.Transport, opts...)
+	return WrapHandler(p, service, resource)
+}
+
+// decodeSpanName is the operation name of the spans started by StartDecodeSpan.
+const decodeSpanName = "http.request.decode"
+
+// StartChildSpan starts a span with the given operation name, child of the span found in ctx, such as the request
+// span of a traced handler, and returns it along with a copy of ctx holding it. It allows handlers to decompose the
+// latency of a request into child spans, such as around the decoding of the request body or the calls to a dependency
+// without integration, without importing the tracer. The span is tagged with the net/http component and the service
+// of the parent span, and must be finished by the caller. A root span is started when ctx holds no span.
+func StartChildSpan(ctx context.Context, operationName string, opts ...ddtrace.StartSpanOption) (ddtrace.Span, context.Context) {
+	opts = append([]ddtrace.StartSpanOption{tracer.Tag(ext.Component, componentName)}, opts...)
+	return tracer.StartSpanFromContext(ctx, operationName, opts...)
+}
+
+// StartDecodeSpan starts a child span of the request span found in the context of r, named "http.request.decode", to
+// be finished by the handler once it decoded the request body, such as a JSON payload, so that the decoding time is
+// seen separately from the rest of the request. It is a shorthand for StartChildSpan:
+//
+//	span, _ := httptrace.StartDecodeSpan(r)
+//	err := json.NewDecoder(r.Body).Decode(&payload)
+//	span.Finish()
+func StartDecodeSpan(r *http.Request, opts ...ddtrace.StartSpanOption) (ddtrace.Span, context.Context) {
+	return StartChildSpan(r.Context(), decodeSpanName, opts...)
+}
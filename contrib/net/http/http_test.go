@@ -8,10 +8,14 @@ package http
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
@@ -77,6 +81,33 @@ func TestHttpTracer500(t *testing.T) {
 	assert.Equal("net/http", s.Tag(ext.Component))
 }
 
+func TestWrapHandlerFunc(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+	assert := assert.New(t)
+
+	handler := WrapHandlerFunc(handler200, "my-service", "my-resource",
+		WithSpanOptions(tracer.Tag("foo", "bar")),
+	)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(200, w.Code)
+	assert.Equal("OK\n", w.Body.String())
+
+	spans := mt.FinishedSpans()
+	assert.Equal(1, len(spans))
+
+	s := spans[0]
+	assert.Equal("http.request", s.OperationName())
+	assert.Equal("my-service", s.Tag(ext.ServiceName))
+	assert.Equal("my-resource", s.Tag(ext.ResourceName))
+	assert.Equal("200", s.Tag(ext.HTTPCode))
+	assert.Equal("bar", s.Tag("foo"))
+	assert.Equal(ext.SpanKindServer, s.Tag(ext.SpanKind))
+}
+
 func TestWrapHandler200(t *testing.T) {
 	mt := mocktracer.Start()
 	defer mt.Stop()
@@ -109,6 +140,41 @@ func TestWrapHandler200(t *testing.T) {
 	assert.Equal("net/http", s.Tag(ext.Component))
 }
 
+func TestWrapReverseProxy(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	var upstreamCtx ddtrace.SpanContext
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		upstreamCtx, err = tracer.Extract(tracer.HTTPHeadersCarrier(r.Header))
+		assert.NoError(t, err)
+		w.Write([]byte("Hello World"))
+	}))
+	defer upstream.Close()
+	u, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	proxy := WrapReverseProxy(httputil.NewSingleHostReverseProxy(u), "proxy-service", "proxy-resource")
+	r := httptest.NewRequest("GET", "/hello", nil)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Hello World", w.Body.String())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	client, server := spans[0], spans[1]
+	assert.Equal(t, "proxy-service", server.Tag(ext.ServiceName))
+	assert.Equal(t, "proxy-resource", server.Tag(ext.ResourceName))
+	assert.Equal(t, ext.SpanKindServer, server.Tag(ext.SpanKind))
+	assert.Equal(t, ext.SpanKindClient, client.Tag(ext.SpanKind))
+	assert.Equal(t, server.SpanID(), client.ParentID())
+	require.NotNil(t, upstreamCtx)
+	assert.Equal(t, client.TraceID(), upstreamCtx.TraceID())
+	assert.Equal(t, client.SpanID(), upstreamCtx.SpanID())
+}
+
 func TestNoStack(t *testing.T) {
 	mt := mocktracer.Start()
 	defer mt.Stop()
@@ -309,3 +375,29 @@ func handler200(w http.ResponseWriter, r *http.Request) {
 func handler500(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "500!", http.StatusInternalServerError)
 }
+
+func TestStartDecodeSpan(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mux := NewServeMux(WithServiceName("my-service"))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := StartDecodeSpan(r)
+		child, _ := StartChildSpan(ctx, "validate", tracer.ResourceName("payload"))
+		child.Finish()
+		span.Finish()
+	})
+	r := httptest.NewRequest("POST", "/", nil)
+	mux.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	child, decode, request := spans[0], spans[1], spans[2]
+	assert.Equal(t, "http.request.decode", decode.OperationName())
+	assert.Equal(t, request.SpanID(), decode.ParentID())
+	assert.Equal(t, "my-service", decode.Tag(ext.ServiceName))
+	assert.Equal(t, "net/http", decode.Tag(ext.Component))
+	assert.Equal(t, "validate", child.OperationName())
+	assert.Equal(t, "payload", child.Tag(ext.ResourceName))
+	assert.Equal(t, decode.SpanID(), child.ParentID())
+}
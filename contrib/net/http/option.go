@@ -8,12 +8,16 @@ package http
 import (
 	"math"
 	"net/http"
+	"strings"
+	"time"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/httptrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/namingschema"
 )
 
 type config struct {
@@ -23,6 +27,9 @@ type config struct {
 	finishOpts    []ddtrace.FinishOption
 	ignoreRequest func(*http.Request) bool
 	resourceNamer func(*http.Request) string
+	cacheHeaders  []string
+	contentLength bool
+	schemeTag     *SchemeTagConfig
 }
 
 // MuxOption has been deprecated in favor of Option.
@@ -47,6 +54,7 @@ func defaults(cfg *config) {
 	}
 	cfg.ignoreRequest = func(_ *http.Request) bool { return false }
 	cfg.resourceNamer = func(_ *http.Request) string { return "" }
+	cfg.contentLength = httptrace.RequestContentLengthEnabled()
 }
 
 // WithIgnoreRequest holds the function to use for determining if the
@@ -104,6 +112,36 @@ func WithResourceNamer(namer func(req *http.Request) string) Option {
 	}
 }
 
+// WithCacheHeaders tags the request spans with "http.cache" set to "hit" or
+// "miss" according to the given response headers, such as X-Cache or Age,
+// instead of the ones specified by the DD_TRACE_HTTP_CACHE_HEADERS env var.
+func WithCacheHeaders(headers ...string) Option {
+	return func(cfg *config) {
+		cfg.cacheHeaders = append([]string{}, headers...)
+	}
+}
+
+// WithRequestContentLength enables or disables tagging the request spans with
+// the request Content-Length declared by the client, as
+// "http.request.content_length". It defaults to the value of the
+// DD_TRACE_HTTP_REQUEST_CONTENT_LENGTH_ENABLED env var, false if unset.
+func WithRequestContentLength(on bool) Option {
+	return func(cfg *config) {
+		cfg.contentLength = on
+	}
+}
+
+// WithSchemeTag enables or disables tagging the request spans with the request
+// scheme as "http.scheme", which is enabled by default. The scheme found in the
+// given forwarded proto header, such as X-Forwarded-Proto, takes precedence when
+// not empty. It overrides the DD_TRACE_HTTP_SCHEME_ENABLED and
+// DD_TRACE_HTTP_FORWARDED_PROTO_HEADER env vars.
+func WithSchemeTag(enabled bool, forwardedProtoHeader string) Option {
+	return func(cfg *config) {
+		cfg.schemeTag = &SchemeTagConfig{Enabled: enabled, ForwardedProtoHeader: forwardedProtoHeader}
+	}
+}
+
 // NoDebugStack prevents stack traces from being attached to spans finishing
 // with an error. This is useful in situations where errors are frequent and
 // performance is critical.
@@ -126,17 +164,30 @@ type roundTripperConfig struct {
 	after         RoundTripperAfterFunc
 	analyticsRate float64
 	serviceName   string
+	serviceNamer  func(req *http.Request) string
 	resourceNamer func(req *http.Request) string
+	spanName      string                         // operation name of the client spans, per the naming schema
+	spanNamer     func(req *http.Request) string // optional function overriding spanName per request
 	ignoreRequest func(*http.Request) bool
 	spanOpts      []ddtrace.StartSpanOption
 	errCheck      func(err error) bool
+	propagate     func(*http.Request) bool
+	recordProto   bool
+	clientTimeout time.Duration
+	breakerOpen   func(err error) bool
+	trailerTags   []string // canonical names of the response trailers to tag, if any
+	connTimings   bool     // tag the client spans with the reuse of the connection
+	cacheHeaders  []string // response headers telling the cache status, if any
 }
 
 func newRoundTripperConfig() *roundTripperConfig {
 	return &roundTripperConfig{
 		analyticsRate: globalconfig.AnalyticsRate(),
 		resourceNamer: defaultResourceNamer,
+		spanName:      namingschema.NewHTTPClientOp().GetName(),
 		ignoreRequest: func(_ *http.Request) bool { return false },
+		clientTimeout: defaultClientTimeout,
+		cacheHeaders:  httptrace.DefaultCacheHeaders(),
 	}
 }
 
@@ -145,17 +196,39 @@ func newRoundTripperConfig() *roundTripperConfig {
 type RoundTripperOption func(*roundTripperConfig)
 
 // WithBefore adds a RoundTripperBeforeFunc to the RoundTripper
-// config.
+// config. When given several times, the functions are called in the
+// order they were given. A nil function is ignored.
 func WithBefore(f RoundTripperBeforeFunc) RoundTripperOption {
 	return func(cfg *roundTripperConfig) {
+		if f == nil {
+			return
+		}
+		if prev := cfg.before; prev != nil {
+			cfg.before = func(req *http.Request, span ddtrace.Span) {
+				prev(req, span)
+				f(req, span)
+			}
+			return
+		}
 		cfg.before = f
 	}
 }
 
 // WithAfter adds a RoundTripperAfterFunc to the RoundTripper
-// config.
+// config. When given several times, the functions are called in the
+// order they were given. A nil function is ignored.
 func WithAfter(f RoundTripperAfterFunc) RoundTripperOption {
 	return func(cfg *roundTripperConfig) {
+		if f == nil {
+			return
+		}
+		if prev := cfg.after; prev != nil {
+			cfg.after = func(res *http.Response, span ddtrace.Span) {
+				prev(res, span)
+				f(res, span)
+			}
+			return
+		}
 		cfg.after = f
 	}
 }
@@ -168,6 +241,16 @@ func RTWithResourceNamer(namer func(req *http.Request) string) RoundTripperOptio
 	}
 }
 
+// RTWithSpanNameFormatter specifies a function fn which returns the operation
+// name of the span of a given request, to follow custom naming conventions. The
+// operation name given by the naming schema version, "http.request" for v0 and
+// "http.client.request" for v1, is used when fn returns an empty string.
+func RTWithSpanNameFormatter(fn func(req *http.Request) string) RoundTripperOption {
+	return func(cfg *roundTripperConfig) {
+		cfg.spanNamer = fn
+	}
+}
+
 // RTWithSpanOptions defines a set of additional ddtrace.StartSpanOption to be added
 // to spans started by the integration.
 func RTWithSpanOptions(opts ...ddtrace.StartSpanOption) RoundTripperOption {
@@ -187,6 +270,16 @@ func RTWithServiceName(name string) RoundTripperOption {
 	}
 }
 
+// RTWithServiceNamer specifies a function which will be used to obtain the
+// service name of the span of a given request, such as one per destination host.
+// The service name given by RTWithServiceName, or the global one, is used when
+// it returns an empty string.
+func RTWithServiceNamer(namer func(req *http.Request) string) RoundTripperOption {
+	return func(cfg *roundTripperConfig) {
+		cfg.serviceNamer = namer
+	}
+}
+
 // RTWithAnalytics enables Trace Analytics for all started spans.
 func RTWithAnalytics(on bool) RoundTripperOption {
 	return func(cfg *roundTripperConfig) {
@@ -226,3 +319,98 @@ func RTWithErrorCheck(fn func(err error) bool) RoundTripperOption {
 		cfg.errCheck = fn
 	}
 }
+
+// RTWithPropagationHosts restricts the injection of the trace context into the
+// outgoing request headers to requests sent to one of the given hosts. Requests
+// to other hosts are still traced, but their headers are left untouched. Hosts
+// are compared case-insensitively against the request URL host name, without
+// port.
+func RTWithPropagationHosts(hosts ...string) RoundTripperOption {
+	allowed := make(map[string]struct{}, len(hosts))
+	for _, h := range hosts {
+		allowed[strings.ToLower(h)] = struct{}{}
+	}
+	return func(cfg *roundTripperConfig) {
+		cfg.propagate = func(req *http.Request) bool {
+			_, ok := allowed[strings.ToLower(req.URL.Hostname())]
+			return ok
+		}
+	}
+}
+
+// RTWithRecordProtocol enables tagging client spans with the HTTP protocol
+// version of the response, as "http.version" (e.g. "1.1" or "2.0").
+func RTWithRecordProtocol() RoundTripperOption {
+	return func(cfg *roundTripperConfig) {
+		cfg.recordProto = true
+	}
+}
+
+// RTWithConnectionTimings enables tagging client spans with the way the
+// connection sending the request was obtained, as reported to the GotConn hook
+// of a net/http/httptrace.ClientTrace: whether it was reused from the idle
+// connection pool, as "http.conn_reused", whether it was idle, as
+// "http.conn_was_idle", and for how long, in milliseconds, as
+// "http.conn_idle_time_ms". It helps telling apart the requests establishing a
+// new connection, and so paying the TCP and TLS handshake latency, from the
+// ones reusing one, such as when diagnosing connection-pool exhaustion. The
+// ClientTrace of the request context, if any, is still called. The spans of
+// the requests failing before getting a connection aren't tagged.
+func RTWithConnectionTimings() RoundTripperOption {
+	return func(cfg *roundTripperConfig) {
+		cfg.connTimings = true
+	}
+}
+
+// RTWithCircuitBreakerCheck specifies a function fn which reports whether the
+// error returned by the wrapped transport is the rejection of the request by a
+// circuit breaker, or any other resilience layer failing requests locally
+// without sending them. The spans of such requests are tagged with
+// "http.circuit_breaker" set to "open", so that they can be told apart from
+// network errors. The wrapped transport must be the one short-circuiting, so
+// that the span is started before the request is rejected.
+func RTWithCircuitBreakerCheck(fn func(err error) bool) RoundTripperOption {
+	return func(cfg *roundTripperConfig) {
+		cfg.breakerOpen = fn
+	}
+}
+
+// RTWithTrailerTags tags client spans with the given response trailers, as
+// "http.response.trailers.<name>" with the lowercased trailer name, such as the
+// Grpc-Status trailer sent by gRPC-over-HTTP servers once the response body is
+// sent. Trailers are only available once the response body was read until
+// EOF, so the client span of a response with a body is finished when the body
+// is closed, instead of when RoundTrip returns, and the span duration includes
+// the time taken to read it. The trailers missing because the body was closed
+// before being fully read are not tagged. Note that the client span is never
+// finished when the response body isn't closed, which is required by net/http
+// anyway to release the connection.
+func RTWithTrailerTags(trailers []string) RoundTripperOption {
+	return func(cfg *roundTripperConfig) {
+		cfg.trailerTags = make([]string, 0, len(trailers))
+		for _, t := range trailers {
+			cfg.trailerTags = append(cfg.trailerTags, http.CanonicalHeaderKey(t))
+		}
+	}
+}
+
+// RTWithCacheHeaders tags client spans with "http.cache" set to "hit" or "miss"
+// according to the given response headers, such as X-Cache or Age, instead of
+// the ones specified by the DD_TRACE_HTTP_CACHE_HEADERS env var.
+func RTWithCacheHeaders(headers ...string) RoundTripperOption {
+	return func(cfg *roundTripperConfig) {
+		cfg.cacheHeaders = append([]string{}, headers...)
+	}
+}
+
+// defaultClientTimeout is the default timeout of the clients created with NewClient.
+const defaultClientTimeout = 30 * time.Second
+
+// RTWithClientTimeout sets the timeout of the client returned by NewClient, which
+// defaults to 30 seconds. A zero timeout means no timeout. It has no effect on
+// WrapRoundTripper and WrapClient.
+func RTWithClientTimeout(timeout time.Duration) RoundTripperOption {
+	return func(cfg *roundTripperConfig) {
+		cfg.clientTimeout = timeout
+	}
+}
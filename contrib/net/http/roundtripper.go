@@ -6,12 +6,20 @@
 package http
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
+	nethttptrace "net/http/httptrace"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/httptrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
@@ -26,7 +34,10 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err er
 	if rt.cfg.ignoreRequest(req) {
 		return rt.base.RoundTrip(req)
 	}
-	resourceName := rt.cfg.resourceNamer(req)
+	resourceName, ok := req.Context().Value(requestResourceKey{}).(string)
+	if !ok || resourceName == "" {
+		resourceName = rt.cfg.resourceNamer(req)
+	}
 	// Make a copy of the URL so we don't modify the outgoing request
 	url := *req.URL
 	url.User = nil // Do not include userinfo in the HTTPURL tag.
@@ -41,41 +52,82 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err er
 	if !math.IsNaN(rt.cfg.analyticsRate) {
 		opts = append(opts, tracer.Tag(ext.EventSampleRate, rt.cfg.analyticsRate))
 	}
-	if rt.cfg.serviceName != "" {
-		opts = append(opts, tracer.ServiceName(rt.cfg.serviceName))
+	serviceName := rt.cfg.serviceName
+	if rt.cfg.serviceNamer != nil {
+		if name := rt.cfg.serviceNamer(req); name != "" {
+			serviceName = name
+		}
+	}
+	if serviceName != "" {
+		opts = append(opts, tracer.ServiceName(serviceName))
 	}
 	if len(rt.cfg.spanOpts) > 0 {
 		opts = append(opts, rt.cfg.spanOpts...)
 	}
-	span, ctx := tracer.StartSpanFromContext(req.Context(), "http.request", opts...)
+	spanName := rt.cfg.spanName
+	if rt.cfg.spanNamer != nil {
+		if name := rt.cfg.spanNamer(req); name != "" {
+			spanName = name
+		}
+	}
+	span, ctx := tracer.StartSpanFromContext(req.Context(), spanName, opts...)
 	defer func() {
 		if rt.cfg.after != nil {
 			rt.cfg.after(res, span)
 		}
-		if rt.cfg.errCheck == nil || rt.cfg.errCheck(err) {
-			span.Finish(tracer.WithError(err))
-		} else {
-			span.Finish()
+		finish := func() {
+			if rt.cfg.errCheck == nil || rt.cfg.errCheck(err) {
+				span.Finish(tracer.WithError(err))
+			} else {
+				span.Finish()
+			}
+		}
+		if len(rt.cfg.trailerTags) > 0 && err == nil && res.Body != nil && res.Body != http.NoBody &&
+			res.StatusCode != http.StatusSwitchingProtocols {
+			// the trailers are only available once the body was read
+			res.Body = &trailerTagsBody{ReadCloser: res.Body, finish: func(eof bool) {
+				if eof {
+					setTrailerTags(span, res.Trailer, rt.cfg.trailerTags)
+				}
+				finish()
+			}}
+			return
+		}
+		if err == nil {
+			setTrailerTags(span, res.Trailer, rt.cfg.trailerTags)
 		}
+		finish()
 	}()
 	if rt.cfg.before != nil {
 		rt.cfg.before(req, span)
 	}
+	if rt.cfg.connTimings {
+		ctx = withConnTags(ctx, span)
+	}
 	r2 := req.Clone(ctx)
-	// inject the span context into the http request copy
-	err = tracer.Inject(span.Context(), tracer.HTTPHeadersCarrier(r2.Header))
-	if err != nil {
-		// this should never happen
-		fmt.Fprintf(os.Stderr, "contrib/net/http.Roundtrip: failed to inject http headers: %v\n", err)
+	if rt.cfg.propagate == nil || rt.cfg.propagate(r2) {
+		// inject the span context into the http request copy
+		err = tracer.Inject(span.Context(), tracer.HTTPHeadersCarrier(r2.Header))
+		if err != nil {
+			// this should never happen
+			fmt.Fprintf(os.Stderr, "contrib/net/http.Roundtrip: failed to inject http headers: %v\n", err)
+		}
 	}
 	res, err = rt.base.RoundTrip(r2)
 	if err != nil {
 		span.SetTag("http.errors", err.Error())
+		if rt.cfg.breakerOpen != nil && rt.cfg.breakerOpen(err) {
+			span.SetTag("http.circuit_breaker", "open")
+		}
 		if rt.cfg.errCheck == nil || rt.cfg.errCheck(err) {
 			span.SetTag(ext.Error, err)
 		}
 	} else {
 		span.SetTag(ext.HTTPCode, strconv.Itoa(res.StatusCode))
+		httptrace.SetResponseCacheTag(span, rt.cfg.cacheHeaders, res.Header)
+		if rt.cfg.recordProto {
+			span.SetTag("http.version", protoVersion(res.ProtoMajor, res.ProtoMinor))
+		}
 		// treat 5XX as errors
 		if res.StatusCode/100 == 5 {
 			span.SetTag("http.errors", res.Status)
@@ -85,6 +137,70 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err er
 	return res, err
 }
 
+// withConnTags returns a copy of ctx with a client trace tagging the given span
+// with the reuse of the connection sending the request.
+func withConnTags(ctx context.Context, span ddtrace.Span) context.Context {
+	return nethttptrace.WithClientTrace(ctx, &nethttptrace.ClientTrace{
+		GotConn: func(info nethttptrace.GotConnInfo) {
+			span.SetTag("http.conn_reused", info.Reused)
+			span.SetTag("http.conn_was_idle", info.WasIdle)
+			if info.WasIdle {
+				span.SetTag("http.conn_idle_time_ms", float64(info.IdleTime)/float64(time.Millisecond))
+			}
+		},
+	})
+}
+
+// trailerTagsBody is a response body calling finish once closed, to tag the
+// client span with the response trailers and finish it.
+type trailerTagsBody struct {
+	io.ReadCloser
+	eof    int32 // accessed atomically, set once the body was read until EOF
+	once   sync.Once
+	finish func(eof bool)
+}
+
+// Read reads the response body and records whether it was read until EOF.
+func (b *trailerTagsBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		atomic.StoreInt32(&b.eof, 1)
+	}
+	return n, err
+}
+
+// Close closes the response body and calls finish the first time. The
+// trailers are only complete, and safe to read, once the body was read until
+// EOF: net/http keeps on reading the body in the background when it is closed
+// before, so that finish is then told not to read them.
+func (b *trailerTagsBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.finish(atomic.LoadInt32(&b.eof) == 1) })
+	return err
+}
+
+// setTrailerTags tags the given span with the given response trailers found in
+// trailer.
+func setTrailerTags(span ddtrace.Span, trailer http.Header, names []string) {
+	for _, name := range names {
+		if v := trailer.Values(name); len(v) > 0 {
+			span.SetTag("http.response.trailers."+strings.ToLower(name), httptrace.HeaderTagValue(strings.Join(v, ",")))
+		}
+	}
+}
+
+// requestResourceKey is the context key holding the resource name set by
+// WithRequestResource.
+type requestResourceKey struct{}
+
+// WithRequestResource returns a copy of ctx holding the given resource name.
+// Requests sent with the returned context through a traced RoundTripper use
+// it as the resource name of their client span, taking precedence over the
+// resource namer. An empty name falls back to the configured resource namer.
+func WithRequestResource(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, requestResourceKey{}, name)
+}
+
 // Unwrap returns the original http.RoundTripper.
 func (rt *roundTripper) Unwrap() http.RoundTripper {
 	return rt.base
@@ -109,6 +225,21 @@ func WrapRoundTripper(rt http.RoundTripper, opts ...RoundTripperOption) http.Rou
 	}
 }
 
+// NewClient returns a new http.Client tracing all the requests it sends with a
+// transport wrapping http.DefaultTransport, as WrapRoundTripper does. Unlike
+// http.DefaultClient, the client times out after 30 seconds by default. Use
+// RTWithClientTimeout to change it.
+func NewClient(opts ...RoundTripperOption) *http.Client {
+	cfg := newRoundTripperConfig()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return &http.Client{
+		Transport: WrapRoundTripper(http.DefaultTransport, opts...),
+		Timeout:   cfg.clientTimeout,
+	}
+}
+
 // WrapClient modifies the given client's transport to augment it with tracing and returns it.
 func WrapClient(c *http.Client, opts ...RoundTripperOption) *http.Client {
 	if c.Transport == nil {
@@ -117,3 +248,18 @@ func WrapClient(c *http.Client, opts ...RoundTripperOption) *http.Client {
 	c.Transport = WrapRoundTripper(c.Transport, opts...)
 	return c
 }
+
+// Inject injects the context of the span found in ctx, if any, into the headers of req using the
+// configured propagator, so that the trace is propagated to the requested service. It allows
+// propagating the trace through requests sent by clients that can't be wrapped with WrapClient,
+// without tracing the requests themselves. Nothing is injected when ctx holds no span.
+func Inject(ctx context.Context, req *http.Request) error {
+	span, ok := tracer.SpanFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	return tracer.Inject(span.Context(), tracer.HTTPHeadersCarrier(req.Header))
+}
@@ -6,10 +6,14 @@
 package http
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	nethttptrace "net/http/httptrace"
 	"net/url"
 	"strings"
 	"testing"
@@ -18,11 +22,13 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/namingschematest"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/namingschema"
 )
 
 func TestWrapRoundTripperAllowNilTransport(t *testing.T) {
@@ -183,6 +189,103 @@ func TestRoundTripperNetworkError(t *testing.T) {
 	assert.Equal(t, "net/http", s0.Tag(ext.Component))
 }
 
+func TestRoundTripperCircuitBreaker(t *testing.T) {
+	errBreakerOpen := errors.New("circuit breaker is open")
+	// breaker is a transport rejecting all the requests without sending them
+	breaker := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errBreakerOpen
+	})
+	isBreakerOpen := func(err error) bool { return errors.Is(err, errBreakerOpen) }
+
+	for _, tc := range []struct {
+		name     string
+		opts     []RoundTripperOption
+		expected interface{}
+	}{
+		{name: "check", opts: []RoundTripperOption{RTWithCircuitBreakerCheck(isBreakerOpen)}, expected: "open"},
+		{name: "no-check"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			client := &http.Client{Transport: WrapRoundTripper(breaker, tc.opts...)}
+			_, err := client.Get("http://example.invalid/hello")
+			require.ErrorIs(t, err, errBreakerOpen)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			s0 := spans[0]
+			assert.ErrorIs(t, s0.Tag(ext.Error).(error), errBreakerOpen)
+			assert.Equal(t, errBreakerOpen.Error(), s0.Tag("http.errors"))
+			assert.Equal(t, tc.expected, s0.Tag("http.circuit_breaker"))
+			assert.Nil(t, s0.Tag(ext.HTTPCode))
+		})
+	}
+}
+
+func TestRoundTripperTrailerTags(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Write([]byte("hello"))
+		w.Header().Set("Grpc-Status", "13")
+		w.Header().Set("Grpc-Message", "internal error")
+	}))
+	defer s.Close()
+
+	t.Run("read", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		client := &http.Client{Transport: WrapRoundTripper(nil, RTWithTrailerTags([]string{"grpc-status", "x-missing"}))}
+		res, err := client.Get(s.URL)
+		require.NoError(t, err)
+		// the span is finished once the body is closed
+		assert.Len(t, mt.FinishedSpans(), 0)
+		_, err = io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.NoError(t, res.Body.Close())
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "13", spans[0].Tag("http.response.trailers.grpc-status"))
+		assert.Nil(t, spans[0].Tag("http.response.trailers.grpc-message"))
+		assert.Nil(t, spans[0].Tag("http.response.trailers.x-missing"))
+	})
+
+	t.Run("not-read", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		client := &http.Client{Transport: WrapRoundTripper(nil, RTWithTrailerTags([]string{"grpc-status"}))}
+		res, err := client.Get(s.URL)
+		require.NoError(t, err)
+		assert.Len(t, mt.FinishedSpans(), 0)
+		// closing the body without reading it still finishes the span, without
+		// reading the trailers written by the background drain of net/http
+		require.NoError(t, res.Body.Close())
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Nil(t, spans[0].Tag("http.response.trailers.grpc-status"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		client := &http.Client{Transport: WrapRoundTripper(nil)}
+		res, err := client.Get(s.URL)
+		require.NoError(t, err)
+		// the span is finished when RoundTrip returns
+		assert.Len(t, mt.FinishedSpans(), 1)
+		_, err = io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		assert.Nil(t, mt.FinishedSpans()[0].Tag("http.response.trailers.grpc-status"))
+	})
+}
+
 func TestRoundTripperNetworkErrorWithErrorCheck(t *testing.T) {
 	failedRequest := func(t *testing.T, mt mocktracer.Tracer, forwardErr bool, opts ...RoundTripperOption) mocktracer.Span {
 		done := make(chan struct{})
@@ -438,6 +541,29 @@ func TestServiceName(t *testing.T) {
 		assert.Len(t, spans, 1)
 		assert.Equal(t, serviceName, spans[0].Tag(ext.ServiceName))
 	})
+
+	t.Run("namer", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		rt := WrapRoundTripper(http.DefaultTransport,
+			RTWithServiceName("defaultServiceName"),
+			RTWithServiceNamer(func(req *http.Request) string {
+				if req.URL.Path == "/hello/world" {
+					return "hello-service"
+				}
+				return ""
+			}),
+		)
+		client := &http.Client{
+			Transport: rt,
+		}
+		client.Get(s.URL + "/hello/world")
+		client.Get(s.URL + "/bye")
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 2)
+		assert.Equal(t, "hello-service", spans[0].Tag(ext.ServiceName))
+		assert.Equal(t, "defaultServiceName", spans[1].Tag(ext.ServiceName))
+	})
 }
 
 func TestResourceNamer(t *testing.T) {
@@ -476,6 +602,284 @@ func TestResourceNamer(t *testing.T) {
 	})
 }
 
+func TestSpanNameFormatter(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World"))
+	}))
+	defer s.Close()
+
+	for _, tc := range []struct {
+		name      string
+		version   namingschema.Version
+		formatter func(req *http.Request) string
+		expected  string
+	}{
+		{name: "v0-default", version: namingschema.SchemaV0, expected: "http.request"},
+		{name: "v1-default", version: namingschema.SchemaV1, expected: "http.client.request"},
+		{
+			name:      "v0-custom",
+			version:   namingschema.SchemaV0,
+			formatter: func(req *http.Request) string { return "http.client." + strings.ToLower(req.Method) },
+			expected:  "http.client.get",
+		},
+		{
+			name:      "v1-custom",
+			version:   namingschema.SchemaV1,
+			formatter: func(req *http.Request) string { return "http.client." + strings.ToLower(req.Method) },
+			expected:  "http.client.get",
+		},
+		{
+			name:      "v1-empty",
+			version:   namingschema.SchemaV1,
+			formatter: func(req *http.Request) string { return "" },
+			expected:  "http.client.request",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			version := namingschema.GetVersion()
+			defer namingschema.SetVersion(version)
+			namingschema.SetVersion(tc.version)
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			client := &http.Client{
+				Transport: WrapRoundTripper(http.DefaultTransport, RTWithSpanNameFormatter(tc.formatter)),
+			}
+			resp, err := client.Get(s.URL + "/hello/world")
+			require.NoError(t, err)
+			resp.Body.Close()
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.expected, spans[0].OperationName())
+		})
+	}
+}
+
+func TestClientNamingSchema(t *testing.T) {
+	genSpans := func(t *testing.T, _ string) []mocktracer.Span {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("Hello World"))
+		}))
+		defer srv.Close()
+
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		client := &http.Client{Transport: WrapRoundTripper(http.DefaultTransport)}
+		resp, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return mt.FinishedSpans()
+	}
+	t.Run("operation name", namingschematest.NewHTTPClientOpNameTest(genSpans))
+}
+
+func TestRequestResource(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World"))
+	}))
+	defer s.Close()
+
+	mt := mocktracer.Start()
+	defer mt.Stop()
+	client := WrapClient(&http.Client{}, RTWithResourceNamer(func(_ *http.Request) string {
+		return "namer"
+	}))
+
+	ctx := WithRequestResource(context.Background(), "get-user")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/user/1", nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.NoError(t, err)
+
+	req, err = http.NewRequest(http.MethodGet, s.URL+"/user/1", nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.NoError(t, err)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "get-user", spans[0].Tag(ext.ResourceName))
+	assert.Equal(t, "namer", spans[1].Tag(ext.ResourceName))
+}
+
+func TestPropagationHosts(t *testing.T) {
+	var injected bool
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := tracer.Extract(tracer.HTTPHeadersCarrier(r.Header))
+		injected = err == nil
+		w.Write([]byte("Hello World"))
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		hosts    []string
+		injected bool
+	}{
+		{name: "allowed", hosts: []string{"example.com", strings.ToUpper(u.Hostname())}, injected: true},
+		{name: "not-allowed", hosts: []string{"example.com"}, injected: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+			client := WrapClient(&http.Client{}, RTWithPropagationHosts(tc.hosts...))
+			_, err := client.Get(s.URL + "/hello/world")
+			require.NoError(t, err)
+			assert.Equal(t, tc.injected, injected)
+			assert.Len(t, mt.FinishedSpans(), 1)
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("Hello World"))
+	}))
+	defer s.Close()
+
+	t.Run("default", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		client := NewClient(RTWithResourceNamer(func(*http.Request) string { return "hello" }))
+		assert.Equal(t, defaultClientTimeout, client.Timeout)
+		_, err := client.Get(s.URL + "/hello/world")
+		require.NoError(t, err)
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "hello", spans[0].Tag(ext.ResourceName))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		client := NewClient(RTWithClientTimeout(10 * time.Millisecond))
+		_, err := client.Get(s.URL + "/hello/world")
+		require.Error(t, err)
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.NotNil(t, spans[0].Tag(ext.Error))
+	})
+}
+
+func TestBeforeAfterComposition(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Api-Version", "v2")
+		w.Write([]byte("Hello World"))
+	}))
+	defer s.Close()
+
+	mt := mocktracer.Start()
+	defer mt.Stop()
+	var calls []string
+	client := WrapClient(&http.Client{},
+		WithBefore(nil),
+		WithBefore(func(req *http.Request, span ddtrace.Span) {
+			calls = append(calls, "before-1")
+			span.SetTag("tenant", "acme")
+		}),
+		WithBefore(func(req *http.Request, span ddtrace.Span) {
+			calls = append(calls, "before-2")
+		}),
+		WithAfter(nil),
+		WithAfter(func(res *http.Response, span ddtrace.Span) {
+			calls = append(calls, "after-1")
+			span.SetTag("api.version", res.Header.Get("X-Api-Version"))
+		}),
+		WithAfter(func(res *http.Response, span ddtrace.Span) {
+			calls = append(calls, "after-2")
+		}))
+	_, err := client.Get(s.URL + "/hello/world")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"before-1", "before-2", "after-1", "after-2"}, calls)
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "acme", spans[0].Tag("tenant"))
+	assert.Equal(t, "v2", spans[0].Tag("api.version"))
+}
+
+func TestRecordProtocol(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World"))
+	}))
+	defer s.Close()
+
+	mt := mocktracer.Start()
+	defer mt.Stop()
+	client := WrapClient(&http.Client{}, RTWithRecordProtocol())
+	_, err := client.Get(s.URL + "/hello/world")
+	require.NoError(t, err)
+	_, err = WrapClient(&http.Client{}).Get(s.URL + "/hello/world")
+	require.NoError(t, err)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "1.1", spans[0].Tag("http.version"))
+	assert.Nil(t, spans[1].Tag("http.version"))
+}
+
+func TestRoundTripperCacheHeaders(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cache", "HIT from cloudfront")
+		w.Write([]byte("Hello World"))
+	}))
+	defer s.Close()
+
+	mt := mocktracer.Start()
+	defer mt.Stop()
+	_, err := WrapClient(&http.Client{}, RTWithCacheHeaders("x-cache")).Get(s.URL + "/hello/world")
+	require.NoError(t, err)
+	_, err = WrapClient(&http.Client{}).Get(s.URL + "/hello/world")
+	require.NoError(t, err)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "hit", spans[0].Tag("http.cache"))
+	assert.Nil(t, spans[1].Tag("http.cache"))
+}
+
+func TestConnectionTimings(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World"))
+	}))
+	defer s.Close()
+
+	mt := mocktracer.Start()
+	defer mt.Stop()
+	client := WrapClient(&http.Client{Transport: &http.Transport{}}, RTWithConnectionTimings())
+	var gotConns int
+	ctx := nethttptrace.WithClientTrace(context.Background(), &nethttptrace.ClientTrace{
+		GotConn: func(nethttptrace.GotConnInfo) { gotConns++ },
+	})
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/hello/world", nil)
+		require.NoError(t, err)
+		res, err := client.Do(req)
+		require.NoError(t, err)
+		// release the connection to the idle pool so that the next request reuses it
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+	_, err := WrapClient(&http.Client{}).Get(s.URL + "/hello/world")
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, gotConns)
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal(t, false, spans[0].Tag("http.conn_reused"))
+	assert.Equal(t, false, spans[0].Tag("http.conn_was_idle"))
+	assert.Nil(t, spans[0].Tag("http.conn_idle_time_ms"))
+	assert.Equal(t, true, spans[1].Tag("http.conn_reused"))
+	assert.Equal(t, true, spans[1].Tag("http.conn_was_idle"))
+	assert.IsType(t, float64(0), spans[1].Tag("http.conn_idle_time_ms"))
+	assert.Nil(t, spans[2].Tag("http.conn_reused"))
+}
+
 func TestSpanOptions(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("")) }))
 	defer s.Close()
@@ -493,3 +897,34 @@ func TestSpanOptions(t *testing.T) {
 	assert.Len(t, spans, 1)
 	assert.Equal(t, tagValue, spans[0].Tag(tagKey))
 }
+
+// roundTripperFunc is an http.RoundTripper calling itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestInject(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	t.Run("span", func(t *testing.T) {
+		span, ctx := tracer.StartSpanFromContext(context.Background(), "parent")
+		defer span.Finish()
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		require.NoError(t, err)
+
+		require.NoError(t, Inject(ctx, req))
+		spanctx, err := tracer.Extract(tracer.HTTPHeadersCarrier(req.Header))
+		require.NoError(t, err)
+		assert.Equal(t, span.Context().SpanID(), spanctx.SpanID())
+		assert.Equal(t, span.Context().TraceID(), spanctx.TraceID())
+	})
+
+	t.Run("no-span", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		require.NoError(t, err)
+
+		require.NoError(t, Inject(context.Background(), req))
+		assert.Empty(t, req.Header)
+	})
+}
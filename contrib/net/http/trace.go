@@ -8,7 +8,20 @@ package http // import "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 //go:generate sh -c "go run make_responsewriter.go | gofmt > trace_gen.go"
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/httptrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
@@ -44,26 +57,364 @@ type ServeConfig struct {
 	FinishOpts []ddtrace.FinishOption
 	// SpanOpts specifies any options to be applied to the request starting span.
 	SpanOpts []ddtrace.StartSpanOption
+	// RecordTLSInfo should be true in order to add the "tls.version" and "tls.cipher" tags
+	// describing the connection of TLS requests.
+	RecordTLSInfo bool
+	// RecordSNI should be true in order to add the "tls.sni" tag holding the server name requested
+	// by the client with the TLS Server Name Indication extension, identifying the virtual host of
+	// the request. It is skipped for non-TLS requests and when the client sent no server name.
+	RecordSNI bool
+	// RecordLocalAddr should be true in order to add the "network.local.port" tag holding the local
+	// port the request was received on, as found in the request context under http.LocalAddrContextKey,
+	// so that the requests received on the different ports of a server listening on several ones, such
+	// as a public and an admin port, can be told apart. It is skipped when the local address is unknown.
+	RecordLocalAddr bool
+	// RecordProtocol should be true in order to add the "http.version" tag holding the HTTP
+	// protocol version of the request (e.g. "1.1" or "2.0").
+	RecordProtocol bool
+	// ReuseSpan should be true in order to add the HTTP request tags to the span found in the
+	// request context, if any, instead of starting a new span. The reused span is neither
+	// finished by TraceAndServe, nor given an empty Service or Resource. Any other field of
+	// the ServeConfig still applies to it, except FinishOpts. When no span is found in the
+	// request context, a new span is started as usual.
+	ReuseSpan bool
+	// CacheHeaders optionally specifies the response headers, such as X-Cache or Age, telling whether
+	// the response was served from a cache, to tag the request span with "http.cache" set to "hit" or
+	// "miss". The headers specified by the DD_TRACE_HTTP_CACHE_HEADERS env var are used when nil, and
+	// none when empty.
+	CacheHeaders []string
+	// RecordContentType should be true in order to add the "http.response.content_type" tag
+	// holding the media type of the response Content-Type header, without its parameters.
+	RecordContentType bool
+	// RecordContentLength should be true in order to add the "http.request.content_length" tag holding
+	// the request Content-Length declared by the client, available before the body is read. Note that
+	// it reflects the declared length rather than the number of bytes actually read, and that it is
+	// skipped when unknown, such as for chunked requests. It is set by the WithRequestContentLength
+	// option of NewServeMux and WrapHandler, which defaults to the value of the
+	// DD_TRACE_HTTP_REQUEST_CONTENT_LENGTH_ENABLED env var.
+	RecordContentLength bool
+	// SchemeTag optionally overrides the configuration of the "http.scheme" tag holding the request
+	// scheme, given by default by the DD_TRACE_HTTP_SCHEME_ENABLED (true if unset) and
+	// DD_TRACE_HTTP_FORWARDED_PROTO_HEADER env vars.
+	SchemeTag *SchemeTagConfig
+	// CountRequestBytes should be true in order to add the "http.request.length" tag holding the
+	// number of bytes of the request body actually read by the handler, complementing the declared
+	// Content-Length for chunked or streaming uploads. The request body is wrapped with a reader
+	// counting the bytes read, and the count is tagged once the handler returns, so that the body
+	// left unread by the handler isn't accounted for.
+	CountRequestBytes bool
+	// RecordRawPath should be true in order to add the "http.path" tag holding the concrete request
+	// path, without its query string, such as "/users/123" for the route "/users/{id}". It allows
+	// seeing the actual paths of the requests while their resource names are grouped by route, at
+	// the cost of a high cardinality.
+	RecordRawPath bool
+	// ApplyDeadline should be true in order to apply the deadline resulting from the timeout header
+	// specified by the DD_TRACE_HTTP_DEADLINE_HEADER env var, such as grpc-timeout, to the request
+	// context passed to the handler. The deadline is tagged on the request span regardless.
+	ApplyDeadline bool
+	// SlowRequestThreshold optionally specifies the duration above which requests are tagged
+	// with "http.slow" set to true. It is disabled when zero.
+	SlowRequestThreshold time.Duration
+	// Propagator optionally specifies the propagator used to extract the span context of the
+	// upstream service from the request headers. The global propagator is used when nil.
+	Propagator tracer.Propagator
+	// RequestStartHeader optionally specifies the request header holding the time at which a
+	// fronting proxy received the request, such as X-Request-Start, to tag the request span
+	// with the time the request waited before being served, as "http.queue_time_ms". Epoch
+	// timestamps in seconds, milliseconds or microseconds are supported, optionally prefixed
+	// with "t=".
+	RequestStartHeader string
+	// RecordAPIGatewayInfo should be true in order to tag requests received from AWS API Gateway
+	// with the "aws.apigateway.*" tags, such as the API ID and stage, when running in AWS Lambda
+	// behind a net/http adapter. The API Gateway request context is read from the
+	// X-GoLambdaProxy-ApiGw-Context header set by github.com/awslabs/aws-lambda-go-api-proxy, or
+	// from the X-Amzn-Apigateway-Api-Id header otherwise. The API Gateway resource path, or route
+	// key, is used as the span route and resource when Route and Resource are empty. The spans of
+	// these requests are also named "aws.apigateway.request" instead of "http.request", and given
+	// the "server" span kind, unless overridden by SpanOpts. Requests not coming from API Gateway
+	// are left untouched.
+	RecordAPIGatewayInfo bool
+	// RecordHandlerTimeout should be true in order to tag with "http.timeout" set to true the requests
+	// cut off by an http.TimeoutHandler wrapped by TraceAndServe, so that they can be told apart from
+	// the 503 responses of the handler itself. They are detected by their 503 status code and their
+	// body, which must be the message given to http.TimeoutHandler, as set by HandlerTimeoutMessage.
+	RecordHandlerTimeout bool
+	// HandlerTimeoutMessage optionally specifies the message given to http.TimeoutHandler, used by
+	// RecordHandlerTimeout to detect the timed out requests. The default message of
+	// http.TimeoutHandler is expected when empty.
+	HandlerTimeoutMessage string
+	// CollapseIDsInResource should be true in order to derive the resource name from the request
+	// path when neither Resource nor Route are set, replacing its path segments looking like IDs
+	// with the "{id}" placeholder to bound the number of resources (e.g. "GET /users/{id}" for
+	// "/users/123"). Numeric, UUID and hexadecimal IDs of at least 16 digits are collapsed.
+	CollapseIDsInResource bool
+	// WaitForBodyFlush should be true in order to flush the response once the handler returns,
+	// before finishing the request span, so that the span duration includes the time taken to
+	// send the response body still buffered by the server, such as the end of large downloads.
+	// Note that flushing the response prevents the server from setting the Content-Length header
+	// of responses small enough to be buffered, which are then sent with chunked encoding. It
+	// has no effect when the handler hijacked the connection, or when the ResponseWriter isn't
+	// an http.Flusher.
+	WaitForBodyFlush bool
+	// IgnorePreflight should be true in order to serve the CORS preflight requests without tracing them,
+	// to reduce the noise of CORS-heavy APIs. Preflight requests are the OPTIONS requests having both
+	// the Origin and Access-Control-Request-Method headers. The other OPTIONS requests are still traced,
+	// their resource being named after their method as for any other request (e.g. "OPTIONS /users").
+	IgnorePreflight bool
 }
 
+// SchemeTagConfig configures the "http.scheme" tag of the request spans.
+type SchemeTagConfig struct {
+	// Enabled should be true in order to tag the request spans with the request scheme.
+	Enabled bool
+	// ForwardedProtoHeader optionally specifies the request header holding the scheme of the requests
+	// received by a TLS-terminating proxy, such as X-Forwarded-Proto, taking precedence over the
+	// scheme of the connection.
+	ForwardedProtoHeader string
+}
+
+// defaultHandlerTimeoutMessage is the response body written by http.TimeoutHandler when given an empty message.
+const defaultHandlerTimeoutMessage = "<html><head><title>Timeout</title></head><body><h1>Timeout</h1></body></html>"
+
 // TraceAndServe serves the handler h using the given ResponseWriter and Request, applying tracing
 // according to the specified config.
 func TraceAndServe(h http.Handler, w http.ResponseWriter, r *http.Request, cfg *ServeConfig) {
 	if cfg == nil {
 		cfg = new(ServeConfig)
 	}
-	opts := append(cfg.SpanOpts, tracer.ServiceName(cfg.Service), tracer.ResourceName(cfg.Resource))
-	opts = append(opts, tracer.Tag(ext.HTTPRoute, cfg.Route))
-	span, ctx := httptrace.StartRequestSpan(r, opts...)
+	if cfg.IgnorePreflight && isPreflight(r) {
+		h.ServeHTTP(w, r)
+		return
+	}
+	resource, route := cfg.Resource, cfg.Route
+	var apigwOpts []ddtrace.StartSpanOption
+	if cfg.RecordAPIGatewayInfo {
+		if apigw, ok := apiGatewayContext(r); ok {
+			apigwOpts = apigw.spanOptions()
+			if route == "" {
+				route = apigw.route()
+			}
+			if resource == "" && route != "" {
+				resource = r.Method + " " + route
+			}
+		}
+	}
+	if cfg.CollapseIDsInResource && resource == "" && route == "" {
+		resource = r.Method + " " + collapsePathIDs(r.URL.Path)
+	}
+	// the API Gateway options come first so that they can be overridden by cfg.SpanOpts
+	opts := append(apigwOpts, cfg.SpanOpts...)
+	opts = append(opts, tracer.ServiceName(cfg.Service), tracer.ResourceName(resource))
+	opts = append(opts, tracer.Tag(ext.HTTPRoute, route))
+	if cfg.RecordTLSInfo && r.TLS != nil {
+		opts = append(opts,
+			tracer.Tag("tls.version", tlsVersionName(r.TLS.Version)),
+			tracer.Tag("tls.cipher", tls.CipherSuiteName(r.TLS.CipherSuite)))
+	}
+	if cfg.RecordSNI && r.TLS != nil && r.TLS.ServerName != "" {
+		opts = append(opts, tracer.Tag("tls.sni", r.TLS.ServerName))
+	}
+	if cfg.RecordContentLength {
+		if opt, ok := httptrace.RequestContentLengthTag(r); ok {
+			opts = append(opts, opt)
+		}
+	}
+	if cfg.RecordLocalAddr {
+		if port, ok := localPort(r); ok {
+			opts = append(opts, tracer.Tag("network.local.port", port))
+		}
+	}
+	if cfg.RecordRawPath {
+		opts = append(opts, httptrace.RawPathTag(r))
+	}
+	if cfg.SchemeTag != nil {
+		opts = append(opts, httptrace.SchemeTag(r, cfg.SchemeTag.Enabled, cfg.SchemeTag.ForwardedProtoHeader))
+	}
+	if cfg.RecordProtocol {
+		opts = append(opts, tracer.Tag("http.version", protoVersion(r.ProtoMajor, r.ProtoMinor)))
+	}
+	var (
+		span ddtrace.Span
+		ctx  = r.Context()
+	)
+	reused := false
+	if cfg.ReuseSpan {
+		span, reused = tracer.SpanFromContext(ctx)
+	}
+	if reused {
+		ctx = httptrace.SetRequestSpanTags(span, r, opts...)
+	} else if cfg.Propagator != nil {
+		span, ctx = httptrace.StartRequestSpanWithPropagator(r, cfg.Propagator, opts...)
+	} else {
+		span, ctx = httptrace.StartRequestSpan(r, opts...)
+	}
+	if cfg.ApplyDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = httptrace.ApplyRequestDeadline(ctx)
+		defer cancel()
+	}
+	start := time.Now()
+	if cfg.RequestStartHeader != "" {
+		if received, ok := parseRequestStart(r.Header.Get(cfg.RequestStartHeader)); ok && !received.After(start) {
+			span.SetTag("http.queue_time_ms", float64(start.Sub(received))/float64(time.Millisecond))
+		}
+	}
+	r = r.WithContext(ctx)
+	var body *countingReadCloser
+	if cfg.CountRequestBytes && r.Body != nil && r.Body != http.NoBody {
+		// r is a shallow copy at this point, so the body of the caller's request is left untouched
+		body = &countingReadCloser{ReadCloser: r.Body}
+		r.Body = body
+	}
 	rw, ddrw := wrapResponseWriter(w)
+	if cfg.RecordHandlerTimeout {
+		ddrw.timeoutBody = []byte(cfg.HandlerTimeoutMessage)
+		if cfg.HandlerTimeoutMessage == "" {
+			ddrw.timeoutBody = []byte(defaultHandlerTimeoutMessage)
+		}
+	}
 	defer func() {
+		if cfg.WaitForBodyFlush && !ddrw.hijacked {
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		if ddrw.timedOut {
+			span.SetTag("http.timeout", true)
+		}
+		if cfg.SlowRequestThreshold > 0 && time.Since(start) > cfg.SlowRequestThreshold {
+			span.SetTag("http.slow", true)
+		}
+		cacheHeaders := cfg.CacheHeaders
+		if cacheHeaders == nil {
+			cacheHeaders = httptrace.DefaultCacheHeaders()
+		}
+		httptrace.SetResponseCacheTag(span, cacheHeaders, w.Header())
+		if cfg.RecordContentType {
+			if ct := mediaType(w.Header().Get("Content-Type")); ct != "" {
+				span.SetTag("http.response.content_type", ct)
+			}
+		}
+		if cfg.CountRequestBytes {
+			span.SetTag("http.request.length", body.count())
+		}
+		if reused {
+			httptrace.SetResponseSpanTags(span, ddrw.status)
+			return
+		}
 		httptrace.FinishRequestSpan(span, ddrw.status, cfg.FinishOpts...)
 	}()
 
 	if appsec.Enabled() {
 		h = httpsec.WrapHandler(h, span, cfg.RouteParams)
 	}
-	h.ServeHTTP(rw, r.WithContext(ctx))
+	h.ServeHTTP(rw, r)
+}
+
+// countingReadCloser is an io.ReadCloser counting the bytes read from the wrapped io.ReadCloser.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64 // number of bytes read, accessed atomically
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// count returns the number of bytes read so far, which is zero for a nil countingReadCloser.
+func (c *countingReadCloser) count() int64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&c.n)
+}
+
+// isPreflight reports whether the given request is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// parseRequestStart parses the given request start header value holding an epoch timestamp in seconds, milliseconds or
+// microseconds, optionally prefixed with "t=", the unit being deduced from its magnitude.
+func parseRequestStart(v string) (time.Time, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "t=")
+	ts, err := strconv.ParseFloat(v, 64)
+	if err != nil || ts <= 0 || math.IsNaN(ts) || math.IsInf(ts, 0) {
+		return time.Time{}, false
+	}
+	var us float64
+	switch {
+	case ts > 1e15:
+		us = ts
+	case ts > 1e12:
+		us = ts * 1e3
+	default:
+		us = ts * 1e6
+	}
+	if us >= math.MaxInt64 {
+		// out of the range of time.UnixMicro
+		return time.Time{}, false
+	}
+	return time.UnixMicro(int64(us)), true
+}
+
+// pathIDRegexp matches the path segments looking like IDs: numbers, UUIDs and hexadecimal IDs of at least 16 digits.
+var pathIDRegexp = regexp.MustCompile(`^(?:[0-9]+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{16,})$`)
+
+// collapsePathIDs returns the given URL path with its segments looking like IDs replaced by the "{id}" placeholder.
+func collapsePathIDs(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if pathIDRegexp.MatchString(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// mediaType returns the lowercased media type of the given Content-Type header value, without its parameters.
+func mediaType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// localPort returns the local port the request was received on, as found in the request context
+// under http.LocalAddrContextKey. It reports false when unknown.
+func localPort(r *http.Request) (string, bool) {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok || addr == nil {
+		return "", false
+	}
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil || port == "" {
+		return "", false
+	}
+	return port, true
+}
+
+// protoVersion returns the HTTP protocol version with the given major and minor versions.
+func protoVersion(major, minor int) string {
+	return strconv.Itoa(major) + "." + strconv.Itoa(minor)
+}
+
+// tlsVersionName returns the name of the given TLS version.
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "1.0"
+	case tls.VersionTLS11:
+		return "1.1"
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS13:
+		return "1.3"
+	default:
+		return fmt.Sprintf("0x%04X", version)
+	}
 }
 
 // responseWriter is a small wrapper around an http response writer that will
@@ -71,10 +422,34 @@ func TraceAndServe(h http.Handler, w http.ResponseWriter, r *http.Request, cfg *
 type responseWriter struct {
 	http.ResponseWriter
 	status int
+	// timeoutBody is the response body of the http.TimeoutHandler timeouts to detect, if any.
+	timeoutBody []byte
+	// timedOut reports whether the response is an http.TimeoutHandler timeout.
+	timedOut bool
+	// wrote reports whether the response body was written to.
+	wrote bool
+	// hijacked reports whether the connection was hijacked by the handler.
+	hijacked bool
+}
+
+// hijackerFunc is an http.Hijacker calling itself.
+type hijackerFunc func() (net.Conn, *bufio.ReadWriter, error)
+
+func (f hijackerFunc) Hijack() (net.Conn, *bufio.ReadWriter, error) { return f() }
+
+// recordHijack returns an http.Hijacker calling the given one and recording whether the connection was hijacked.
+func (w *responseWriter) recordHijack(h http.Hijacker) http.Hijacker {
+	return hijackerFunc(func() (net.Conn, *bufio.ReadWriter, error) {
+		conn, rw, err := h.Hijack()
+		if err == nil {
+			w.hijacked = true
+		}
+		return conn, rw, err
+	})
 }
 
 func newResponseWriter(w http.ResponseWriter) *responseWriter {
-	return &responseWriter{w, 0}
+	return &responseWriter{ResponseWriter: w}
 }
 
 // Status returns the status code that was monitored.
@@ -89,6 +464,11 @@ func (w *responseWriter) Write(b []byte) (int, error) {
 	if w.status == 0 {
 		w.WriteHeader(http.StatusOK)
 	}
+	if !w.wrote {
+		// http.TimeoutHandler writes its whole timeout response body at once
+		w.timedOut = w.timeoutBody != nil && w.status == http.StatusServiceUnavailable && bytes.Equal(b, w.timeoutBody)
+		w.wrote = true
+	}
 	return w.ResponseWriter.Write(b)
 }
 
@@ -6,18 +6,26 @@
 package http
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 )
 
 func TestTraceAndServe(t *testing.T) {
@@ -328,6 +336,585 @@ func TestTraceAndServeHost(t *testing.T) {
 	})
 }
 
+func TestTraceAndServeReuseSpan(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span, ok := tracer.SpanFromContext(r.Context())
+		assert.True(t, ok)
+		span.SetTag("handler", "called")
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	t.Run("reused", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		parent, ctx := tracer.StartSpanFromContext(context.Background(), "parent", tracer.ServiceName("parent-service"))
+		r := httptest.NewRequest("GET", "/hello", nil).WithContext(ctx)
+		TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{ReuseSpan: true, Resource: "GET /hello", Route: "/hello"})
+		assert.Len(t, mt.FinishedSpans(), 0)
+		parent.Finish()
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		span := spans[0]
+		assert.Equal(t, "parent", span.OperationName())
+		assert.Equal(t, "parent-service", span.Tag(ext.ServiceName))
+		assert.Equal(t, "GET /hello", span.Tag(ext.ResourceName))
+		assert.Equal(t, "/hello", span.Tag(ext.HTTPRoute))
+		assert.Equal(t, "GET", span.Tag(ext.HTTPMethod))
+		assert.Equal(t, "http://example.com/hello", span.Tag(ext.HTTPURL))
+		assert.Equal(t, "418", span.Tag(ext.HTTPCode))
+		assert.Equal(t, "called", span.Tag("handler"))
+	})
+
+	t.Run("no-span", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/hello", nil)
+		TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{ReuseSpan: true})
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "http.request", spans[0].OperationName())
+		assert.Equal(t, "called", spans[0].Tag("handler"))
+	})
+}
+
+func TestTraceAndServeContentType(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		enabled     bool
+		contentType string
+		expected    interface{}
+	}{
+		{name: "json", enabled: true, contentType: "application/json", expected: "application/json"},
+		{name: "params", enabled: true, contentType: "Text/HTML; charset=utf-8", expected: "text/html"},
+		{name: "multipart", enabled: true, contentType: "multipart/form-data; boundary=abc", expected: "multipart/form-data"},
+		{name: "none", enabled: true},
+		{name: "disabled", contentType: "application/json"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.contentType != "" {
+					w.Header().Set("Content-Type", tc.contentType)
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+			r := httptest.NewRequest("GET", "/", nil)
+			TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{RecordContentType: tc.enabled})
+			span := mt.FinishedSpans()[0]
+			assert.Equal(t, tc.expected, span.Tag("http.response.content_type"))
+		})
+	}
+}
+
+// failingPropagator is a tracer.Propagator never finding a span context.
+type failingPropagator struct{}
+
+func (failingPropagator) Inject(ddtrace.SpanContext, interface{}) error { return nil }
+
+func (failingPropagator) Extract(interface{}) (ddtrace.SpanContext, error) {
+	return nil, tracer.ErrSpanContextNotFound
+}
+
+func TestTraceAndServePropagator(t *testing.T) {
+	// the mock tracer only supports its own span contexts, so the real tracer is used
+	tracer.Start(tracer.WithLogger(log.DiscardLogger{}))
+	defer tracer.Stop()
+
+	for _, tc := range []struct {
+		name       string
+		propagator tracer.Propagator
+		datadog    bool // send the datadog headers rather than the B3 ones
+		joined     bool
+	}{
+		{name: "b3", propagator: tracer.NewPropagator(&tracer.PropagatorConfig{B3: true}), joined: true},
+		{name: "default"},
+		{name: "default-datadog", datadog: true, joined: true},
+		// the global propagator isn't used when the custom one fails
+		{name: "failing", propagator: failingPropagator{}, datadog: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var traceID uint64
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				span, ok := tracer.SpanFromContext(r.Context())
+				require.True(t, ok)
+				traceID = span.Context().TraceID()
+			})
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.datadog {
+				r.Header.Set(tracer.DefaultTraceIDHeader, "170")
+				r.Header.Set(tracer.DefaultParentIDHeader, "187")
+			} else {
+				r.Header.Set("X-B3-TraceId", "000000000000000000000000000000aa")
+				r.Header.Set("X-B3-SpanId", "00000000000000bb")
+				r.Header.Set("X-B3-Sampled", "1")
+			}
+			TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{Propagator: tc.propagator})
+			assert.Equal(t, tc.joined, traceID == 0xaa)
+		})
+	}
+}
+
+func TestTraceAndServeQueueTime(t *testing.T) {
+	received := time.Now().Add(-100 * time.Millisecond)
+	for _, tc := range []struct {
+		name   string
+		header string
+		value  string
+		queued bool
+	}{
+		{name: "millis", header: "X-Request-Start", value: strconv.FormatInt(received.UnixMilli(), 10), queued: true},
+		{name: "micros", header: "X-Request-Start", value: "t=" + strconv.FormatInt(received.UnixMicro(), 10), queued: true},
+		{name: "seconds", header: "X-Request-Start", value: fmt.Sprintf("t=%.3f", float64(received.UnixMilli())/1e3), queued: true},
+		{name: "malformed", header: "X-Request-Start", value: "t=yesterday"},
+		{name: "nan", header: "X-Request-Start", value: "t=NaN"},
+		{name: "inf", header: "X-Request-Start", value: "+Inf"},
+		{name: "overflow", header: "X-Request-Start", value: "1e30"},
+		{name: "future", header: "X-Request-Start", value: strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)},
+		{name: "disabled", value: strconv.FormatInt(received.UnixMilli(), 10)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Request-Start", tc.value)
+			TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{RequestStartHeader: tc.header})
+			tag := mt.FinishedSpans()[0].Tag("http.queue_time_ms")
+			if !tc.queued {
+				assert.Nil(t, tag)
+				return
+			}
+			require.IsType(t, float64(0), tag)
+			assert.GreaterOrEqual(t, tag.(float64), float64(99))
+			assert.Less(t, tag.(float64), float64(time.Minute/time.Millisecond))
+		})
+	}
+}
+
+func TestTraceAndServeSlowRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		threshold time.Duration
+		sleep     time.Duration
+		expected  interface{}
+	}{
+		{name: "slow", threshold: time.Millisecond, sleep: 10 * time.Millisecond, expected: true},
+		{name: "fast", threshold: time.Hour},
+		{name: "disabled", sleep: 10 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tc.sleep)
+				w.WriteHeader(http.StatusOK)
+			})
+			r := httptest.NewRequest("GET", "/", nil)
+			TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{SlowRequestThreshold: tc.threshold})
+			span := mt.FinishedSpans()[0]
+			assert.Equal(t, tc.expected, span.Tag("http.slow"))
+		})
+	}
+}
+
+func TestTraceAndServeProtocol(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		major   int
+		minor   int
+		version interface{}
+	}{
+		{name: "http/1.1", enabled: true, major: 1, minor: 1, version: "1.1"},
+		{name: "http/2", enabled: true, major: 2, version: "2.0"},
+		{name: "disabled", major: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.ProtoMajor, r.ProtoMinor = tc.major, tc.minor
+			TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{RecordProtocol: tc.enabled})
+			span := mt.FinishedSpans()[0]
+			assert.Equal(t, tc.version, span.Tag("http.version"))
+		})
+	}
+}
+
+func TestTraceAndServeTLSInfo(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		tls     *tls.ConnectionState
+		version interface{}
+		cipher  interface{}
+	}{
+		{
+			name:    "enabled",
+			enabled: true,
+			tls:     &tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			version: "1.2",
+			cipher:  "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		},
+		{
+			name:    "disabled",
+			enabled: false,
+			tls:     &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256},
+		},
+		{
+			name:    "no-tls",
+			enabled: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.TLS = tc.tls
+			TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{RecordTLSInfo: tc.enabled})
+			span := mt.FinishedSpans()[0]
+			assert.Equal(t, tc.version, span.Tag("tls.version"))
+			assert.Equal(t, tc.cipher, span.Tag("tls.cipher"))
+		})
+	}
+}
+
+func TestTraceAndServeCacheHeaders(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cache", "MISS")
+		w.Write([]byte("Hello World"))
+	})
+	for _, tc := range []struct {
+		name     string
+		handler  http.Handler
+		expected interface{}
+	}{
+		{name: "serve-config", handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			TraceAndServe(handler, w, r, &ServeConfig{CacheHeaders: []string{"X-Cache"}})
+		}), expected: "miss"},
+		{name: "wrap-handler", handler: WrapHandler(handler, "service", "resource", WithCacheHeaders("X-Cache")), expected: "miss"},
+		{name: "disabled", handler: WrapHandler(handler, "service", "resource", WithCacheHeaders())},
+		{name: "default", handler: WrapHandler(handler, "service", "resource")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			tc.handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.expected, spans[0].Tag("http.cache"))
+		})
+	}
+}
+
+func TestTraceAndServeSchemeTag(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tc := range []struct {
+		name     string
+		handler  http.Handler
+		expected interface{}
+	}{
+		{name: "default", handler: WrapHandler(handler, "service", "resource"), expected: "http"},
+		{name: "serve-config", handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			TraceAndServe(handler, w, r, &ServeConfig{SchemeTag: &SchemeTagConfig{Enabled: true, ForwardedProtoHeader: "X-Forwarded-Proto"}})
+		}), expected: "https"},
+		{name: "disabled", handler: WrapHandler(handler, "service", "resource", WithSchemeTag(false, ""))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Forwarded-Proto", "https")
+			tc.handler.ServeHTTP(httptest.NewRecorder(), r)
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.expected, spans[0].Tag("http.scheme"))
+		})
+	}
+}
+
+func TestTraceAndServeContentLength(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, tc := range []struct {
+		name     string
+		enabled  bool
+		length   int64
+		expected interface{}
+	}{
+		{name: "enabled", enabled: true, length: 5, expected: int64(5)},
+		{name: "disabled", length: 5},
+		{name: "unknown", enabled: true, length: -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			r := httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+			r.ContentLength = tc.length
+			TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{RecordContentLength: tc.enabled})
+			span := mt.FinishedSpans()[0]
+			assert.Equal(t, tc.expected, span.Tag("http.request.content_length"))
+		})
+	}
+}
+
+func TestWithRequestContentLength(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, h := range []http.Handler{
+		WrapHandler(handler, "service", "resource", WithRequestContentLength(true)),
+		WrapHandler(handler, "service", "resource"),
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader("hello")))
+	}
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, int64(5), spans[0].Tag("http.request.content_length"))
+	assert.Nil(t, spans[1].Tag("http.request.content_length"))
+}
+
+func TestTraceAndServeCountRequestBytes(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		enabled  bool
+		body     io.Reader
+		length   int64
+		read     int64 // number of bytes read by the handler, or all when negative
+		expected interface{}
+	}{
+		{name: "enabled", enabled: true, body: strings.NewReader("hello"), length: 5, read: -1, expected: int64(5)},
+		{name: "chunked", enabled: true, body: strings.NewReader("hello"), length: -1, read: -1, expected: int64(5)},
+		{name: "partially-read", enabled: true, body: strings.NewReader("hello"), length: 5, read: 3, expected: int64(3)},
+		{name: "no-body", enabled: true, expected: int64(0)},
+		{name: "disabled", body: strings.NewReader("hello"), length: 5, read: -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			r := httptest.NewRequest("POST", "/", tc.body)
+			r.ContentLength = tc.length
+			body := r.Body
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var err error
+				if tc.read < 0 {
+					_, err = io.ReadAll(r.Body)
+				} else {
+					_, err = io.CopyN(io.Discard, r.Body, tc.read)
+				}
+				assert.NoError(t, err)
+				assert.NoError(t, r.Body.Close())
+			})
+			TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{CountRequestBytes: tc.enabled})
+			assert.Equal(t, body, r.Body, "the body of the given request should be left untouched")
+			span := mt.FinishedSpans()[0]
+			assert.Equal(t, tc.expected, span.Tag("http.request.length"))
+		})
+	}
+}
+
+func TestTraceAndServeRawPath(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, tc := range []struct {
+		name     string
+		enabled  bool
+		expected interface{}
+	}{
+		{name: "enabled", enabled: true, expected: "/users/123"},
+		{name: "disabled"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			r := httptest.NewRequest("GET", "/users/123?page=2", nil)
+			TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{Route: "/users/{id}", RecordRawPath: tc.enabled})
+			span := mt.FinishedSpans()[0]
+			assert.Equal(t, tc.expected, span.Tag("http.path"))
+			assert.Equal(t, "/users/{id}", span.Tag(ext.HTTPRoute))
+		})
+	}
+}
+
+func TestTraceAndServeIgnorePreflight(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		method  string
+		headers map[string]string
+		traced  bool
+	}{
+		{
+			name:    "preflight",
+			enabled: true,
+			method:  "OPTIONS",
+			headers: map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "POST"},
+		},
+		{
+			name:    "disabled",
+			method:  "OPTIONS",
+			headers: map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "POST"},
+			traced:  true,
+		},
+		{
+			name:    "options",
+			enabled: true,
+			method:  "OPTIONS",
+			traced:  true,
+		},
+		{
+			name:    "cors-get",
+			enabled: true,
+			method:  "GET",
+			headers: map[string]string{"Origin": "https://example.com"},
+			traced:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			called := false
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusNoContent)
+			})
+			r := httptest.NewRequest(tc.method, "/users", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			TraceAndServe(handler, w, r, &ServeConfig{IgnorePreflight: tc.enabled})
+			assert.True(t, called)
+			assert.Equal(t, http.StatusNoContent, w.Code)
+			spans := mt.FinishedSpans()
+			if !tc.traced {
+				assert.Len(t, spans, 0)
+				return
+			}
+			require.Len(t, spans, 1)
+			assert.Equal(t, tc.method, spans[0].Tag(ext.HTTPMethod))
+		})
+	}
+}
+
+func TestTraceAndServeLocalAddr(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, tc := range []struct {
+		name     string
+		enabled  bool
+		addr     net.Addr
+		expected interface{}
+	}{
+		{name: "enabled", enabled: true, addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8081}, expected: "8081"},
+		{name: "ipv6", enabled: true, addr: &net.TCPAddr{IP: net.IPv6loopback, Port: 9090}, expected: "9090"},
+		{name: "disabled", addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8081}},
+		{name: "unknown", enabled: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.addr != nil {
+				r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, tc.addr))
+			}
+			TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{RecordLocalAddr: tc.enabled})
+			span := mt.FinishedSpans()[0]
+			assert.Equal(t, tc.expected, span.Tag("network.local.port"))
+		})
+	}
+
+	t.Run("server", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			TraceAndServe(handler, w, r, &ServeConfig{RecordLocalAddr: true})
+		}))
+		defer srv.Close()
+		res, err := srv.Client().Get(srv.URL)
+		require.NoError(t, err)
+		res.Body.Close()
+
+		_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+		require.NoError(t, err)
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, port, spans[0].Tag("network.local.port"))
+	})
+}
+
+func TestTraceAndServeSNI(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		tls     *tls.ConnectionState
+		sni     interface{}
+	}{
+		{
+			name:    "enabled",
+			enabled: true,
+			tls:     &tls.ConnectionState{ServerName: "tenant.example.com"},
+			sni:     "tenant.example.com",
+		},
+		{
+			name:    "disabled",
+			enabled: false,
+			tls:     &tls.ConnectionState{ServerName: "tenant.example.com"},
+		},
+		{
+			name:    "no-server-name",
+			enabled: true,
+			tls:     &tls.ConnectionState{},
+		},
+		{
+			name:    "no-tls",
+			enabled: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.TLS = tc.tls
+			TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{RecordSNI: tc.enabled})
+			span := mt.FinishedSpans()[0]
+			assert.Equal(t, tc.sni, span.Tag("tls.sni"))
+		})
+	}
+}
+
 type noopHandler struct{}
 
 func (noopHandler) ServeHTTP(_ http.ResponseWriter, _ *http.Request) {}
@@ -355,3 +942,203 @@ func BenchmarkTraceAndServe(b *testing.B) {
 		TraceAndServe(handler, noopWriter{}, req, &cfg)
 	}
 }
+
+func TestTraceAndServeAPIGateway(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("rest-api", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/users/123", nil)
+		r.Header.Set("X-GoLambdaProxy-ApiGw-Context", `{"apiId":"abc123","stage":"prod","requestId":"req-1","domainName":"abc123.execute-api.us-east-1.amazonaws.com","resourcePath":"/users/{id}"}`)
+		TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{RecordAPIGatewayInfo: true})
+
+		span := mt.FinishedSpans()[0]
+		assert.Equal(t, "abc123", span.Tag("aws.apigateway.api_id"))
+		assert.Equal(t, "prod", span.Tag("aws.apigateway.stage"))
+		assert.Equal(t, "req-1", span.Tag("aws.apigateway.request_id"))
+		assert.Equal(t, "abc123.execute-api.us-east-1.amazonaws.com", span.Tag("aws.apigateway.domain_name"))
+		assert.Equal(t, "/users/{id}", span.Tag(ext.HTTPRoute))
+		assert.Equal(t, "GET /users/{id}", span.Tag(ext.ResourceName))
+		assert.Equal(t, "aws.apigateway.request", span.Tag(ext.SpanName))
+		assert.Equal(t, ext.SpanKindServer, span.Tag(ext.SpanKind))
+	})
+
+	t.Run("span-opts-override", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Amzn-Apigateway-Api-Id", "abc123")
+		TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{
+			RecordAPIGatewayInfo: true,
+			SpanOpts:             []ddtrace.StartSpanOption{tracer.Tag(ext.SpanName, "lambda.request")},
+		})
+
+		span := mt.FinishedSpans()[0]
+		assert.Equal(t, "lambda.request", span.Tag(ext.SpanName))
+		assert.Equal(t, "abc123", span.Tag("aws.apigateway.api_id"))
+	})
+
+	t.Run("http-api", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/users/123", nil)
+		r.Header.Set("X-GoLambdaProxy-ApiGw-Context", `{"apiId":"abc123","stage":"$default","routeKey":"GET /users/{id}"}`)
+		TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{RecordAPIGatewayInfo: true, Resource: "users"})
+
+		span := mt.FinishedSpans()[0]
+		assert.Equal(t, "abc123", span.Tag("aws.apigateway.api_id"))
+		assert.Equal(t, "/users/{id}", span.Tag(ext.HTTPRoute))
+		assert.Equal(t, "users", span.Tag(ext.ResourceName))
+	})
+
+	t.Run("api-id-header", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Amzn-Apigateway-Api-Id", "abc123")
+		TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{RecordAPIGatewayInfo: true})
+
+		span := mt.FinishedSpans()[0]
+		assert.Equal(t, "abc123", span.Tag("aws.apigateway.api_id"))
+		assert.Nil(t, span.Tag("aws.apigateway.stage"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Amzn-Apigateway-Api-Id", "abc123")
+		TraceAndServe(handler, httptest.NewRecorder(), r, &ServeConfig{})
+
+		span := mt.FinishedSpans()[0]
+		assert.Nil(t, span.Tag("aws.apigateway.api_id"))
+		assert.Nil(t, span.Tag(ext.SpanName))
+		assert.Nil(t, span.Tag(ext.SpanKind))
+	})
+}
+
+func TestTraceAndServeHandlerTimeout(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	unavailable := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("maintenance"))
+	})
+	for _, tc := range []struct {
+		name     string
+		handler  http.Handler
+		cfg      ServeConfig
+		expected interface{}
+	}{
+		{
+			name:     "default-message",
+			handler:  http.TimeoutHandler(slow, time.Millisecond, ""),
+			cfg:      ServeConfig{RecordHandlerTimeout: true},
+			expected: true,
+		},
+		{
+			name:     "custom-message",
+			handler:  http.TimeoutHandler(slow, time.Millisecond, "too slow"),
+			cfg:      ServeConfig{RecordHandlerTimeout: true, HandlerTimeoutMessage: "too slow"},
+			expected: true,
+		},
+		{
+			name:    "handler-503",
+			handler: http.TimeoutHandler(unavailable, time.Minute, ""),
+			cfg:     ServeConfig{RecordHandlerTimeout: true},
+		},
+		{
+			name:    "disabled",
+			handler: http.TimeoutHandler(slow, time.Millisecond, ""),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			w := httptest.NewRecorder()
+			TraceAndServe(tc.handler, w, httptest.NewRequest("GET", "/", nil), &tc.cfg)
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+			span := mt.FinishedSpans()[0]
+			assert.Equal(t, "503", span.Tag(ext.HTTPCode))
+			assert.Equal(t, tc.expected, span.Tag("http.timeout"))
+		})
+	}
+}
+
+func TestTraceAndServeCollapseIDsInResource(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		path     string
+		cfg      ServeConfig
+		expected string
+	}{
+		{name: "numeric", path: "/users/123/orders/45", cfg: ServeConfig{CollapseIDsInResource: true}, expected: "GET /users/{id}/orders/{id}"},
+		{name: "uuid", path: "/users/7c9e6679-7425-40de-944b-e07fc1f90ae7", cfg: ServeConfig{CollapseIDsInResource: true}, expected: "GET /users/{id}"},
+		{name: "hex", path: "/objects/507f1f77bcf86cd799439011/", cfg: ServeConfig{CollapseIDsInResource: true}, expected: "GET /objects/{id}/"},
+		{name: "no-id", path: "/users/me/v2", cfg: ServeConfig{CollapseIDsInResource: true}, expected: "GET /users/me/v2"},
+		{name: "short-hex", path: "/colors/beef", cfg: ServeConfig{CollapseIDsInResource: true}, expected: "GET /colors/beef"},
+		{name: "resource", path: "/users/123", cfg: ServeConfig{CollapseIDsInResource: true, Resource: "users"}, expected: "users"},
+		{name: "route", path: "/users/123", cfg: ServeConfig{CollapseIDsInResource: true, Route: "/users/:id"}, expected: ""},
+		{name: "disabled", path: "/users/123", expected: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			TraceAndServe(handler, httptest.NewRecorder(), httptest.NewRequest("GET", tc.path, nil), &tc.cfg)
+			assert.Equal(t, tc.expected, mt.FinishedSpans()[0].Tag(ext.ResourceName))
+		})
+	}
+}
+
+func TestTraceAndServeWaitForBodyFlush(t *testing.T) {
+	t.Run("flush", func(t *testing.T) {
+		for _, enabled := range []bool{true, false} {
+			mt := mocktracer.Start()
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("large body"))
+			})
+			w := httptest.NewRecorder()
+			TraceAndServe(handler, w, httptest.NewRequest("GET", "/", nil), &ServeConfig{WaitForBodyFlush: enabled})
+			assert.Equal(t, enabled, w.Flushed)
+			assert.Len(t, mt.FinishedSpans(), 1)
+			mt.Stop()
+		}
+	})
+
+	t.Run("hijack", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+			rw.Flush()
+		})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			TraceAndServe(handler, w, r, &ServeConfig{WaitForBodyFlush: true})
+		}))
+		defer srv.Close()
+
+		res, err := srv.Client().Get(srv.URL)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(body))
+
+		require.Eventually(t, func() bool { return len(mt.FinishedSpans()) == 1 }, time.Second, 10*time.Millisecond)
+	})
+}
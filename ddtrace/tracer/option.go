@@ -23,6 +23,7 @@ import (
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/namingschema"
@@ -154,6 +155,9 @@ type config struct {
 
 	// spanAttributeSchemaVersion holds the selected DD_TRACE_SPAN_ATTRIBUTE_SCHEMA version.
 	spanAttributeSchemaVersion int
+
+	// appsecOpts holds the options AppSec is started with, when enabled.
+	appsecOpts []appsec.StartOption
 }
 
 // HasFeature reports whether feature f is enabled.
@@ -793,6 +797,16 @@ func WithProfilerEndpoints(enabled bool) StartOption {
 	}
 }
 
+// WithAppSecOptions configures Application Security with the given options of the
+// gopkg.in/DataDog/dd-trace-go.v1/appsec package, such as appsec.WithBodyAnalysis. They
+// only apply when AppSec is enabled, using the appsec build tag and the DD_APPSEC_ENABLED
+// environment variable. When given several times, the options are applied in order.
+func WithAppSecOptions(opts ...appsec.StartOption) StartOption {
+	return func(c *config) {
+		c.appsecOpts = append(c.appsecOpts, opts...)
+	}
+}
+
 // StartSpanOption is a configuration option for StartSpan. It is aliased in order
 // to help godoc group all the functions returning it together. It is considered
 // more correct to refer to it as the type as the origin, ddtrace.StartSpanOption.
@@ -142,7 +142,8 @@ func Start(opts ...StartOption) {
 	cfg.Env = t.config.env
 	cfg.HTTP = t.config.httpClient
 	cfg.ServiceName = t.config.serviceName
-	appsec.Start(appsec.WithRCConfig(cfg))
+	appsecOpts := []appsec.StartOption{appsec.WithRCConfig(cfg), appsec.WithStatsdClient(t.statsd)}
+	appsec.Start(append(appsecOpts, t.config.appsecOpts...)...)
 	// start instrumentation telemetry unless it is disabled through the
 	// DD_INSTRUMENTATION_TELEMETRY_ENABLED env var
 	startTelemetry(t.config)
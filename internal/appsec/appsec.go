@@ -9,11 +9,16 @@
 package appsec
 
 import (
+	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/httpsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/remoteconfig"
+
+	waf "github.com/DataDog/go-libddwaf"
 )
 
 // Enabled returns true when AppSec is up and running. Meaning that the appsec build tag is enabled, the env var
@@ -46,6 +51,10 @@ func Start(opts ...StartOption) {
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	if cfg.rulesErr != nil {
+		logUnexpectedStartError(cfg.rulesErr)
+		return
+	}
 	appsec := newAppSec(cfg)
 	appsec.startRC()
 
@@ -66,12 +75,56 @@ func Start(opts ...StartOption) {
 	setActiveAppSec(appsec)
 }
 
+// RequiredAddresses returns the sorted list of the WAF addresses used by the security rules currently in use, such as
+// "server.request.body" when the request body is required by a rule, or nil when AppSec isn't running. The addresses
+// are reported regardless of their support and of the configuration disabling their monitoring, such as
+// WithBodyAnalysis.
+func RequiredAddresses() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if activeAppSec == nil || !activeAppSec.started {
+		return nil
+	}
+	addresses, _ := activeAppSec.addresses.Load().([]string)
+	return append([]string(nil), addresses...)
+}
+
+// Status returns whether AppSec is enabled and healthy, along with a human-readable description of its status, such as
+// the number of security rules loaded by the WAF and the remote configuration connectivity. AppSec is healthy when it is
+// running with a WAF having loaded security rules. The remote configuration connectivity doesn't affect its health since
+// the security rules in use keep being enforced without it, but only reported in the details.
+func Status() (enabled, healthy bool, details string) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if activeAppSec == nil {
+		return false, false, "appsec is not started"
+	}
+	rcStatus := "disabled"
+	if rc := activeAppSec.rc; rc != nil {
+		if rc.Connected() {
+			rcStatus = "connected"
+		} else {
+			rcStatus = "disconnected"
+		}
+	}
+	if !activeAppSec.started {
+		return false, false, "appsec is waiting for its activation through remote configuration; remote config: " + rcStatus
+	}
+	info, _ := activeAppSec.rulesInfo.Load().(*waf.RulesetInfo)
+	if info == nil {
+		return true, false, "waf: not loaded; remote config: " + rcStatus
+	}
+	details = fmt.Sprintf("waf: %d security rules loaded, %d failed, rules version %q; remote config: %s", info.Loaded, info.Failed, info.Version, rcStatus)
+	return true, info.Loaded > 0, details
+}
+
 // Implement the AppSec log message C1
 func logUnexpectedStartError(err error) {
 	log.Error("appsec: could not start because of an unexpected error: %v\nNo security activities will be collected. Please contact support at https://docs.datadoghq.com/help/ for help.", err)
 }
 
-// Stop AppSec.
+// Stop AppSec. The remote configuration client is stopped once its update in progress, if any, completes, and the
+// pending security events of the event sink are reported, both within bounded timeouts.
 func Stop() {
 	setActiveAppSec(nil)
 }
@@ -83,12 +136,16 @@ var (
 
 func setActiveAppSec(a *appsec) {
 	mu.Lock()
-	defer mu.Unlock()
-	if activeAppSec != nil {
-		activeAppSec.stopRC()
-		activeAppSec.stop()
-	}
+	old := activeAppSec
 	activeAppSec = a
+	mu.Unlock()
+	// Stop the previous AppSec outside of the mutex, as stopping its remote config client can wait for the update in
+	// progress to complete, which would otherwise block Enabled() and the other functions acquiring the mutex. Its
+	// remote config client is stopped first so that no remote config update restarts it afterwards.
+	if old != nil {
+		old.stopRC()
+		old.stop()
+	}
 }
 
 type appsec struct {
@@ -97,6 +154,17 @@ type appsec struct {
 	limiter       *TokenTicker
 	rc            *remoteconfig.Client
 	started       bool
+	// addresses are the sorted addresses of the security rules of the registered WAF, as a []string. It is atomic as
+	// the WAF is registered and unregistered by remote config updates, outside of the global mutex.
+	addresses atomic.Value
+	// rulesInfo is the loading information of the security rules of the registered WAF, as a *waf.RulesetInfo. It is
+	// atomic for the same reason as addresses.
+	rulesInfo atomic.Value
+}
+
+// setRulesInfo sets the loading information of the security rules of the registered WAF, or nil when unregistered.
+func (a *appsec) setRulesInfo(info *waf.RulesetInfo) {
+	a.rulesInfo.Store(info)
 }
 
 func newAppSec(cfg *Config) *appsec {
@@ -118,6 +186,7 @@ func newAppSec(cfg *Config) *appsec {
 func (a *appsec) start() error {
 	a.limiter = NewTokenTicker(int64(a.cfg.traceRateLimit), int64(a.cfg.traceRateLimit))
 	a.limiter.Start()
+	httpsec.SetCollectedRequestHeaders(a.cfg.collectedHeaders, a.cfg.maxCollectedHeadersSize)
 	// Register the WAF operation event listener
 	unregisterWAF, err := a.registerWAF()
 	if err != nil {
@@ -134,5 +203,6 @@ func (a *appsec) stop() {
 		a.started = false
 		a.unregisterWAF()
 		a.limiter.Stop()
+		httpsec.SetCollectedRequestHeaders(nil, 0)
 	}
 }
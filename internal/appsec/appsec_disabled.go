@@ -31,6 +31,16 @@ func Start(...StartOption) {
 	}
 }
 
+// RequiredAddresses returns nil since AppSec is disabled.
+func RequiredAddresses() []string {
+	return nil
+}
+
+// Status returns that AppSec is neither enabled nor healthy since it is disabled.
+func Status() (enabled, healthy bool, details string) {
+	return false, false, "appsec is not compiled: please add the go build tag `appsec` to your build options to enable it"
+}
+
 // Stop AppSec.
 func Stop() {}
 
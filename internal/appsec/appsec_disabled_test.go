@@ -37,5 +37,9 @@ func TestEnabled(t *testing.T) {
 	assert.False(t, appsec.Enabled())
 	tracer.Stop()
 	assert.False(t, appsec.Enabled())
-
+	assert.Nil(t, appsec.RequiredAddresses())
+	enabled, healthy, details := appsec.Status()
+	assert.False(t, enabled)
+	assert.False(t, healthy)
+	assert.Contains(t, details, "build tag")
 }
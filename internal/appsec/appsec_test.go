@@ -10,6 +10,7 @@ package appsec_test
 
 import (
 	"os"
+	"sort"
 	"strconv"
 	"testing"
 
@@ -32,6 +33,43 @@ func TestEnabled(t *testing.T) {
 	assert.False(t, appsec.Enabled())
 }
 
+func TestRequiredAddresses(t *testing.T) {
+	require.Nil(t, appsec.RequiredAddresses())
+	appsec.Start()
+	if !appsec.Enabled() {
+		appsec.Stop()
+		t.Skip("AppSec needs to be enabled for this test")
+	}
+	addresses := appsec.RequiredAddresses()
+	require.NotEmpty(t, addresses)
+	require.True(t, sort.StringsAreSorted(addresses))
+	require.Contains(t, addresses, "server.request.query")
+	require.Contains(t, addresses, "server.request.body")
+	appsec.Stop()
+	require.Nil(t, appsec.RequiredAddresses())
+}
+
+func TestStatus(t *testing.T) {
+	enabled, healthy, details := appsec.Status()
+	require.False(t, enabled)
+	require.False(t, healthy)
+	require.Equal(t, "appsec is not started", details)
+	appsec.Start()
+	if !appsec.Enabled() {
+		appsec.Stop()
+		t.Skip("AppSec needs to be enabled for this test")
+	}
+	enabled, healthy, details = appsec.Status()
+	require.True(t, enabled)
+	require.True(t, healthy)
+	require.Contains(t, details, "security rules loaded")
+	require.Contains(t, details, "remote config: disabled")
+	appsec.Stop()
+	enabled, healthy, _ = appsec.Status()
+	require.False(t, enabled)
+	require.False(t, healthy)
+}
+
 // Test that everything goes well when simply starting and stopping appsec
 func TestStartStop(t *testing.T) {
 	// Use t.Setenv() to automatically restore the initial env var value, if set
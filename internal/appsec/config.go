@@ -6,14 +6,18 @@
 package appsec
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 	"unicode"
 	"unicode/utf8"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/remoteconfig"
 )
@@ -25,6 +29,8 @@ const (
 	traceRateLimitEnvVar  = "DD_APPSEC_TRACE_RATE_LIMIT"
 	obfuscatorKeyEnvVar   = "DD_APPSEC_OBFUSCATION_PARAMETER_KEY_REGEXP"
 	obfuscatorValueEnvVar = "DD_APPSEC_OBFUSCATION_PARAMETER_VALUE_REGEXP"
+	bodyAnalysisEnvVar    = "DD_APPSEC_BODY_ANALYSIS_ENABLED"
+	disabledRulesEnvVar   = "DD_APPSEC_DISABLED_RULES"
 )
 
 const (
@@ -49,6 +55,181 @@ type Config struct {
 	obfuscator ObfuscatorConfig
 	// rc is the remote configuration client used to receive product configuration updates. Nil if rc is disabled (default)
 	rc *remoteconfig.ClientConfig
+	// bodyAnalysis reports whether the request body is passed to the WAF.
+	bodyAnalysis bool
+	// minimal reports whether only the blocking rules are run, without the optional data collections.
+	minimal bool
+	// statsd is the DogStatsD client used to report the AppSec metrics. Nil if the metrics are disabled (default)
+	statsd StatsdClient
+	// eventEnricher is called when security events were produced for an HTTP request. Nil if not set (default)
+	eventEnricher instrumentation.EventEnricher
+	// wafSampleRate is the fraction of the requests monitored by the WAF, between 0 and 1 (1 by default)
+	wafSampleRate float64
+	// monitoringEventKeepRate is the fraction of the traces with monitoring-only security events that are force-kept,
+	// between 0 and 1 (1 by default)
+	monitoringEventKeepRate float64
+	// eventSink is called with every security event, out of the request path. Nil if not set (default)
+	eventSink func(event SecurityEvent)
+	// wafErrorBehavior is what happens to the requests when the WAF fails to run (fail-open by default)
+	wafErrorBehavior WAFErrorBehavior
+	// disabledRules are the IDs of the security rules removed from the rules, set by the env var
+	// DD_APPSEC_DISABLED_RULES. Nil if not set (default)
+	disabledRules []string
+	// rulesErr is the error of the rules loading done by the start options, if any. AppSec doesn't start when set.
+	rulesErr error
+	// collectedHeaders is the allowlist of the request headers collected into the security events. Nil if not set,
+	// in which case the default list of headers is collected (default)
+	collectedHeaders []string
+	// maxCollectedHeadersSize is the maximum total size of the collected request header names and values, beyond which
+	// they are not collected. Zero means no limit (default)
+	maxCollectedHeadersSize int
+}
+
+// StatsdClient is the subset of the DogStatsD client interface used to report the AppSec metrics.
+type StatsdClient interface {
+	Timing(name string, value time.Duration, tags []string, rate float64) error
+}
+
+// WithStatsdClient sets the DogStatsD client used to report the AppSec metrics, such as the WAF execution time.
+func WithStatsdClient(c StatsdClient) StartOption {
+	return func(cfg *Config) {
+		cfg.statsd = c
+	}
+}
+
+// WithEventEnricher sets the function called with the request context when security events were produced for an
+// HTTP request, before they get reported in the service entry span. It allows adding span tags correlating the events
+// with application-specific data found in the request context, such as internal request or tenant IDs. The function
+// must be safe for concurrent use and is called at most once per request.
+func WithEventEnricher(fn instrumentation.EventEnricher) StartOption {
+	return func(cfg *Config) {
+		cfg.eventEnricher = fn
+	}
+}
+
+// WithWAFSampleRate sets the fraction of the requests monitored by the WAF, between 0 and 1, in order to reduce the
+// AppSec overhead of latency-sensitive services. Every request is still traced, and the sampling decision is recorded
+// in the service entry span tag _dd.appsec.waf.sampled.
+//
+// Security implications: the requests that are not sampled are not protected at all. Attacks in them are neither
+// detected nor blocked, including the IP addresses and user IDs blocked by your denylists, and attackers sending
+// enough requests will eventually get through. Rates outside of [0, 1] are ignored.
+func WithWAFSampleRate(rate float64) StartOption {
+	return func(cfg *Config) {
+		if rate >= 0 && rate <= 1 {
+			cfg.wafSampleRate = rate
+		} else {
+			log.Error("appsec: ignoring the WAF sample rate %v: expecting a value between 0 and 1", rate)
+		}
+	}
+}
+
+// WithMonitoringEventKeepRate sets the fraction of the traces with monitoring-only security events that are
+// force-kept, between 0 and 1, in order to limit the trace volume sent to the backend under heavy attacks. The traces
+// of the requests blocked by AppSec are always kept. The other traces with security events are force-kept at the
+// given rate, and left to the regular trace sampling otherwise.
+//
+// Visibility implications: the security events are still reported in the service entry span, but the traces that
+// aren't force-kept may be dropped by the trace sampling, along with their security events, which then miss from the
+// security signals and attack statistics. The rate should therefore only be lowered when the trace volume due to
+// attacks becomes too costly. Rates outside of [0, 1] are ignored.
+func WithMonitoringEventKeepRate(rate float64) StartOption {
+	return func(cfg *Config) {
+		if rate >= 0 && rate <= 1 {
+			cfg.monitoringEventKeepRate = rate
+		} else {
+			log.Error("appsec: ignoring the monitoring event keep rate %v: expecting a value between 0 and 1", rate)
+		}
+	}
+}
+
+// WithEventSink sets the function called with every security event reported to Datadog, so that they can be forwarded
+// to other security systems, such as a SIEM. It is called out of the request path, sequentially from a dedicated
+// goroutine, so that it doesn't add latency to the requests. The security events are dropped when it is too slow to
+// keep up with them, and the number of dropped events is logged when AppSec stops. When AppSec stops, the pending
+// security events are reported before it returns, unless they take more than a few seconds, in which case the remaining
+// ones are dropped.
+func WithEventSink(fn func(event SecurityEvent)) StartOption {
+	return func(cfg *Config) {
+		cfg.eventSink = fn
+	}
+}
+
+// WAFErrorBehavior is what happens to the requests when the WAF fails to run, as set by WithWAFErrorBehavior.
+type WAFErrorBehavior int
+
+const (
+	// WAFFailOpen lets the requests proceed when the WAF fails to run (default).
+	WAFFailOpen WAFErrorBehavior = iota
+	// WAFFailClosed blocks the requests when the WAF fails to run.
+	WAFFailClosed
+)
+
+// String returns the name of the WAF error behavior.
+func (b WAFErrorBehavior) String() string {
+	switch b {
+	case WAFFailOpen:
+		return "fail_open"
+	case WAFFailClosed:
+		return "fail_closed"
+	default:
+		return fmt.Sprintf("WAFErrorBehavior(%d)", int(b))
+	}
+}
+
+// WithWAFErrorBehavior sets what happens to the requests when the WAF fails to run due to an internal error, such as a
+// failure to encode the request values, which is different from a WAF timeout. The default WAFFailOpen lets the
+// requests proceed unprotected, favoring availability, while WAFFailClosed blocks them with the default blocking
+// action, favoring security. Note that requests can only be blocked when the WAF fails before the handler responds,
+// such as when checking the client IP address at the beginning of the request, or the user ID passed to SetUser. In
+// both cases, the error is recorded in the service entry span tag _dd.appsec.waf.error, and counted in the
+// datadog.appsec.waf.error metric when the client given to WithStatsdClient provides the Incr method of the
+// DogStatsD client.
+func WithWAFErrorBehavior(b WAFErrorBehavior) StartOption {
+	return func(cfg *Config) {
+		switch b {
+		case WAFFailOpen, WAFFailClosed:
+			cfg.wafErrorBehavior = b
+		default:
+			log.Error("appsec: ignoring the unknown WAF error behavior %v", b)
+		}
+	}
+}
+
+// WithRulesFromFiles sets the security rules to the merge of the rules files at the given paths, taking precedence over
+// DD_APPSEC_RULES. The first file is the base ruleset, such as a copy of the recommended rules, and the next ones are
+// edits applied in order: their rules, exclusions, actions and rules data replace the base entries having the same ID
+// and are appended otherwise, while their other top-level fields, such as the version, replace the base ones. AppSec
+// doesn't start when a file cannot be read or parsed, and the error reports which one.
+func WithRulesFromFiles(paths ...string) StartOption {
+	return func(c *Config) {
+		if len(paths) == 0 {
+			return
+		}
+		var rules []byte
+		for i, path := range paths {
+			buf, err := os.ReadFile(path)
+			if err != nil {
+				c.rulesErr = fmt.Errorf("could not read the rules file %s: %v", path, err)
+				return
+			}
+			if i == 0 {
+				if !json.Valid(buf) {
+					c.rulesErr = fmt.Errorf("could not parse the rules file %s", path)
+					return
+				}
+				rules = buf
+				continue
+			}
+			if rules, err = mergeRules(rules, buf); err != nil {
+				c.rulesErr = fmt.Errorf("could not merge the rules file %s: %v", path, err)
+				return
+			}
+		}
+		log.Info("appsec: starting with the security rules merged from the files %v", paths)
+		c.rules = rules
+		c.rulesErr = nil
+	}
 }
 
 // WithRCConfig sets the AppSec remote config client configuration to the specified cfg
@@ -58,6 +239,48 @@ func WithRCConfig(cfg remoteconfig.ClientConfig) StartOption {
 	}
 }
 
+// WithBodyAnalysis enables or disables the analysis of the request body by the WAF. Disabling it reduces the WAF
+// execution time for services handling large bodies, but attacks only present in the request body will no longer be
+// detected nor blocked.
+func WithBodyAnalysis(enabled bool) StartOption {
+	return func(c *Config) {
+		c.bodyAnalysis = enabled
+	}
+}
+
+// WithMinimalMode restricts AppSec to the blocking of requests with the minimum per-request overhead: only the security
+// rules having a blocking action are run by the WAF, and neither the request body, nor the response status code and
+// headers, are passed to the WAF. Attacks detected by the other security rules are therefore no longer reported, and
+// the WAF is no longer run when the response is sent unless a blocking rule uses request data only available then.
+func WithMinimalMode() StartOption {
+	return func(c *Config) {
+		c.minimal = true
+	}
+}
+
+// WithCollectedHeaders sets the allowlist of the request headers collected into the security events, in place of the
+// default list of collected headers, in order to reduce the size of the security events and the exposure of personal
+// data. The header names are case-insensitive. The client IP header set with DD_TRACE_CLIENT_IP_HEADER is always
+// collected. The response headers collected into the security events are not affected.
+func WithCollectedHeaders(headers ...string) StartOption {
+	return func(c *Config) {
+		c.collectedHeaders = append([]string{}, headers...)
+	}
+}
+
+// WithMaxCollectedHeadersSize caps the total size, in bytes, of the names and values of the request headers collected
+// into the security events. The headers going over the size, considered in alphabetical order, are not collected.
+// Zero means no limit (default).
+func WithMaxCollectedHeadersSize(size int) StartOption {
+	return func(c *Config) {
+		if size >= 0 {
+			c.maxCollectedHeadersSize = size
+		} else {
+			log.Error("appsec: ignoring the maximum collected headers size %d: expecting a positive value", size)
+		}
+	}
+}
+
 // ObfuscatorConfig wraps the key and value regexp to be passed to the WAF to perform obfuscation.
 type ObfuscatorConfig struct {
 	KeyRegex   string
@@ -88,6 +311,11 @@ func newConfig() (*Config, error) {
 		wafTimeout:     readWAFTimeoutConfig(),
 		traceRateLimit: readRateLimitConfig(),
 		obfuscator:     readObfuscatorConfig(),
+		bodyAnalysis:   internal.BoolEnv(bodyAnalysisEnvVar, true),
+		disabledRules:  readDisabledRulesConfig(),
+		wafSampleRate:  1,
+
+		monitoringEventKeepRate: 1,
 	}, nil
 }
 
@@ -173,6 +401,103 @@ func readRulesConfig() (rules []byte, err error) {
 	return buf, nil
 }
 
+// ruleIDRegexp matches the well-formed security rule IDs, such as "crs-941-110".
+var ruleIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// readDisabledRulesConfig returns the IDs of the security rules to disable listed in the comma-separated env var
+// DD_APPSEC_DISABLED_RULES, such as "crs-941-110,ua0-600-55x", to silence noisy rules without code changes. The
+// malformed IDs are ignored.
+func readDisabledRulesConfig() (ids []string) {
+	value := os.Getenv(disabledRulesEnvVar)
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if !ruleIDRegexp.MatchString(id) {
+			log.Error("appsec: ignoring the malformed security rule ID %q of the env var %s", id, disabledRulesEnvVar)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// mergedRulesFields are the top-level ruleset fields holding lists of objects identified by their "id" field, which are
+// merged by ID by mergeRules.
+var mergedRulesFields = []string{"rules", "custom_rules", "exclusions", "actions", "rules_data"}
+
+// mergeRules returns the given base ruleset edited by the given ruleset edit. The entries of the edit lists of objects
+// identified by an ID replace the base entries having the same ID, and are appended otherwise. The other top-level
+// fields of the edit replace the base ones.
+func mergeRules(base, edit []byte) ([]byte, error) {
+	var ruleset, overlay map[string]json.RawMessage
+	if err := json.Unmarshal(base, &ruleset); err != nil {
+		return nil, fmt.Errorf("could not parse the base ruleset: %v", err)
+	}
+	if err := json.Unmarshal(edit, &overlay); err != nil {
+		return nil, fmt.Errorf("could not parse the ruleset: %v", err)
+	}
+	for _, field := range mergedRulesFields {
+		if _, ok := overlay[field]; !ok {
+			continue
+		}
+		merged, err := mergeRulesEntries(field, ruleset[field], overlay[field])
+		if err != nil {
+			return nil, err
+		}
+		overlay[field] = merged
+	}
+	for k, v := range overlay {
+		ruleset[k] = v
+	}
+	return json.Marshal(ruleset)
+}
+
+// mergeRulesEntries returns the given list of base entries, of the given ruleset field, with the given edit entries
+// replacing the base entries having the same ID, and appended otherwise.
+func mergeRulesEntries(field string, base, edit json.RawMessage) (json.RawMessage, error) {
+	var baseEntries, editEntries []json.RawMessage
+	if base != nil {
+		if err := json.Unmarshal(base, &baseEntries); err != nil {
+			return nil, fmt.Errorf("could not parse the %s of the base ruleset: %v", field, err)
+		}
+	}
+	if err := json.Unmarshal(edit, &editEntries); err != nil {
+		return nil, fmt.Errorf("could not parse the %s: %v", field, err)
+	}
+	entryID := func(entry json.RawMessage) (string, error) {
+		var v struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(entry, &v); err != nil || v.ID == "" {
+			return "", fmt.Errorf("could not find the id of an entry of the %s", field)
+		}
+		return v.ID, nil
+	}
+	index := make(map[string]int, len(baseEntries))
+	for i, entry := range baseEntries {
+		id, err := entryID(entry)
+		if err != nil {
+			return nil, err
+		}
+		index[id] = i
+	}
+	for _, entry := range editEntries {
+		id, err := entryID(entry)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			baseEntries[i] = entry
+			continue
+		}
+		index[id] = len(baseEntries)
+		baseEntries = append(baseEntries, entry)
+	}
+	return json.Marshal(baseEntries)
+}
+
 func logEnvVarParsingError(name, value string, err error, defaultValue interface{}) {
 	log.Error("appsec: could not parse the env var %s=%s as a duration: %v. Using default value %v.", name, value, err, defaultValue)
 }
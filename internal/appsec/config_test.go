@@ -10,6 +10,7 @@ package appsec
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -25,6 +26,10 @@ func TestConfig(t *testing.T) {
 			KeyRegex:   defaultObfuscatorKeyRegex,
 			ValueRegex: defaultObfuscatorValueRegex,
 		},
+		bodyAnalysis:  true,
+		wafSampleRate: 1,
+
+		monitoringEventKeepRate: 1,
 	}
 
 	t.Run("default", func(t *testing.T) {
@@ -247,6 +252,61 @@ func TestConfig(t *testing.T) {
 			})
 		})
 	})
+
+	t.Run("body-analysis", func(t *testing.T) {
+		t.Run("disabled", func(t *testing.T) {
+			expCfg := *expectedDefaultConfig
+			expCfg.bodyAnalysis = false
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(bodyAnalysisEnvVar, "false"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, &expCfg, cfg)
+		})
+
+		t.Run("not-parsable", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(bodyAnalysisEnvVar, "not a boolean"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, expectedDefaultConfig, cfg)
+		})
+	})
+
+	t.Run("disabled-rules", func(t *testing.T) {
+		t.Run("list", func(t *testing.T) {
+			expCfg := *expectedDefaultConfig
+			expCfg.disabledRules = []string{"crs-941-110", "ua0-600-55x"}
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(disabledRulesEnvVar, " crs-941-110,,ua0-600-55x "))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, &expCfg, cfg)
+		})
+
+		t.Run("malformed", func(t *testing.T) {
+			expCfg := *expectedDefaultConfig
+			expCfg.disabledRules = []string{"crs-941-110"}
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(disabledRulesEnvVar, "crs-941-110,not a rule id,{}"))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, &expCfg, cfg)
+		})
+
+		t.Run("empty-string", func(t *testing.T) {
+			restoreEnv := cleanEnv()
+			defer restoreEnv()
+			require.NoError(t, os.Setenv(disabledRulesEnvVar, ""))
+			cfg, err := newConfig()
+			require.NoError(t, err)
+			require.Equal(t, expectedDefaultConfig, cfg)
+		})
+	})
 }
 
 func cleanEnv() func() {
@@ -256,6 +316,8 @@ func cleanEnv() func() {
 		traceRateLimitEnvVar:  os.Getenv(traceRateLimitEnvVar),
 		obfuscatorKeyEnvVar:   os.Getenv(obfuscatorKeyEnvVar),
 		obfuscatorValueEnvVar: os.Getenv(obfuscatorValueEnvVar),
+		bodyAnalysisEnvVar:    os.Getenv(bodyAnalysisEnvVar),
+		disabledRulesEnvVar:   os.Getenv(disabledRulesEnvVar),
 	}
 	for k, _ := range env {
 		if err := os.Unsetenv(k); err != nil {
@@ -280,3 +342,57 @@ func restoreEnv(key, value string) {
 		}
 	}
 }
+
+func TestWithRulesFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	base := writeFile("base.json", `{"version":"2.2","metadata":{"rules_version":"1.0.0"},"rules":[{"id":"r1","name":"base 1"},{"id":"r2","name":"base 2"}]}`)
+	overlay := writeFile("overlay.json", `{"metadata":{"rules_version":"1.0.1"},"rules":[{"id":"r2","name":"overlay 2"},{"id":"r3","name":"overlay 3"}],"exclusions":[{"id":"e1"}]}`)
+	overlay2 := writeFile("overlay2.json", `{"rules":[{"id":"r3","name":"overlay2 3"}]}`)
+	invalid := writeFile("invalid.json", `{"rules":`)
+	noID := writeFile("no-id.json", `{"rules":[{"name":"no id"}]}`)
+
+	t.Run("merge", func(t *testing.T) {
+		cfg := &Config{}
+		WithRulesFromFiles(base, overlay, overlay2)(cfg)
+		require.NoError(t, cfg.rulesErr)
+		require.JSONEq(t, `{
+			"version": "2.2",
+			"metadata": {"rules_version": "1.0.1"},
+			"rules": [{"id":"r1","name":"base 1"},{"id":"r2","name":"overlay 2"},{"id":"r3","name":"overlay2 3"}],
+			"exclusions": [{"id":"e1"}]
+		}`, string(cfg.rules))
+	})
+
+	t.Run("base-only", func(t *testing.T) {
+		cfg := &Config{}
+		WithRulesFromFiles(base)(cfg)
+		require.NoError(t, cfg.rulesErr)
+		buf, err := os.ReadFile(base)
+		require.NoError(t, err)
+		require.Equal(t, buf, cfg.rules)
+	})
+
+	for _, tc := range []struct {
+		name  string
+		paths []string
+		file  string
+	}{
+		{name: "invalid-base", paths: []string{invalid, overlay}, file: invalid},
+		{name: "invalid-overlay", paths: []string{base, overlay, invalid}, file: invalid},
+		{name: "missing-id", paths: []string{base, noID}, file: noID},
+		{name: "missing-file", paths: []string{base, filepath.Join(dir, "missing.json")}, file: filepath.Join(dir, "missing.json")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{rules: []byte("default")}
+			WithRulesFromFiles(tc.paths...)(cfg)
+			require.Error(t, cfg.rulesErr)
+			require.Contains(t, cfg.rulesErr.Error(), tc.file)
+			require.Equal(t, []byte("default"), cfg.rules)
+		})
+	}
+}
@@ -7,6 +7,7 @@
 package instrumentation
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sync"
@@ -33,13 +34,30 @@ type (
 	// used by composition in an Operation to allow said operation to handle security events addition/retrieval.
 	// See httpsec/http.go and grpcsec/grpc.go.
 	SecurityEventsHolder struct {
-		events []json.RawMessage
-		mu     sync.RWMutex
+		events     []json.RawMessage
+		sampledOut bool // the trace isn't force-kept because of the security events
+		mu         sync.RWMutex
 	}
+	// EventEnricher is a function called with the request context when security events were produced for the request,
+	// before they get reported in the service entry span, so that it can enrich them with extra span tags.
+	EventEnricher func(ctx context.Context, span TagSetter, events []json.RawMessage)
 	// ContextKey is used as a key to store operations in the request's context (gRPC/HTTP)
 	ContextKey struct{}
+	// bypassContextKey is used as a key to flag the request's context as bypassing the security monitoring
+	bypassContextKey struct{}
 )
 
+// WithBypass returns a copy of the given context flagged as bypassing the security monitoring of the request.
+func WithBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassContextKey{}, true)
+}
+
+// IsBypassed reports whether the given context was flagged as bypassing the security monitoring with WithBypass.
+func IsBypassed(ctx context.Context) bool {
+	bypassed, _ := ctx.Value(bypassContextKey{}).(bool)
+	return bypassed
+}
+
 // NewTagsHolder returns a new instance of a TagsHolder struct.
 func NewTagsHolder() TagsHolder {
 	return TagsHolder{tags: map[string]interface{}{}}
@@ -79,6 +97,22 @@ func (s *SecurityEventsHolder) ClearEvents() {
 	s.events = s.events[0:0]
 }
 
+// SampleOutEvents flags the security events as sampled out so that they don't force-keep the trace, which is then kept
+// or dropped by the regular trace sampling. Thread safe.
+func (s *SecurityEventsHolder) SampleOutEvents() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sampledOut = true
+}
+
+// KeepEvents reports whether the security events must force-keep the trace, which is the case unless SampleOutEvents
+// was called. Thread safe.
+func (s *SecurityEventsHolder) KeepEvents() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.sampledOut
+}
+
 // SetTags fills the span tags using the key/value pairs found in `tags`
 func SetTags(span TagSetter, tags map[string]interface{}) {
 	for k, v := range tags {
@@ -101,8 +135,9 @@ func SetAppSecEnabledTags(span TagSetter) {
 	span.SetTag("_dd.runtime_family", "go")
 }
 
-// SetEventSpanTags sets the security event span tags into the service entry span.
-func SetEventSpanTags(span TagSetter, events []json.RawMessage) error {
+// SetEventSpanTags sets the security event span tags into the service entry span. The trace is force-kept when keep is
+// true, and left to the regular trace sampling otherwise.
+func SetEventSpanTags(span TagSetter, events []json.RawMessage, keep bool) error {
 	// Set the appsec event span tag
 	val, err := makeEventTagValue(events)
 	if err != nil {
@@ -115,7 +150,9 @@ func SetEventSpanTags(span TagSetter, events []json.RawMessage) error {
 	// Passing any other value than `appsec.SamplerAppSec` has no effect.
 	// Customers should use `span.SetTag(ext.ManualKeep, true)` pattern
 	// to keep the trace, manually.
-	span.SetTag(ext.ManualKeep, samplernames.AppSec)
+	if keep {
+		span.SetTag(ext.ManualKeep, samplernames.AppSec)
+	}
 	span.SetTag("_dd.origin", "appsec")
 	// Set the appsec.event tag needed by the appsec backend
 	span.SetTag("appsec.event", true)
@@ -15,19 +15,20 @@ import (
 )
 
 // SetSecurityEventTags sets the AppSec-specific span tags when a security event
-// occurred into the service entry span.
-func SetSecurityEventTags(span ddtrace.Span, events []json.RawMessage, md map[string][]string) {
-	if err := setSecurityEventTags(span, events, md); err != nil {
+// occurred into the service entry span. The trace is force-kept when keep is
+// true.
+func SetSecurityEventTags(span ddtrace.Span, events []json.RawMessage, keep bool, md map[string][]string) {
+	if err := setSecurityEventTags(span, events, keep, md); err != nil {
 		log.Error("appsec: %v", err)
 	}
 }
 
-func setSecurityEventTags(span ddtrace.Span, events []json.RawMessage, md map[string][]string) error {
-	if err := instrumentation.SetEventSpanTags(span, events); err != nil {
+func setSecurityEventTags(span ddtrace.Span, events []json.RawMessage, keep bool, md map[string][]string) error {
+	if err := instrumentation.SetEventSpanTags(span, events, keep); err != nil {
 		return err
 	}
 
-	for h, v := range httpsec.NormalizeHTTPHeaders(md) {
+	for h, v := range httpsec.NormalizeRequestHeaders(md) {
 		span.SetTag("grpc.metadata."+h, v)
 	}
 
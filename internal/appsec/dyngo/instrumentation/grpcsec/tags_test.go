@@ -93,7 +93,7 @@ func TestSetSecurityEventTags(t *testing.T) {
 			metadataCase := metadataCase
 			t.Run(fmt.Sprintf("%s-%s", eventCase.name, metadataCase.name), func(t *testing.T) {
 				var span MockSpan
-				err := setSecurityEventTags(&span, eventCase.events, metadataCase.md)
+				err := setSecurityEventTags(&span, eventCase.events, true, metadataCase.md)
 				if eventCase.expectedError {
 					require.Error(t, err)
 					return
@@ -113,6 +113,12 @@ func TestSetSecurityEventTags(t *testing.T) {
 
 				require.Equal(t, expectedTags, span.tags)
 				require.False(t, span.finished)
+
+				// sampled out events don't keep the trace
+				var sampledOut MockSpan
+				require.NoError(t, setSecurityEventTags(&sampledOut, eventCase.events, false, metadataCase.md))
+				delete(expectedTags, "manual.keep")
+				require.Equal(t, expectedTags, sampledOut.tags)
 			})
 		}
 	}
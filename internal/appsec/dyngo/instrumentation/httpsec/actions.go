@@ -64,6 +64,25 @@ func newBlockRequestHandler(status int, ct string, payload []byte) http.Handler
 	})
 }
 
+// TagRequestAction is the action that adds a set of tags to the request span without interrupting the request.
+// It allows monitoring suspicious requests that are still allowed to go through.
+type TagRequestAction struct {
+	// tags are the span tags to add to the request span
+	tags map[string]interface{}
+}
+
+func (*TagRequestAction) isAction() {}
+
+// NewTagRequestAction creates, initializes and returns a new TagRequestAction adding the given tags to the request
+// span.
+func NewTagRequestAction(tags map[string]interface{}) TagRequestAction {
+	action := TagRequestAction{tags: make(map[string]interface{}, len(tags))}
+	for k, v := range tags {
+		action.tags[k] = v
+	}
+	return action
+}
+
 // ActionsHandler handles actions registration and their application to operations
 type ActionsHandler struct {
 	mu      sync.RWMutex
@@ -91,6 +110,22 @@ func (h *ActionsHandler) RegisterAction(id string, a Action) {
 	h.actions[id] = a
 }
 
+// ApplyNonBlocking applies the action identified by `id` for the given operation, unless it would interrupt the request
+// flow. It is meant for the actions returned once the handler responded, when the request can no longer be blocked.
+func (h *ActionsHandler) ApplyNonBlocking(id string, op *Operation) {
+	h.mu.RLock()
+	a, ok := h.actions[id]
+	h.mu.RUnlock()
+	if !ok {
+		log.Debug("appsec: ignoring the returned waf action: unknown action id `%s`", id)
+		return
+	}
+	if _, ok := a.(*BlockRequestAction); ok {
+		return
+	}
+	op.AddAction(a)
+}
+
 // Apply applies the action identified by `id` for the given operation
 // Returns true if the applied action will interrupt the request flow (block, redirect, etc...)
 func (h *ActionsHandler) Apply(id string, op *Operation) bool {
@@ -6,12 +6,15 @@
 package httpsec
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation"
 )
 
 func TestNewBlockRequestAction(t *testing.T) {
@@ -153,3 +156,23 @@ func TestNewBlockRequestAction(t *testing.T) {
 		}
 	})
 }
+
+func TestTagRequestAction(t *testing.T) {
+	handler := NewActionsHandler()
+	monitor := NewTagRequestAction(map[string]interface{}{"appsec.monitored": "suspicious-client"})
+	handler.RegisterAction("monitor", &monitor)
+
+	_, op := StartOperation(context.Background(), HandlerOperationArgs{})
+	require.False(t, handler.Apply("monitor", op))
+	require.Nil(t, applyActions(op))
+	require.Equal(t, "suspicious-client", op.Tags()["appsec.monitored"])
+	require.Empty(t, op.Actions())
+
+	// Tags are still applied along with a blocking action
+	_, op = StartOperation(context.Background(), HandlerOperationArgs{})
+	require.True(t, handler.Apply("block", op))
+	require.False(t, handler.Apply("monitor", op))
+	require.NotNil(t, applyActions(op))
+	require.Equal(t, "suspicious-client", op.Tags()["appsec.monitored"])
+	require.Equal(t, true, op.Tags()[instrumentation.BlockedRequestTag])
+}
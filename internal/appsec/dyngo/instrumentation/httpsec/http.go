@@ -51,6 +51,8 @@ type (
 	HandlerOperationRes struct {
 		// Status corresponds to the address `server.response.status`.
 		Status int
+		// Headers corresponds to the address `server.response.headers.no_cookies`.
+		Headers map[string][]string
 	}
 
 	// SDKBodyOperationArgs is the SDK body operation arguments.
@@ -67,6 +69,9 @@ type (
 // This function should not be called when AppSec is disabled in order to
 // get preciser error logs.
 func MonitorParsedBody(ctx context.Context, body interface{}) {
+	if instrumentation.IsBypassed(ctx) {
+		return
+	}
 	if parent := fromContext(ctx); parent != nil {
 		op := StartSDKBodyOperation(parent, SDKBodyOperationArgs{Body: body})
 		op.Finish()
@@ -78,16 +83,24 @@ func MonitorParsedBody(ctx context.Context, body interface{}) {
 // applyActions executes the operation's actions and returns the resulting http handler
 func applyActions(op *Operation) http.Handler {
 	defer op.ClearActions()
+	var handler http.Handler
 	for _, action := range op.Actions() {
 		switch a := action.(type) {
 		case *BlockRequestAction:
-			op.AddTag(instrumentation.BlockedRequestTag, true)
-			return a.handler
+			// Only the first blocking action is applied, but the remaining non-blocking actions still are
+			if handler == nil {
+				op.AddTag(instrumentation.BlockedRequestTag, true)
+				handler = a.handler
+			}
+		case *TagRequestAction:
+			for k, v := range a.tags {
+				op.AddTag(k, v)
+			}
 		default:
 			log.Error("appsec: ignoring security action: unexpected action type %T", a)
 		}
 	}
-	return nil
+	return handler
 }
 
 // WrapHandler wraps the given HTTP handler with the abstract HTTP operation defined by HandlerOperationArgs and
@@ -98,6 +111,12 @@ func WrapHandler(handler http.Handler, span ddtrace.Span, pathParams map[string]
 		ipTags, clientIP := ClientIPTags(r.Header, true, r.RemoteAddr)
 		instrumentation.SetStringTags(span, ipTags)
 
+		// The request is still traced but not monitored when the security monitoring is bypassed
+		if instrumentation.IsBypassed(r.Context()) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
 		args := MakeHandlerOperationArgs(r, clientIP, pathParams)
 		ctx, op := StartOperation(r.Context(), args)
 		r = r.WithContext(ctx)
@@ -111,7 +130,7 @@ func WrapHandler(handler http.Handler, span ddtrace.Span, pathParams map[string]
 				status = mw.Status()
 			}
 
-			events := op.Finish(HandlerOperationRes{Status: status})
+			events := op.Finish(HandlerOperationRes{Status: status, Headers: makeResponseHeaders(w.Header())})
 			if h := applyActions(op); h != nil {
 				h.ServeHTTP(w, r)
 			}
@@ -119,7 +138,10 @@ func WrapHandler(handler http.Handler, span ddtrace.Span, pathParams map[string]
 			if len(events) == 0 {
 				return
 			}
-			SetSecurityEventTags(span, events, args.Headers, w.Header())
+			if enrich := op.EventEnricher(); enrich != nil {
+				enrich(r.Context(), span, events)
+			}
+			SetSecurityEventTags(span, events, op.KeepEvents(), args.Headers, w.Header())
 		}()
 
 		handler.ServeHTTP(w, r)
@@ -152,6 +174,21 @@ func MakeHandlerOperationArgs(r *http.Request, clientIP netip.Addr, pathParams m
 	}
 }
 
+// Return the response headers following the specification of the rule address
+// `server.response.headers.no_cookies`.
+func makeResponseHeaders(h http.Header) map[string][]string {
+	headers := make(map[string][]string, len(h))
+	for k, v := range h {
+		k := strings.ToLower(k)
+		if k == "set-cookie" {
+			// Do not include cookies in the response headers
+			continue
+		}
+		headers[k] = v
+	}
+	return headers
+}
+
 // Return the map of parsed cookies if any and following the specification of
 // the rule address `server.request.cookies`.
 func makeCookies(r *http.Request) map[string][]string {
@@ -175,8 +212,9 @@ type (
 		dyngo.Operation
 		instrumentation.TagsHolder
 		instrumentation.SecurityEventsHolder
-		mu      sync.RWMutex
-		actions []Action
+		mu       sync.RWMutex
+		actions  []Action
+		enricher instrumentation.EventEnricher
 	}
 
 	// SDKBodyOperation type representing an SDK body. It must be created with
@@ -214,6 +252,21 @@ func (op *Operation) Finish(res HandlerOperationRes) []json.RawMessage {
 	return op.Events()
 }
 
+// SetEventEnricher sets the function called with the request context when security events were produced for the
+// request.
+func (op *Operation) SetEventEnricher(fn instrumentation.EventEnricher) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.enricher = fn
+}
+
+// EventEnricher returns the function set by SetEventEnricher, or nil if none.
+func (op *Operation) EventEnricher() instrumentation.EventEnricher {
+	op.mu.RLock()
+	defer op.mu.RUnlock()
+	return op.enricher
+}
+
 // Actions returns the actions linked to the operation
 func (op *Operation) Actions() []Action {
 	op.mu.RLock()
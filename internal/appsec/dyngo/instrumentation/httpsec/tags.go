@@ -10,6 +10,7 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"sync"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
@@ -43,6 +44,9 @@ var (
 	//client IP address. Defined at init-time in the init() function below.
 	monitoredClientIPHeadersCfg []string
 
+	// IP header configured with DD_TRACE_CLIENT_IP_HEADER, if any.
+	clientIPHeader string
+
 	// List of HTTP headers we collect and send.
 	collectedHTTPHeaders = append(defaultIPHeaders,
 		"host",
@@ -56,10 +60,18 @@ var (
 		"accept",
 		"accept-encoding",
 		"accept-language")
+
+	// List of request headers collected into the security events, set by
+	// SetCollectedRequestHeaders, and the maximum total size of their names
+	// and values beyond which they are not collected. Zero means no limit.
+	collectedRequestHeaders        []string
+	maxCollectedRequestHeadersSize int
+	collectedRequestHeadersMu      sync.RWMutex
 )
 
 func init() {
 	if cfg := os.Getenv(envClientIPHeader); cfg != "" {
+		clientIPHeader = cfg
 		// Collect this header value too
 		collectedHTTPHeaders = append(collectedHTTPHeaders, cfg)
 		// Set this IP header as the only one to consider for ClientIP()
@@ -70,14 +82,42 @@ func init() {
 
 	// Ensure the list of headers are sorted for sort.SearchStrings()
 	sort.Strings(collectedHTTPHeaders[:])
+	collectedRequestHeaders = collectedHTTPHeaders
+}
+
+// SetCollectedRequestHeaders sets the allowlist of the request headers
+// collected into the security events, and the maximum total size of their
+// names and values beyond which they are not collected, zero meaning no limit.
+// A nil allowlist restores the default list of collected headers. The client
+// IP header set with DD_TRACE_CLIENT_IP_HEADER is always collected. The
+// response headers are not affected and keep following the default list.
+func SetCollectedRequestHeaders(allowlist []string, maxSize int) {
+	headers := collectedHTTPHeaders
+	if allowlist != nil {
+		headers = make([]string, 0, len(allowlist)+1)
+		for _, h := range allowlist {
+			if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+				headers = append(headers, h)
+			}
+		}
+		if clientIPHeader != "" {
+			headers = append(headers, clientIPHeader)
+		}
+		sort.Strings(headers)
+	}
+	collectedRequestHeadersMu.Lock()
+	defer collectedRequestHeadersMu.Unlock()
+	collectedRequestHeaders = headers
+	maxCollectedRequestHeadersSize = maxSize
 }
 
 // SetSecurityEventTags sets the AppSec-specific span tags when a security event occurred into the service entry span.
-func SetSecurityEventTags(span instrumentation.TagSetter, events []json.RawMessage, headers, respHeaders map[string][]string) {
-	if err := instrumentation.SetEventSpanTags(span, events); err != nil {
+// The trace is force-kept when keep is true.
+func SetSecurityEventTags(span instrumentation.TagSetter, events []json.RawMessage, keep bool, headers, respHeaders map[string][]string) {
+	if err := instrumentation.SetEventSpanTags(span, events, keep); err != nil {
 		log.Error("appsec: unexpected error while creating the appsec event tags: %v", err)
 	}
-	for h, v := range NormalizeHTTPHeaders(headers) {
+	for h, v := range NormalizeRequestHeaders(headers) {
 		span.SetTag("http.request.headers."+h, v)
 	}
 	for h, v := range NormalizeHTTPHeaders(respHeaders) {
@@ -88,13 +128,36 @@ func SetSecurityEventTags(span instrumentation.TagSetter, events []json.RawMessa
 // NormalizeHTTPHeaders returns the HTTP headers following Datadog's
 // normalization format.
 func NormalizeHTTPHeaders(headers map[string][]string) (normalized map[string]string) {
+	return normalizeHeaders(headers, collectedHTTPHeaders)
+}
+
+// NormalizeRequestHeaders returns the request headers collected into the
+// security events, as configured with SetCollectedRequestHeaders, following
+// Datadog's normalization format.
+func NormalizeRequestHeaders(headers map[string][]string) (normalized map[string]string) {
+	collectedRequestHeadersMu.RLock()
+	collected, max := collectedRequestHeaders, maxCollectedRequestHeadersSize
+	collectedRequestHeadersMu.RUnlock()
+	normalized = normalizeHeaders(headers, collected)
+	if max > 0 {
+		capHeadersSize(normalized, max)
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// normalizeHeaders returns the given headers present in the given sorted list
+// of collected headers, following Datadog's normalization format.
+func normalizeHeaders(headers map[string][]string, collected []string) (normalized map[string]string) {
 	if len(headers) == 0 {
 		return nil
 	}
 	normalized = make(map[string]string)
 	for k, v := range headers {
 		k = strings.ToLower(k)
-		if i := sort.SearchStrings(collectedHTTPHeaders[:], k); i < len(collectedHTTPHeaders) && collectedHTTPHeaders[i] == k {
+		if i := sort.SearchStrings(collected, k); i < len(collected) && collected[i] == k {
 			normalized[k] = strings.Join(v, ",")
 		}
 	}
@@ -104,6 +167,26 @@ func NormalizeHTTPHeaders(headers map[string][]string) (normalized map[string]st
 	return normalized
 }
 
+// capHeadersSize removes the headers going over the given maximum total size
+// of header names and values. Headers are considered in alphabetical order so
+// that the resulting set is deterministic.
+func capHeadersSize(headers map[string]string, max int) {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	size := 0
+	for _, k := range keys {
+		n := len(k) + len(headers[k])
+		if size+n > max {
+			delete(headers, k)
+			continue
+		}
+		size += n
+	}
+}
+
 // ClientIPTags returns the resulting Datadog span tags `http.client_ip`
 // containing the client IP and `network.client.ip` containing the remote IP.
 // The tags are present only if a valid ip address has been returned by
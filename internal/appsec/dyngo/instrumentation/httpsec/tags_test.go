@@ -49,3 +49,42 @@ func TestNormalizeHTTPHeaders(t *testing.T) {
 		require.Equal(t, tc.expected, headers)
 	}
 }
+
+func TestSetCollectedRequestHeaders(t *testing.T) {
+	defer SetCollectedRequestHeaders(nil, 0)
+	defer func(header string) { clientIPHeader = header }(clientIPHeader)
+	clientIPHeader = ""
+
+	headers := map[string][]string{
+		"x-forwarded-for": {"1.2.3.4"},
+		"user-agent":      {"curl"},
+		"accept":          {"*/*"},
+	}
+
+	t.Run("default", func(t *testing.T) {
+		SetCollectedRequestHeaders(nil, 0)
+		require.Equal(t, map[string]string{"x-forwarded-for": "1.2.3.4", "user-agent": "curl", "accept": "*/*"}, NormalizeRequestHeaders(headers))
+	})
+
+	t.Run("allowlist", func(t *testing.T) {
+		SetCollectedRequestHeaders([]string{" User-Agent", "accept ", ""}, 0)
+		require.Equal(t, map[string]string{"user-agent": "curl", "accept": "*/*"}, NormalizeRequestHeaders(headers))
+		// the response headers are not affected
+		require.Equal(t, map[string]string{"x-forwarded-for": "1.2.3.4", "user-agent": "curl", "accept": "*/*"}, NormalizeHTTPHeaders(headers))
+	})
+
+	t.Run("client-ip-header", func(t *testing.T) {
+		clientIPHeader = "x-forwarded-for"
+		defer func() { clientIPHeader = "" }()
+		SetCollectedRequestHeaders([]string{"user-agent"}, 0)
+		require.Equal(t, map[string]string{"x-forwarded-for": "1.2.3.4", "user-agent": "curl"}, NormalizeRequestHeaders(headers))
+	})
+
+	t.Run("max-size", func(t *testing.T) {
+		SetCollectedRequestHeaders([]string{"user-agent", "accept", "x-forwarded-for"}, len("accept")+len("*/*")+len("user-agent")+len("curl"))
+		require.Equal(t, map[string]string{"user-agent": "curl", "accept": "*/*"}, NormalizeRequestHeaders(headers))
+
+		SetCollectedRequestHeaders([]string{"user-agent", "accept", "x-forwarded-for"}, 1)
+		require.Nil(t, NormalizeRequestHeaders(headers))
+	})
+}
@@ -71,6 +71,9 @@ func (f OnUserIDOperationStart) Call(op dyngo.Operation, v interface{}) {
 // A call to the WAF is made to check the user ID and an error is returned if the
 // user should be blocked. The return value is nil otherwise.
 func MonitorUser(ctx context.Context, userID string) error {
+	if instrumentation.IsBypassed(ctx) {
+		return nil
+	}
 	if parent, ok := ctx.Value(instrumentation.ContextKey{}).(dyngo.Operation); ok {
 		return ExecuteUserIDOperation(parent, UserIDOperationArgs{UserID: userID})
 	}
@@ -0,0 +1,52 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023 Datadog, Inc.
+
+package appsec
+
+import "time"
+
+type (
+	// SecurityEvent is a security event detected by the WAF in a request, as reported to the event sink set by
+	// WithEventSink. A security event is reported per security rule matching the request.
+	SecurityEvent struct {
+		// RuleID is the ID of the security rule that matched, such as "crs-941-110".
+		RuleID string
+		// RuleName is the name of the security rule that matched.
+		RuleName string
+		// RuleType is the type of attack the security rule detects, such as "xss" or "sql_injection".
+		RuleType string
+		// Matches are the request values that matched the security rule.
+		Matches []SecurityEventMatch
+		// Timestamp is the time at which the security event was detected.
+		Timestamp time.Time
+		// Request is the metadata of the request the security event was detected in.
+		Request SecurityEventRequest
+	}
+
+	// SecurityEventMatch is a request value that matched a security rule.
+	SecurityEventMatch struct {
+		// Address is the WAF address of the matched value, such as "server.request.query".
+		Address string
+		// KeyPath is the path of the matched value in the address value, such as the query parameter name.
+		KeyPath []string
+		// Highlight is the part of the matched value that triggered the security rule, obfuscated according to the
+		// obfuscator configuration.
+		Highlight []string
+	}
+
+	// SecurityEventRequest is the metadata of the request a security event was detected in.
+	SecurityEventRequest struct {
+		// URI is the raw request URI of HTTP requests, empty for gRPC requests.
+		URI string
+		// Host is the Host header of HTTP requests, or the :authority metadata of gRPC requests.
+		Host string
+		// UserAgent is the User-Agent header of HTTP requests, or the user-agent metadata of gRPC requests.
+		UserAgent string
+		// ClientIP is the client IP address of the request, empty when unknown.
+		ClientIP string
+		// Blocked reports whether the request was blocked by a security rule.
+		Blocked bool
+	}
+)
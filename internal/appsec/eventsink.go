@@ -0,0 +1,180 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023 Datadog, Inc.
+
+//go:build appsec
+// +build appsec
+
+package appsec
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+)
+
+// eventSinkQueueSize is the maximum number of requests whose security events are waiting to be reported to the event
+// sink, beyond which they are dropped.
+const eventSinkQueueSize = 256
+
+// eventSinkStopTimeout is the maximum duration AppSec waits for the event sink to report the pending security events
+// when stopped, beyond which the remaining ones are dropped so that the shutdown of the service isn't delayed.
+var eventSinkStopTimeout = 5 * time.Second
+
+// eventSink reports the security events to the event sink function out of the request path, in a dedicated goroutine.
+// The security events are dropped when the sink function is too slow to keep up with them.
+type eventSink struct {
+	fn      func(event SecurityEvent)
+	queue   chan sinkEntry
+	dropped uint64 // number of dropped security events, accessed atomically
+	done    chan struct{}
+	abort   chan struct{} // closed when the pending security events must be dropped
+
+	// mu protects the queue from being closed while the in-flight requests, still monitored once the sink is
+	// stopped, report their security events.
+	mu      sync.RWMutex
+	stopped bool
+}
+
+// sinkEntry holds the security events of a request waiting to be reported to the event sink.
+type sinkEntry struct {
+	events    []json.RawMessage
+	req       SecurityEventRequest
+	timestamp time.Time
+}
+
+// newEventSink returns a started event sink calling fn with the reported security events.
+func newEventSink(fn func(event SecurityEvent)) *eventSink {
+	s := &eventSink{
+		fn:    fn,
+		queue: make(chan sinkEntry, eventSinkQueueSize),
+		done:  make(chan struct{}),
+		abort: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// report queues the given security events of a request to report them to the event sink, without blocking. The events
+// are dropped when the queue is full, or when the sink is stopped.
+func (s *eventSink) report(events []json.RawMessage, req SecurityEventRequest) {
+	if len(events) == 0 {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.stopped {
+		atomic.AddUint64(&s.dropped, uint64(len(events)))
+		return
+	}
+	// copy the events slice which is still owned by the operation
+	events = append([]json.RawMessage(nil), events...)
+	select {
+	case s.queue <- sinkEntry{events: events, req: req, timestamp: time.Now()}:
+	default:
+		if atomic.AddUint64(&s.dropped, uint64(len(events))) == uint64(len(events)) {
+			log.Warn("appsec: the event sink is too slow: dropping security events")
+		}
+	}
+}
+
+// droppedEvents returns the number of security events dropped because the event sink was too slow.
+func (s *eventSink) droppedEvents() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// stop stops the event sink once the queued security events are reported, waiting for them at most for the given
+// timeout. The security events still queued past the timeout are dropped, without waiting for the sink function to
+// return from the one being reported.
+func (s *eventSink) stop(timeout time.Duration) {
+	s.mu.Lock()
+	s.stopped = true
+	close(s.queue)
+	s.mu.Unlock()
+	select {
+	case <-s.done:
+	case <-time.After(timeout):
+		close(s.abort)
+		log.Warn("appsec: the event sink didn't report the pending security events within %s: dropping them", timeout)
+		return
+	}
+	if dropped := s.droppedEvents(); dropped > 0 {
+		log.Warn("appsec: %d security events were dropped by the event sink", dropped)
+	}
+}
+
+func (s *eventSink) run() {
+	defer close(s.done)
+	for e := range s.queue {
+		select {
+		case <-s.abort:
+			atomic.AddUint64(&s.dropped, uint64(len(e.events)))
+			continue
+		default:
+		}
+		for _, raw := range e.events {
+			events, err := parseSecurityEvents(raw)
+			if err != nil {
+				log.Error("appsec: could not parse the security events for the event sink: %v", err)
+				continue
+			}
+			for _, event := range events {
+				event.Timestamp = e.timestamp
+				event.Request = e.req
+				s.fn(event)
+			}
+		}
+	}
+}
+
+// wafMatch is a security rule match as returned by the WAF.
+type wafMatch struct {
+	Rule struct {
+		ID   string            `json:"id"`
+		Name string            `json:"name"`
+		Tags map[string]string `json:"tags"`
+	} `json:"rule"`
+	RuleMatches []struct {
+		Parameters []struct {
+			Address   string        `json:"address"`
+			KeyPath   []interface{} `json:"key_path"`
+			Highlight []string      `json:"highlight"`
+		} `json:"parameters"`
+	} `json:"rule_matches"`
+}
+
+// parseSecurityEvents returns the security events of the given WAF matches, one per matching security rule.
+func parseSecurityEvents(raw json.RawMessage) ([]SecurityEvent, error) {
+	var matches []wafMatch
+	if err := json.Unmarshal(raw, &matches); err != nil {
+		return nil, err
+	}
+	events := make([]SecurityEvent, 0, len(matches))
+	for _, m := range matches {
+		event := SecurityEvent{
+			RuleID:   m.Rule.ID,
+			RuleName: m.Rule.Name,
+			RuleType: m.Rule.Tags["type"],
+		}
+		for _, rm := range m.RuleMatches {
+			for _, p := range rm.Parameters {
+				keyPath := make([]string, len(p.KeyPath))
+				for i, k := range p.KeyPath {
+					keyPath[i] = fmt.Sprint(k)
+				}
+				event.Matches = append(event.Matches, SecurityEventMatch{
+					Address:   p.Address,
+					KeyPath:   keyPath,
+					Highlight: p.Highlight,
+				})
+			}
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
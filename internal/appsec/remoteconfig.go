@@ -206,6 +206,32 @@ func (a *appsec) registerRCCapability(c remoteconfig.Capability) error {
 	return nil
 }
 
+func (a *appsec) unregisterRCProduct(product string) error {
+	if a.rc == nil {
+		return fmt.Errorf("no valid remote configuration client")
+	}
+	for i, p := range a.rc.Products {
+		if p == product {
+			a.rc.Products = append(a.rc.Products[:i], a.rc.Products[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (a *appsec) unregisterRCCapability(c remoteconfig.Capability) error {
+	if a.rc == nil {
+		return fmt.Errorf("no valid remote configuration client")
+	}
+	for i, cap := range a.rc.Capabilities {
+		if cap == c {
+			a.rc.Capabilities = append(a.rc.Capabilities[:i], a.rc.Capabilities[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
 func (a *appsec) registerRCCallback(c remoteconfig.Callback, product string) error {
 	if a.rc == nil {
 		return fmt.Errorf("no valid remote configuration client")
@@ -215,6 +241,14 @@ func (a *appsec) registerRCCallback(c remoteconfig.Callback, product string) err
 
 }
 
+func (a *appsec) unregisterRCCallbacks(product string) error {
+	if a.rc == nil {
+		return fmt.Errorf("no valid remote configuration client")
+	}
+	a.rc.UnregisterCallbacks(product)
+	return nil
+}
+
 func (a *appsec) enableRemoteActivation() error {
 	if a.rc == nil {
 		return fmt.Errorf("no valid remote configuration client")
@@ -242,3 +276,16 @@ func (a *appsec) enableRCBlocking(handle wafHandleWrapper) error {
 	a.registerRCCallback(handle.asmDataCallback, rc.ProductASMData)
 	return nil
 }
+
+// disableRCBlocking reverts enableRCBlocking so that the callback of a released WAF handle doesn't remain registered
+// once AppSec is stopped, and so that a later restart doesn't register it twice.
+func (a *appsec) disableRCBlocking() error {
+	if a.rc == nil {
+		return fmt.Errorf("no valid remote configuration client")
+	}
+	a.unregisterRCProduct(rc.ProductASMData)
+	a.unregisterRCCapability(remoteconfig.ASMIPBlocking)
+	a.unregisterRCCapability(remoteconfig.ASMUserBlocking)
+	a.unregisterRCCallbacks(rc.ProductASMData)
+	return nil
+}
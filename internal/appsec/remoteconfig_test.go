@@ -9,13 +9,16 @@
 package appsec
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	rc "github.com/DataDog/datadog-agent/pkg/remoteconfig/state"
 	waf "github.com/DataDog/go-libddwaf"
 	"github.com/stretchr/testify/require"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/remoteconfig"
 )
 
@@ -371,3 +374,100 @@ func TestRemoteActivationScenarios(t *testing.T) {
 		require.False(t, Enabled())
 	})
 }
+
+func TestRCRegistrationRestart(t *testing.T) {
+	if waf.Health() != nil {
+		t.Skip("WAF cannot be used")
+	}
+	cfg, err := newConfig()
+	require.NoError(t, err)
+	rcCfg := remoteconfig.DefaultClientConfig()
+	cfg.rc = &rcCfg
+	a := newAppSec(cfg)
+	require.NotNil(t, a.rc)
+	require.NoError(t, a.enableRemoteActivation())
+
+	// Start and stop appsec several times, as ASM_FEATURES updates would do, and check that the blocking
+	// products and capabilities are registered only while appsec is started.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, a.start())
+		require.ElementsMatch(t, []string{rc.ProductASMFeatures, rc.ProductASMData}, a.rc.Products)
+		require.ElementsMatch(t, []remoteconfig.Capability{
+			remoteconfig.ASMActivation,
+			remoteconfig.ASMIPBlocking,
+			remoteconfig.ASMUserBlocking,
+		}, a.rc.Capabilities)
+
+		a.stop()
+		require.ElementsMatch(t, []string{rc.ProductASMFeatures}, a.rc.Products)
+		require.ElementsMatch(t, []remoteconfig.Capability{remoteconfig.ASMActivation}, a.rc.Capabilities)
+	}
+}
+
+// BenchmarkRulesDataUpdate compares the cost of updating the rules data of the current WAF handle, as done by the
+// ASM_DATA remote config callback, with recreating a whole WAF handle holding the same rules data.
+func BenchmarkRulesDataUpdate(b *testing.B) {
+	if waf.Health() != nil {
+		b.Skip("WAF cannot be used")
+	}
+	cfg, err := newConfig()
+	require.NoError(b, err)
+
+	for _, nbIPs := range []int{10, 1000} {
+		ips := make([]rc.ASMDataRuleDataEntry, nbIPs)
+		for i := range ips {
+			ips[i] = rc.ASMDataRuleDataEntry{Value: fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)}
+		}
+		rulesData := []rc.ASMDataRuleData{{ID: "blocked_ips", Type: "ip_with_expiration", Data: ips}}
+
+		b.Run(fmt.Sprintf("full-swap/%d-ips", nbIPs), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				handle, err := waf.NewHandle(cfg.rules, cfg.obfuscator.KeyRegex, cfg.obfuscator.ValueRegex)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := handle.UpdateRulesData(rulesData); err != nil {
+					b.Fatal(err)
+				}
+				handle.Close()
+			}
+		})
+
+		b.Run(fmt.Sprintf("data-only/%d-ips", nbIPs), func(b *testing.B) {
+			handle, err := waf.NewHandle(cfg.rules, cfg.obfuscator.KeyRegex, cfg.obfuscator.ValueRegex)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer handle.Close()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				if err := handle.UpdateRulesData(rulesData); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestBlockingWithoutRC(t *testing.T) {
+	if waf.Health() != nil {
+		t.Skip("WAF cannot be used")
+	}
+	t.Setenv(rulesEnvVar, "testdata/blocking.json")
+	cfg, err := newConfig()
+	require.NoError(t, err)
+	a := newAppSec(cfg)
+	require.Nil(t, a.rc)
+
+	tl := new(log.RecordLogger)
+	defer log.UseLogger(tl)()
+	require.NoError(t, a.start())
+	defer a.stop()
+	require.True(t, a.started)
+
+	var warned bool
+	for _, l := range tl.Logs() {
+		warned = warned || strings.Contains(l, "WARN: appsec: Remote config: unavailable")
+	}
+	require.True(t, warned, "a warning should be logged when blocking can't be configured remotely")
+}
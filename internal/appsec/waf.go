@@ -12,13 +12,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand"
 	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/grpcsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/httpsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/sharedsec"
@@ -35,6 +38,9 @@ const (
 	eventRulesFailedTag  = "_dd.appsec.event_rules.error_count"
 	wafDurationTag       = "_dd.appsec.waf.duration"
 	wafDurationExtTag    = "_dd.appsec.waf.duration_ext"
+	wafDurationMetric    = "datadog.appsec.waf.duration"
+	wafErrorMetric       = "datadog.appsec.waf.error"
+	wafErrorTag          = "_dd.appsec.waf.error"
 	wafTimeoutTag        = "_dd.appsec.waf.timeouts"
 	wafVersionTag        = "_dd.appsec.waf.version"
 )
@@ -46,8 +52,21 @@ func (a *appsec) registerWAF() (unreg dyngo.UnregisterFunc, err error) {
 		return nil, err
 	}
 
+	rules := a.cfg.rules
+	if a.cfg.minimal {
+		if rules, err = blockingRules(rules); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(a.cfg.disabledRules) > 0 {
+		if rules, err = disableRules(rules, a.cfg.disabledRules); err != nil {
+			return nil, err
+		}
+	}
+
 	// Instantiate the WAF
-	waf, err := waf.NewHandle(a.cfg.rules, a.cfg.obfuscator.KeyRegex, a.cfg.obfuscator.ValueRegex)
+	waf, err := waf.NewHandle(rules, a.cfg.obfuscator.KeyRegex, a.cfg.obfuscator.ValueRegex)
 	if err != nil {
 		return nil, err
 	}
@@ -71,45 +90,93 @@ func (a *appsec) registerWAF() (unreg dyngo.UnregisterFunc, err error) {
 		log.Debug("appsec: the addresses present in the rule are partially supported: not supported=%v", notSupported)
 	}
 
+	if !a.cfg.bodyAnalysis || a.cfg.minimal {
+		httpAddresses = removeAddress(httpAddresses, serverRequestBodyAddr)
+	}
+	if a.cfg.minimal {
+		httpAddresses = removeAddress(httpAddresses, serverResponseStatusAddr)
+		httpAddresses = removeAddress(httpAddresses, serverResponseHeadersNoCookiesAddr)
+	}
+
+	var sink *eventSink
+	if a.cfg.eventSink != nil {
+		sink = newEventSink(a.cfg.eventSink)
+		defer func() {
+			if err != nil {
+				sink.stop(eventSinkStopTimeout)
+			}
+		}()
+	}
+
 	// Register the WAF event listener
 	var unregisterHTTP, unregisterGRPC dyngo.UnregisterFunc
 	if len(httpAddresses) > 0 {
 		log.Debug("appsec: registering http waf listening to addresses %v", httpAddresses)
-		unregisterHTTP = dyngo.Register(newHTTPWAFEventListener(waf, httpAddresses, a.cfg.wafTimeout, a.limiter))
+		actionHandler := httpsec.NewActionsHandler()
+		if err := registerMonitorActions(actionHandler, rules); err != nil {
+			return nil, err
+		}
+		unregisterHTTP = dyngo.Register(newHTTPWAFEventListener(waf, httpAddresses, a.cfg.wafTimeout, a.limiter, a.cfg.statsd, a.cfg.eventEnricher, a.cfg.wafSampleRate, a.cfg.monitoringEventKeepRate, a.cfg.wafErrorBehavior, sink, actionHandler))
 	}
 	if len(grpcAddresses) > 0 {
 		log.Debug("appsec: registering grpc waf listening to addresses %v", grpcAddresses)
-		unregisterGRPC = dyngo.Register(newGRPCWAFEventListener(waf, grpcAddresses, a.cfg.wafTimeout, a.limiter))
+		unregisterGRPC = dyngo.Register(newGRPCWAFEventListener(waf, grpcAddresses, a.cfg.wafTimeout, a.limiter, a.cfg.statsd, a.cfg.wafSampleRate, a.cfg.monitoringEventKeepRate, a.cfg.wafErrorBehavior, sink))
 	}
 
-	if err := a.enableRCBlocking(wafHandleWrapper{waf}); err != nil {
+	if a.rc == nil {
+		// Blocking keeps working with the blocking rules and rules data of the local security rules, but the IP and
+		// user blocklists can only be updated through remote config.
+		log.Warn("appsec: Remote config: unavailable, the blocked IP addresses and user IDs won't be updated remotely. Only the blocking rules and rules data of the security rules in use (cf. %s) are enforced.", rulesEnvVar)
+	} else if err := a.enableRCBlocking(wafHandleWrapper{waf}); err != nil {
 		log.Error("appsec: Remote config: cannot enable blocking, rules data won't be updated: %v", err)
 	}
 
+	addresses := append([]string(nil), ruleAddresses...)
+	sort.Strings(addresses)
+	a.addresses.Store(addresses)
+	rulesInfo := waf.RulesetInfo()
+	a.setRulesInfo(&rulesInfo)
+
 	// Return an unregistration function that will also release the WAF instance.
 	return func() {
 		defer waf.Close()
+		a.addresses.Store([]string(nil))
+		a.setRulesInfo(nil)
+		if a.rc != nil {
+			a.disableRCBlocking()
+		}
 		if unregisterHTTP != nil {
 			unregisterHTTP()
 		}
 		if unregisterGRPC != nil {
 			unregisterGRPC()
 		}
+		if sink != nil {
+			sink.stop(eventSinkStopTimeout)
+		}
 	}, nil
 }
 
 // newWAFEventListener returns the WAF event listener to register in order to enable it.
-func newHTTPWAFEventListener(handle *waf.Handle, addresses []string, timeout time.Duration, limiter Limiter) dyngo.EventListener {
+func newHTTPWAFEventListener(handle *waf.Handle, addresses []string, timeout time.Duration, limiter Limiter, statsd StatsdClient, enricher instrumentation.EventEnricher, sampleRate, eventKeepRate float64, errBehavior WAFErrorBehavior, sink *eventSink, actionHandler *httpsec.ActionsHandler) dyngo.EventListener {
 	var monitorRulesOnce sync.Once // per instantiation
-	actionHandler := httpsec.NewActionsHandler()
 
 	return httpsec.OnHandlerOperationStart(func(op *httpsec.Operation, args httpsec.HandlerOperationArgs) {
-		var body interface{}
+		if !sampleWAF(op, sampleRate) {
+			return
+		}
+		var (
+			body    interface{}
+			blocked bool // whether a security event blocked the request
+		)
 		wafCtx := waf.NewContext(handle)
 		if wafCtx == nil {
 			// The WAF event listener got concurrently released
 			return
 		}
+		if enricher != nil {
+			op.SetEventEnricher(enricher)
+		}
 
 		// OnUserIDOperationStart happens when appsec.SetUser() is called. We run the WAF and apply actions to
 		// see if the associated user should be blocked. Since we don't control the execution flow in this case
@@ -121,11 +188,16 @@ func newHTTPWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 					values[userIDAddr] = args.UserID
 				}
 			}
-			matches, actionIds := runWAF(wafCtx, values, timeout)
+			matches, actionIds, err := runWAF(wafCtx, values, timeout)
+			if err != nil && handleWAFError(op, statsd, errBehavior, err) && actionHandler.Apply(blockActionID, op) {
+				operation.Error = sharedsec.NewUserMonitoringError("Request blocked")
+				blocked = true
+			}
 			if len(matches) > 0 {
 				for _, id := range actionIds {
 					if actionHandler.Apply(id, op) {
 						operation.Error = sharedsec.NewUserMonitoringError("Request blocked")
+						blocked = true
 					}
 				}
 				op.AddSecurityEvents(matches)
@@ -144,18 +216,24 @@ func newHTTPWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 		}
 		// TODO: suspicious request blocking by moving here all the addresses available when the request begins
 
-		matches, actionIds := runWAF(wafCtx, values, timeout)
+		matches, actionIds, err := runWAF(wafCtx, values, timeout)
+		interrupt := false
+		if err != nil && handleWAFError(op, statsd, errBehavior, err) {
+			interrupt = actionHandler.Apply(blockActionID, op)
+		}
 		if len(matches) > 0 {
-			interrupt := false
 			for _, id := range actionIds {
 				interrupt = actionHandler.Apply(id, op) || interrupt
 			}
 			op.AddSecurityEvents(matches)
 			log.Debug("appsec: WAF detected an attack before executing the request")
-			if interrupt {
-				wafCtx.Close()
-				return
+		}
+		if interrupt {
+			wafCtx.Close()
+			if sink != nil {
+				sink.report(op.Events(), httpEventRequest(args, true))
 			}
+			return
 		}
 
 		op.On(httpsec.OnSDKBodyOperationStart(func(op *httpsec.SDKBodyOperation, args httpsec.SDKBodyOperationArgs) {
@@ -194,16 +272,30 @@ func newHTTPWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 					}
 				case serverResponseStatusAddr:
 					values[serverResponseStatusAddr] = res.Status
+				case serverResponseHeadersNoCookiesAddr:
+					if headers := res.Headers; headers != nil {
+						values[serverResponseHeadersNoCookiesAddr] = headers
+					}
+				}
+			}
+			// Run the WAF, ignoring the returned blocking actions - if any - since blocking after the request handler's
+			// response is not possible. Response-based detections are therefore only reported as security events,
+			// along with the tags of their monitor actions.
+			matches, actionIds, err := runWAF(wafCtx, values, timeout)
+			if err != nil {
+				handleWAFError(op, statsd, errBehavior, err)
+			}
+			if len(matches) > 0 {
+				for _, id := range actionIds {
+					actionHandler.ApplyNonBlocking(id, op)
 				}
 			}
-			// Run the WAF, ignoring the returned actions - if any - since blocking after the request handler's
-			// response is not supported at the moment.
-			matches, _ := runWAF(wafCtx, values, timeout)
 
 			// Add WAF metrics.
 			rInfo := handle.RulesetInfo()
 			overallRuntimeNs, internalRuntimeNs := wafCtx.TotalRuntime()
 			addWAFMonitoringTags(op, rInfo.Version, overallRuntimeNs, internalRuntimeNs, wafCtx.TotalTimeouts())
+			reportWAFDuration(statsd, overallRuntimeNs, len(matches) > 0 || len(op.Events()) > 0)
 
 			// Add the following metrics once per instantiation of a WAF handle
 			monitorRulesOnce.Do(func() {
@@ -212,12 +304,15 @@ func newHTTPWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 			})
 
 			// Log the attacks if any
-			if len(matches) == 0 {
-				return
+			if len(matches) > 0 {
+				log.Debug("appsec: attack detected by the waf")
+				if limiter.Allow() {
+					op.AddSecurityEvents(matches)
+				}
 			}
-			log.Debug("appsec: attack detected by the waf")
-			if limiter.Allow() {
-				op.AddSecurityEvents(matches)
+			sampleMonitoringEvents(op, blocked, eventKeepRate)
+			if sink != nil {
+				sink.report(op.Events(), httpEventRequest(args, blocked))
 			}
 		}))
 	})
@@ -225,11 +320,14 @@ func newHTTPWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 
 // newGRPCWAFEventListener returns the WAF event listener to register in order
 // to enable it.
-func newGRPCWAFEventListener(handle *waf.Handle, addresses []string, timeout time.Duration, limiter Limiter) dyngo.EventListener {
+func newGRPCWAFEventListener(handle *waf.Handle, addresses []string, timeout time.Duration, limiter Limiter, statsd StatsdClient, sampleRate, eventKeepRate float64, errBehavior WAFErrorBehavior, sink *eventSink) dyngo.EventListener {
 	var monitorRulesOnce sync.Once // per instantiation
 	actionHandler := grpcsec.NewActionsHandler()
 
 	return grpcsec.OnHandlerOperationStart(func(op *grpcsec.HandlerOperation, handlerArgs grpcsec.HandlerOperationArgs) {
+		if !sampleWAF(op, sampleRate) {
+			return
+		}
 		// Limit the maximum number of security events, as a streaming RPC could
 		// receive unlimited number of messages where we could find security events
 		const maxWAFEventsPerRequest = 10
@@ -240,8 +338,9 @@ func newGRPCWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 			internalRuntimeNs waf.AtomicU64
 			nbTimeouts        waf.AtomicU64
 
-			events []json.RawMessage
-			mu     sync.Mutex // events mutex
+			events  []json.RawMessage
+			mu      sync.Mutex // events mutex
+			blocked bool       // whether a security event blocked the request
 		)
 
 		wafCtx := waf.NewContext(handle)
@@ -260,10 +359,14 @@ func newGRPCWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 					values[userIDAddr] = args.UserID
 				}
 			}
-			matches, actionIds := runWAF(wafCtx, values, timeout)
+			matches, actionIds, err := runWAF(wafCtx, values, timeout)
+			if err != nil && handleWAFError(op, statsd, errBehavior, err) {
+				blocked = actionHandler.Apply(blockActionID, op) || blocked
+				operation.Error = op.Error
+			}
 			if len(matches) > 0 {
 				for _, id := range actionIds {
-					actionHandler.Apply(id, op)
+					blocked = actionHandler.Apply(id, op) || blocked
 				}
 				operation.Error = op.Error
 				op.AddSecurityEvents(matches)
@@ -279,18 +382,24 @@ func newGRPCWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 			}
 		}
 
-		matches, actionIds := runWAF(wafCtx, values, timeout)
+		matches, actionIds, err := runWAF(wafCtx, values, timeout)
+		interrupt := false
+		if err != nil && handleWAFError(op, statsd, errBehavior, err) {
+			interrupt = actionHandler.Apply(blockActionID, op)
+		}
 		if len(matches) > 0 {
-			interrupt := false
 			for _, id := range actionIds {
 				interrupt = actionHandler.Apply(id, op) || interrupt
 			}
 			op.AddSecurityEvents(matches)
 			log.Debug("appsec: WAF detected an attack before executing the request")
-			if interrupt {
-				wafCtx.Close()
-				return
+		}
+		if interrupt {
+			wafCtx.Close()
+			if sink != nil {
+				sink.report(op.Events(), grpcEventRequest(handlerArgs, true))
 			}
+			return
 		}
 
 		op.On(grpcsec.OnReceiveOperationFinish(func(_ grpcsec.ReceiveOperation, res grpcsec.ReceiveOperationRes) {
@@ -324,7 +433,10 @@ func newGRPCWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 			}
 			// Run the WAF, ignoring the returned actions - if any - since blocking after the request handler's
 			// response is not supported at the moment.
-			event, _ := runWAF(wafCtx, values, timeout)
+			event, _, err := runWAF(wafCtx, values, timeout)
+			if err != nil {
+				handleWAFError(op, statsd, errBehavior, err)
+			}
 
 			// WAF run durations are WAF context bound. As of now we need to keep track of those externally since
 			// we use a new WAF context for each callback. When we are able to re-use the same WAF context across
@@ -348,6 +460,7 @@ func newGRPCWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 			defer wafCtx.Close()
 			rInfo := handle.RulesetInfo()
 			addWAFMonitoringTags(op, rInfo.Version, overallRuntimeNs.Load(), internalRuntimeNs.Load(), nbTimeouts.Load())
+			reportWAFDuration(statsd, overallRuntimeNs.Load(), len(events) > 0)
 
 			// Log the following metrics once per instantiation of a WAF handle
 			monitorRulesOnce.Do(func() {
@@ -359,34 +472,61 @@ func newGRPCWAFEventListener(handle *waf.Handle, addresses []string, timeout tim
 			if len(events) > 0 && limiter.Allow() {
 				op.AddSecurityEvents(events...)
 			}
+			sampleMonitoringEvents(op, blocked, eventKeepRate)
+			if sink != nil {
+				sink.report(op.Events(), grpcEventRequest(handlerArgs, blocked))
+			}
 		}))
 	})
 }
 
-func runWAF(wafCtx *waf.Context, values map[string]interface{}, timeout time.Duration) ([]byte, []string) {
-	matches, actions, err := wafCtx.Run(values, timeout)
+// blockActionID is the ID of the default blocking action registered by the action handlers.
+const blockActionID = "block"
+
+// wafRun runs the WAF context. It is replaced by the tests to simulate WAF errors.
+var wafRun = (*waf.Context).Run
+
+// runWAF runs the WAF context with the given values. It returns the unexpected WAF errors, while WAF timeouts are
+// only logged.
+func runWAF(wafCtx *waf.Context, values map[string]interface{}, timeout time.Duration) ([]byte, []string, error) {
+	matches, actions, err := wafRun(wafCtx, values, timeout)
 	if err != nil {
 		if err == waf.ErrTimeout {
 			log.Debug("appsec: waf timeout value of %s reached", timeout)
 		} else {
 			log.Error("appsec: unexpected waf error: %v", err)
-			return nil, nil
+			return nil, nil, err
+		}
+	}
+	return matches, actions, nil
+}
+
+// handleWAFError records the given unexpected WAF error in the operation tags and metrics, and reports whether the
+// request should be blocked according to the WAF error behavior.
+func handleWAFError(th tagsHolder, statsd StatsdClient, behavior WAFErrorBehavior, err error) (block bool) {
+	th.AddTag(wafErrorTag, err.Error())
+	if c, ok := statsd.(interface {
+		Incr(name string, tags []string, rate float64) error
+	}); ok {
+		if err := c.Incr(wafErrorMetric, []string{"behavior:" + behavior.String()}, 1); err != nil {
+			log.Debug("appsec: could not report the WAF error metric: %v", err)
 		}
 	}
-	return matches, actions
+	return behavior == WAFFailClosed
 }
 
 // HTTP rule addresses currently supported by the WAF
 const (
-	serverRequestRawURIAddr           = "server.request.uri.raw"
-	serverRequestHeadersNoCookiesAddr = "server.request.headers.no_cookies"
-	serverRequestCookiesAddr          = "server.request.cookies"
-	serverRequestQueryAddr            = "server.request.query"
-	serverRequestPathParamsAddr       = "server.request.path_params"
-	serverRequestBodyAddr             = "server.request.body"
-	serverResponseStatusAddr          = "server.response.status"
-	httpClientIPAddr                  = "http.client_ip"
-	userIDAddr                        = "usr.id"
+	serverRequestRawURIAddr            = "server.request.uri.raw"
+	serverRequestHeadersNoCookiesAddr  = "server.request.headers.no_cookies"
+	serverRequestCookiesAddr           = "server.request.cookies"
+	serverRequestQueryAddr             = "server.request.query"
+	serverRequestPathParamsAddr        = "server.request.path_params"
+	serverRequestBodyAddr              = "server.request.body"
+	serverResponseStatusAddr           = "server.response.status"
+	serverResponseHeadersNoCookiesAddr = "server.response.headers.no_cookies"
+	httpClientIPAddr                   = "http.client_ip"
+	userIDAddr                         = "usr.id"
 )
 
 // List of HTTP rule addresses currently supported by the WAF
@@ -398,6 +538,7 @@ var httpAddresses = []string{
 	serverRequestPathParamsAddr,
 	serverRequestBodyAddr,
 	serverResponseStatusAddr,
+	serverResponseHeadersNoCookiesAddr,
 	httpClientIPAddr,
 	userIDAddr,
 }
@@ -443,10 +584,227 @@ func supportedAddresses(ruleAddresses []string) (supportedHTTP, supportedGRPC, n
 	return
 }
 
+// blockingActionTypes are the types of the security rules actions interrupting the request.
+var blockingActionTypes = map[string]bool{"block_request": true, "redirect_request": true}
+
+// blockingRules returns the given security rules without the rules having no blocking action. The actions referenced by
+// the on_match of the rules are looked up in the ruleset actions, so that the rules only having monitor actions are
+// removed, while the default "block" action is blocking unless the ruleset redefines it.
+func blockingRules(rules []byte) ([]byte, error) {
+	var ruleset map[string]json.RawMessage
+	if err := json.Unmarshal(rules, &ruleset); err != nil {
+		return nil, fmt.Errorf("could not parse the security rules: %v", err)
+	}
+	var all []json.RawMessage
+	if err := json.Unmarshal(ruleset["rules"], &all); err != nil {
+		return nil, fmt.Errorf("could not parse the security rules: %v", err)
+	}
+	blockingActions := map[string]bool{blockActionID: true}
+	if raw, ok := ruleset["actions"]; ok {
+		var actions []struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &actions); err != nil {
+			return nil, fmt.Errorf("could not parse the security rules actions: %v", err)
+		}
+		for _, a := range actions {
+			blockingActions[a.ID] = blockingActionTypes[a.Type]
+		}
+	}
+	blocking := make([]json.RawMessage, 0, len(all))
+	for _, raw := range all {
+		var rule struct {
+			OnMatch []string `json:"on_match"`
+		}
+		if err := json.Unmarshal(raw, &rule); err != nil {
+			return nil, fmt.Errorf("could not parse the security rules: %v", err)
+		}
+		for _, id := range rule.OnMatch {
+			if blockingActions[id] {
+				blocking = append(blocking, raw)
+				break
+			}
+		}
+	}
+	if len(blocking) == 0 {
+		return nil, errors.New("no blocking rules found in the security rules")
+	}
+	filtered, err := json.Marshal(blocking)
+	if err != nil {
+		return nil, err
+	}
+	ruleset["rules"] = filtered
+	return json.Marshal(ruleset)
+}
+
+// monitorActionType is the type of the security rules actions adding tags to the request span without blocking the
+// request, such as {"id": "flag", "type": "monitor", "parameters": {"tags": {"security.flagged": true}}}, so that the
+// suspicious but allowed requests can be found. They apply when the rules referencing them in their on_match match.
+// Only the monitor actions of the security rules in use (cf. DD_APPSEC_RULES) are supported, as remote config doesn't
+// update the security rules nor their actions, but only the rules data (cf. ASM_DATA).
+const monitorActionType = "monitor"
+
+// registerMonitorActions registers the monitor actions found in the actions of the given security rules to the given
+// HTTP actions handler. The other types of actions are ignored.
+func registerMonitorActions(handler *httpsec.ActionsHandler, rules []byte) error {
+	var ruleset struct {
+		Actions []struct {
+			ID         string `json:"id"`
+			Type       string `json:"type"`
+			Parameters struct {
+				Tags map[string]interface{} `json:"tags"`
+			} `json:"parameters"`
+		} `json:"actions"`
+	}
+	if err := json.Unmarshal(rules, &ruleset); err != nil {
+		return fmt.Errorf("could not parse the security rules actions: %v", err)
+	}
+	for _, a := range ruleset.Actions {
+		if a.Type != monitorActionType {
+			continue
+		}
+		if a.ID == "" || len(a.Parameters.Tags) == 0 {
+			log.Error("appsec: ignoring the monitor action %q without an id or tags", a.ID)
+			continue
+		}
+		action := httpsec.NewTagRequestAction(a.Parameters.Tags)
+		handler.RegisterAction(a.ID, &action)
+		log.Debug("appsec: registered the monitor action %s", a.ID)
+	}
+	return nil
+}
+
+// disableRules returns the given security rules without the rules and custom rules having the given IDs. The
+// disabled rules and the IDs matching no rule are logged.
+func disableRules(rules []byte, ids []string) ([]byte, error) {
+	var ruleset map[string]json.RawMessage
+	if err := json.Unmarshal(rules, &ruleset); err != nil {
+		return nil, fmt.Errorf("could not parse the security rules: %v", err)
+	}
+	disabled := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		disabled[id] = false
+	}
+	for _, key := range []string{"rules", "custom_rules"} {
+		// A missing list of rules is left missing, as there is nothing to disable in it.
+		raw, ok := ruleset[key]
+		if !ok {
+			continue
+		}
+		filtered, err := filterRules(raw, disabled)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the security rules: %v", err)
+		}
+		ruleset[key] = filtered
+	}
+	var found, notFound []string
+	for _, id := range ids {
+		if disabled[id] {
+			found = append(found, id)
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+	if len(found) > 0 {
+		log.Info("appsec: disabled the security rules %v (cf. %s)", found, disabledRulesEnvVar)
+	}
+	if len(notFound) > 0 {
+		log.Warn("appsec: could not find the security rules %v to disable (cf. %s)", notFound, disabledRulesEnvVar)
+	}
+	return json.Marshal(ruleset)
+}
+
+// filterRules returns the given JSON list of rules without the rules whose IDs are keys of disabled, setting the
+// value of these keys to true.
+func filterRules(rules json.RawMessage, disabled map[string]bool) (json.RawMessage, error) {
+	var all []json.RawMessage
+	if err := json.Unmarshal(rules, &all); err != nil {
+		return nil, err
+	}
+	enabled := make([]json.RawMessage, 0, len(all))
+	for _, raw := range all {
+		var rule struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(raw, &rule); err != nil {
+			return nil, err
+		}
+		if _, ok := disabled[rule.ID]; ok {
+			disabled[rule.ID] = true
+			continue
+		}
+		enabled = append(enabled, raw)
+	}
+	return json.Marshal(enabled)
+}
+
+// removeAddress returns the given list of addresses without addr.
+func removeAddress(addresses []string, addr string) []string {
+	filtered := make([]string, 0, len(addresses))
+	for _, a := range addresses {
+		if a != addr {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
 type tagsHolder interface {
 	AddTag(string, interface{})
 }
 
+// httpEventRequest returns the metadata of the HTTP request with the given arguments reported with its security events.
+func httpEventRequest(args httpsec.HandlerOperationArgs, blocked bool) SecurityEventRequest {
+	req := SecurityEventRequest{
+		URI:       args.RequestURI,
+		Host:      firstValue(args.Headers, "host"),
+		UserAgent: firstValue(args.Headers, "user-agent"),
+		Blocked:   blocked,
+	}
+	if args.ClientIP.IsValid() {
+		req.ClientIP = args.ClientIP.String()
+	}
+	return req
+}
+
+// grpcEventRequest returns the metadata of the gRPC request with the given arguments reported with its security events.
+func grpcEventRequest(args grpcsec.HandlerOperationArgs, blocked bool) SecurityEventRequest {
+	req := SecurityEventRequest{
+		Host:      firstValue(args.Metadata, ":authority"),
+		UserAgent: firstValue(args.Metadata, "user-agent"),
+		Blocked:   blocked,
+	}
+	if args.ClientIP.IsValid() {
+		req.ClientIP = args.ClientIP.String()
+	}
+	return req
+}
+
+// firstValue returns the first value of the given key of the lowercased headers or metadata h, or an empty string.
+func firstValue(h map[string][]string, key string) string {
+	if v := h[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+type securityEventsHolder interface {
+	Events() []json.RawMessage
+	SampleOutEvents()
+}
+
+// sampleMonitoringEvents decides whether the security events of the request of the given operation force-keep its
+// trace according to the given keep rate, unless they blocked the request, in which case the trace is always kept.
+func sampleMonitoringEvents(eh securityEventsHolder, blocked bool, rate float64) {
+	if blocked || rate >= 1 || len(eh.Events()) == 0 {
+		return
+	}
+	if rand.Float64() >= rate {
+		eh.SampleOutEvents()
+	}
+}
+
 // Add the tags related to security rules monitoring
 func addRulesMonitoringTags(th tagsHolder, rInfo waf.RulesetInfo) {
 	if len(rInfo.Errors) == 0 {
@@ -462,6 +820,36 @@ func addRulesMonitoringTags(th tagsHolder, rInfo waf.RulesetInfo) {
 	th.AddTag(wafVersionTag, waf.Version())
 }
 
+// wafSampledTag is the span tag recording whether the request was monitored by the WAF, when the WAF sample rate is
+// lower than 1.
+const wafSampledTag = "_dd.appsec.waf.sampled"
+
+// sampleWAF reports whether the request of the given operation is monitored by the WAF according to the given sample
+// rate, and records the decision in the operation tags when the rate is lower than 1.
+func sampleWAF(th tagsHolder, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rand.Float64() < rate {
+		th.AddTag(wafSampledTag, 1)
+		return true
+	}
+	th.AddTag(wafSampledTag, 0)
+	return false
+}
+
+// Report the overall WAF execution time of a request as the datadog.appsec.waf.duration metric, tagged by whether the
+// WAF detected an attack.
+func reportWAFDuration(statsd StatsdClient, overallRuntimeNs uint64, match bool) {
+	if statsd == nil {
+		return
+	}
+	tags := []string{"match:" + strconv.FormatBool(match)}
+	if err := statsd.Timing(wafDurationMetric, time.Duration(overallRuntimeNs), tags, 1); err != nil {
+		log.Debug("appsec: could not report the WAF duration metric: %v", err)
+	}
+}
+
 // Add the tags related to the monitoring of the WAF
 func addWAFMonitoringTags(th tagsHolder, rulesVersion string, overallRuntimeNs, internalRuntimeNs, timeouts uint64) {
 	// Rules version is set for every request to help the backend associate WAF duration metrics with rule version
@@ -9,17 +9,23 @@
 package appsec_test
 
 import (
+	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	pAppsec "gopkg.in/DataDog/dd-trace-go.v1/appsec"
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation"
 
 	"github.com/stretchr/testify/require"
 )
@@ -173,6 +179,171 @@ func TestWAF(t *testing.T) {
 	})
 }
 
+// Test that the WAF is run on the response addresses once the handler has returned, using custom rules
+// TestBypass checks that requests whose context bypasses the security monitoring are traced but not monitored
+func TestBypass(t *testing.T) {
+	appsec.Start()
+	defer appsec.Stop()
+
+	if !appsec.Enabled() {
+		t.Skip("appsec disabled")
+	}
+
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		pAppsec.MonitorParsedHTTPBody(r.Context(), "$globals")
+		w.Write([]byte("Hello World!\n"))
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-internal") != "" {
+			r = r.WithContext(pAppsec.Bypass(r.Context()))
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name     string
+		bypassed bool
+	}{
+		{name: "monitored"},
+		{name: "bypassed", bypassed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			req, err := http.NewRequest("POST", srv.URL+"/?x=../../../secret.txt", nil)
+			require.NoError(t, err)
+			if tc.bypassed {
+				req.Header.Set("x-internal", "true")
+			}
+			res, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			finished := mt.FinishedSpans()
+			require.Len(t, finished, 1)
+			event := finished[0].Tag("_dd.appsec.json")
+			if tc.bypassed {
+				require.Nil(t, event)
+				return
+			}
+			require.NotNil(t, event)
+			require.Contains(t, event, "crs-930-110")
+			require.Contains(t, event, "crs-933-130")
+		})
+	}
+}
+
+// timingRecorder is a StatsdClient recording the tags of the timing metrics reported under a given name
+type timingRecorder struct {
+	mu   sync.Mutex
+	tags map[string][][]string
+}
+
+func (r *timingRecorder) Timing(name string, _ time.Duration, tags []string, _ float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tags[name] = append(r.tags[name], tags)
+	return nil
+}
+
+func TestWAFDurationMetric(t *testing.T) {
+	statsd := &timingRecorder{tags: map[string][][]string{}}
+	appsec.Start(appsec.WithStatsdClient(statsd))
+	defer appsec.Stop()
+
+	if !appsec.Enabled() {
+		t.Skip("appsec disabled")
+	}
+
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World!\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, path := range []string{"/", "/?x=../../../secret.txt"} {
+		res, err := srv.Client().Get(srv.URL + path)
+		require.NoError(t, err)
+		res.Body.Close()
+	}
+
+	statsd.mu.Lock()
+	defer statsd.mu.Unlock()
+	require.Equal(t, [][]string{{"match:false"}, {"match:true"}}, statsd.tags["datadog.appsec.waf.duration"])
+}
+
+func TestResponseAddresses(t *testing.T) {
+	t.Setenv("DD_APPSEC_RULES", "testdata/response.json")
+	appsec.Start()
+	defer appsec.Stop()
+	if !appsec.Enabled() {
+		t.Skip("AppSec needs to be enabled for this test")
+	}
+
+	// Start and trace an HTTP server
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	mux.HandleFunc("/headers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Debug-Token", "leaked")
+		w.Write([]byte("Hello World!\n"))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World!\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name      string
+		endpoint  string
+		status    int
+		ruleMatch string
+	}{
+		{
+			name:     "no-match",
+			endpoint: "/",
+			status:   200,
+		},
+		{
+			name:      "status",
+			endpoint:  "/status",
+			status:    418,
+			ruleMatch: "rsp-001-001",
+		},
+		{
+			name:      "headers",
+			endpoint:  "/headers",
+			status:    200,
+			ruleMatch: "rsp-001-002",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+			res, err := srv.Client().Get(srv.URL + tc.endpoint)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			// Response-based detections cannot block the request
+			require.Equal(t, tc.status, res.StatusCode)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			if tc.ruleMatch == "" {
+				require.Nil(t, spans[0].Tag("_dd.appsec.json"))
+				return
+			}
+			require.Contains(t, spans[0].Tag("_dd.appsec.json"), tc.ruleMatch)
+		})
+	}
+}
+
 // Test that request blocking works by using custom rules/rules data
 func TestBlocking(t *testing.T) {
 	t.Setenv("DD_APPSEC_RULES", "testdata/blocking.json")
@@ -283,3 +454,423 @@ func TestBlocking(t *testing.T) {
 		})
 	}
 }
+
+// TestMinimalMode checks that only the blocking rules are run in minimal mode
+func TestMinimalMode(t *testing.T) {
+	t.Setenv("DD_APPSEC_RULES", "testdata/blocking.json")
+	for _, tc := range []struct {
+		name     string
+		opts     []appsec.StartOption
+		detected bool
+	}{
+		{name: "default", detected: true},
+		{name: "minimal", opts: []appsec.StartOption{appsec.WithMinimalMode()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			appsec.Start(tc.opts...)
+			defer appsec.Stop()
+			if !appsec.Enabled() {
+				t.Skip("AppSec needs to be enabled for this test")
+			}
+
+			mux := httptrace.NewServeMux()
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("Hello World!\n"))
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			t.Run("xss", func(t *testing.T) {
+				mt := mocktracer.Start()
+				defer mt.Stop()
+				res, err := srv.Client().Get(srv.URL + "/?x=" + url.QueryEscape("<script>alert(1)</script>"))
+				require.NoError(t, err)
+				res.Body.Close()
+				require.Equal(t, http.StatusOK, res.StatusCode)
+
+				spans := mt.FinishedSpans()
+				require.Len(t, spans, 1)
+				if tc.detected {
+					require.Contains(t, spans[0].Tag("_dd.appsec.json"), "crs-941-110")
+				} else {
+					require.Nil(t, spans[0].Tag("_dd.appsec.json"))
+				}
+			})
+
+			t.Run("ip-block", func(t *testing.T) {
+				mt := mocktracer.Start()
+				defer mt.Stop()
+				req, err := http.NewRequest("GET", srv.URL, nil)
+				require.NoError(t, err)
+				req.Header.Set("x-forwarded-for", "1.2.3.4")
+				res, err := srv.Client().Do(req)
+				require.NoError(t, err)
+				res.Body.Close()
+				require.Equal(t, http.StatusForbidden, res.StatusCode)
+			})
+		})
+	}
+}
+
+// TestDisabledRules checks that the security rules listed in DD_APPSEC_DISABLED_RULES are not run
+func TestDisabledRules(t *testing.T) {
+	t.Setenv("DD_APPSEC_RULES", "testdata/blocking.json")
+	t.Setenv("DD_APPSEC_DISABLED_RULES", "crs-941-110,unknown-rule")
+	appsec.Start()
+	defer appsec.Stop()
+	if !appsec.Enabled() {
+		t.Skip("AppSec needs to be enabled for this test")
+	}
+
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World!\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Run("disabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+		res, err := srv.Client().Get(srv.URL + "/?x=" + url.QueryEscape("<script>alert(1)</script>"))
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		require.Nil(t, spans[0].Tag("_dd.appsec.json"))
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("x-forwarded-for", "1.2.3.4")
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+	})
+}
+
+// Test that the monitor actions of the security rules tag the request span when the rules referencing them match,
+// without blocking the request.
+func TestMonitorActions(t *testing.T) {
+	t.Setenv("DD_APPSEC_RULES", "testdata/monitor.json")
+	appsec.Start()
+	defer appsec.Stop()
+	if !appsec.Enabled() {
+		t.Skip("AppSec needs to be enabled for this test")
+	}
+
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World!\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name    string
+		query   string
+		xff     string
+		monitor interface{}
+		flagged interface{}
+	}{
+		{name: "no-match"},
+		{name: "xss", query: "?x=" + url.QueryEscape("<script>alert(1)</script>"), monitor: "xss", flagged: true},
+		{name: "ip", xff: "1.2.3.4", monitor: "ip"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+			req, err := http.NewRequest("GET", srv.URL+"/"+tc.query, nil)
+			require.NoError(t, err)
+			if tc.xff != "" {
+				req.Header.Set("x-forwarded-for", tc.xff)
+			}
+			res, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			require.Equal(t, tc.monitor, spans[0].Tag("appsec.monitor"))
+			require.Equal(t, tc.flagged, spans[0].Tag("appsec.flagged"))
+		})
+	}
+}
+
+func TestEventEnricher(t *testing.T) {
+	type tenantKey struct{}
+	enricher := func(ctx context.Context, span instrumentation.TagSetter, events []json.RawMessage) {
+		if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+			span.SetTag("appsec.tenant", tenant)
+		}
+		span.SetTag("appsec.enriched_events", len(events))
+	}
+	appsec.Start(appsec.WithEventEnricher(enricher))
+	defer appsec.Stop()
+
+	if !appsec.Enabled() {
+		t.Skip("appsec disabled")
+	}
+
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World!\n"))
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, "acme")))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name     string
+		query    string
+		enriched bool
+	}{
+		{name: "attack", query: "?x=../../../secret.txt", enriched: true},
+		{name: "no-attack", query: "?x=hello"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			res, err := srv.Client().Get(srv.URL + "/" + tc.query)
+			require.NoError(t, err)
+			res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			finished := mt.FinishedSpans()
+			require.Len(t, finished, 1)
+			if !tc.enriched {
+				require.Nil(t, finished[0].Tag("appsec.tenant"))
+				return
+			}
+			require.NotNil(t, finished[0].Tag("_dd.appsec.json"))
+			require.Equal(t, "acme", finished[0].Tag("appsec.tenant"))
+			require.Equal(t, 1, finished[0].Tag("appsec.enriched_events"))
+		})
+	}
+}
+
+func TestEventSink(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []appsec.SecurityEvent
+	)
+	appsec.Start(appsec.WithEventSink(func(event appsec.SecurityEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}))
+	stopped := false
+	defer func() {
+		if !stopped {
+			appsec.Stop()
+		}
+	}()
+
+	if !appsec.Enabled() {
+		t.Skip("appsec disabled")
+	}
+
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World!\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, query := range []string{"?x=hello", "?x=%3Cscript%3Ealert(1)%3C/script%3E"} {
+		req, err := http.NewRequest("GET", srv.URL+"/"+query, nil)
+		require.NoError(t, err)
+		req.Header.Set("User-Agent", "event-sink-test")
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	// Stopping appsec waits for the queued security events to be reported
+	appsec.Stop()
+	stopped = true
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, events)
+	var queryMatch bool
+	for _, event := range events {
+		require.NotEmpty(t, event.RuleID)
+		require.NotEmpty(t, event.RuleType)
+		require.False(t, event.Timestamp.IsZero())
+		require.NotEmpty(t, event.Matches)
+		for _, m := range event.Matches {
+			if m.Address == "server.request.query" {
+				require.Equal(t, []string{"x", "0"}, m.KeyPath)
+				queryMatch = true
+			}
+		}
+		require.Equal(t, "/?x=%3Cscript%3Ealert(1)%3C/script%3E", event.Request.URI)
+		require.Equal(t, "event-sink-test", event.Request.UserAgent)
+		require.NotEmpty(t, event.Request.Host)
+		require.False(t, event.Request.Blocked)
+	}
+	require.True(t, queryMatch)
+}
+
+func TestWAFSampleRate(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     []appsec.StartOption
+		detected bool
+		sampled  interface{}
+	}{
+		{name: "default", detected: true},
+		{name: "sampled", opts: []appsec.StartOption{appsec.WithWAFSampleRate(0.999999999)}, detected: true, sampled: 1},
+		{name: "not-sampled", opts: []appsec.StartOption{appsec.WithWAFSampleRate(0)}, sampled: 0},
+		{name: "invalid", opts: []appsec.StartOption{appsec.WithWAFSampleRate(2)}, detected: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			appsec.Start(tc.opts...)
+			defer appsec.Stop()
+			if !appsec.Enabled() {
+				t.Skip("AppSec needs to be enabled for this test")
+			}
+
+			mux := httptrace.NewServeMux()
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("Hello World!\n"))
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			mt := mocktracer.Start()
+			defer mt.Stop()
+			res, err := srv.Client().Get(srv.URL + "/?x=../../../secret.txt")
+			require.NoError(t, err)
+			res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			require.Equal(t, tc.sampled, spans[0].Tag("_dd.appsec.waf.sampled"))
+			// The request is still traced with AppSec enabled
+			require.Equal(t, 1, spans[0].Tag("_dd.appsec.enabled"))
+			if tc.detected {
+				require.Contains(t, spans[0].Tag("_dd.appsec.json"), "crs-930-110")
+			} else {
+				require.Nil(t, spans[0].Tag("_dd.appsec.json"))
+			}
+		})
+	}
+}
+
+func TestMonitoringEventKeepRate(t *testing.T) {
+	t.Setenv("DD_APPSEC_RULES", "testdata/blocking.json")
+	for _, tc := range []struct {
+		name string
+		opts []appsec.StartOption
+		kept bool
+	}{
+		{name: "default", kept: true},
+		{name: "sampled-out", opts: []appsec.StartOption{appsec.WithMonitoringEventKeepRate(0)}},
+		{name: "invalid", opts: []appsec.StartOption{appsec.WithMonitoringEventKeepRate(-1)}, kept: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			appsec.Start(tc.opts...)
+			defer appsec.Stop()
+			if !appsec.Enabled() {
+				t.Skip("AppSec needs to be enabled for this test")
+			}
+
+			mux := httptrace.NewServeMux()
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("Hello World!\n"))
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			// The first monitored request is always kept to report the security rules monitoring tags
+			res, err := srv.Client().Get(srv.URL)
+			require.NoError(t, err)
+			res.Body.Close()
+
+			t.Run("monitoring", func(t *testing.T) {
+				mt := mocktracer.Start()
+				defer mt.Stop()
+				res, err := srv.Client().Get(srv.URL + "/?x=" + url.QueryEscape("<script>alert(1)</script>"))
+				require.NoError(t, err)
+				res.Body.Close()
+				require.Equal(t, http.StatusOK, res.StatusCode)
+
+				spans := mt.FinishedSpans()
+				require.Len(t, spans, 1)
+				// The security events are reported regardless of the keep decision
+				require.Contains(t, spans[0].Tag("_dd.appsec.json"), "crs-941-110")
+				require.Equal(t, tc.kept, spans[0].Tag(ext.ManualKeep) != nil)
+			})
+
+			t.Run("blocking", func(t *testing.T) {
+				mt := mocktracer.Start()
+				defer mt.Stop()
+				req, err := http.NewRequest("GET", srv.URL, nil)
+				require.NoError(t, err)
+				req.Header.Set("x-forwarded-for", "1.2.3.4")
+				res, err := srv.Client().Do(req)
+				require.NoError(t, err)
+				res.Body.Close()
+				require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+				spans := mt.FinishedSpans()
+				require.Len(t, spans, 1)
+				require.NotNil(t, spans[0].Tag(ext.ManualKeep))
+			})
+		})
+	}
+}
+
+func TestRulesFromFiles(t *testing.T) {
+	appsec.Start(appsec.WithRulesFromFiles("testdata/blocking.json", "testdata/overlay.json"))
+	defer appsec.Stop()
+	if !appsec.Enabled() {
+		t.Skip("AppSec needs to be enabled for this test")
+	}
+
+	mux := httptrace.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World!\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name     string
+		query    string
+		detected string
+	}{
+		// crs-941-110 is replaced by the overlay with a rule that can't match this query
+		{name: "edited-rule", query: "x=" + url.QueryEscape("<script>alert(1)</script>")},
+		// custom-001 is added by the overlay
+		{name: "added-rule", query: "x=dd-overlay-attack", detected: "custom-001"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+			res, err := srv.Client().Get(srv.URL + "/?" + tc.query)
+			require.NoError(t, err)
+			res.Body.Close()
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			event := spans[0].Tag("_dd.appsec.json")
+			if tc.detected == "" {
+				require.Nil(t, event)
+				return
+			}
+			require.Contains(t, event, tc.detected)
+		})
+	}
+}
@@ -9,9 +9,18 @@
 package appsec
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
 	"testing"
+	"time"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/httpsec"
 
 	waf "github.com/DataDog/go-libddwaf"
 	"github.com/stretchr/testify/require"
@@ -38,3 +47,228 @@ func TestTagsTypes(t *testing.T) {
 		require.Contains(t, tags, tag)
 	}
 }
+
+func TestEventSinkDrops(t *testing.T) {
+	release := make(chan struct{})
+	var reported []SecurityEvent
+	sink := newEventSink(func(event SecurityEvent) {
+		<-release
+		reported = append(reported, event)
+	})
+	events := []json.RawMessage{json.RawMessage(`[{"rule":{"id":"ua0-600-12x","name":"Arachni","tags":{"type":"security_scanner"}},"rule_matches":[{"parameters":[{"address":"server.request.headers.no_cookies","key_path":["user-agent",0],"highlight":["Arachni/v"]}]}]}]`)}
+	// Fill the queue while the sink is blocked reporting the first security event
+	for i := 0; i < eventSinkQueueSize+10; i++ {
+		sink.report(events, SecurityEventRequest{URI: "/"})
+	}
+	require.NotZero(t, sink.droppedEvents())
+	require.LessOrEqual(t, sink.droppedEvents(), uint64(10))
+	close(release)
+	sink.stop(eventSinkStopTimeout)
+
+	require.Len(t, reported, eventSinkQueueSize+10-int(sink.droppedEvents()))
+	require.Equal(t, SecurityEvent{
+		RuleID:   "ua0-600-12x",
+		RuleName: "Arachni",
+		RuleType: "security_scanner",
+		Matches: []SecurityEventMatch{{
+			Address:   "server.request.headers.no_cookies",
+			KeyPath:   []string{"user-agent", "0"},
+			Highlight: []string{"Arachni/v"},
+		}},
+		Timestamp: reported[0].Timestamp,
+		Request:   SecurityEventRequest{URI: "/"},
+	}, reported[0])
+}
+
+func TestEventSinkStop(t *testing.T) {
+	events := []json.RawMessage{json.RawMessage(`[{"rule":{"id":"ua0-600-12x","name":"Arachni","tags":{"type":"security_scanner"}},"rule_matches":[{"parameters":[{"address":"server.request.headers.no_cookies","key_path":["user-agent",0],"highlight":["Arachni/v"]}]}]}]`)}
+
+	t.Run("flush", func(t *testing.T) {
+		var reported int
+		sink := newEventSink(func(event SecurityEvent) {
+			time.Sleep(time.Millisecond)
+			reported++
+		})
+		for i := 0; i < 10; i++ {
+			sink.report(events, SecurityEventRequest{URI: "/"})
+		}
+		sink.stop(time.Minute)
+		require.Equal(t, 10, reported)
+		require.Zero(t, sink.droppedEvents())
+
+		// the in-flight requests finishing after the sink is stopped have their events dropped
+		require.NotPanics(t, func() { sink.report(events, SecurityEventRequest{URI: "/"}) })
+		require.Equal(t, uint64(1), sink.droppedEvents())
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		reporting := make(chan struct{}, 3)
+		release := make(chan struct{})
+		var reported int
+		sink := newEventSink(func(event SecurityEvent) {
+			reporting <- struct{}{}
+			<-release
+			reported++
+		})
+		for i := 0; i < 3; i++ {
+			sink.report(events, SecurityEventRequest{URI: "/"})
+		}
+		<-reporting
+		start := time.Now()
+		sink.stop(10 * time.Millisecond)
+		require.Less(t, time.Since(start), time.Second)
+
+		// the event being reported when the timeout expired is still reported, and the queued ones are dropped
+		close(release)
+		<-sink.done
+		require.Equal(t, 1, reported)
+		require.Equal(t, uint64(2), sink.droppedEvents())
+	})
+}
+
+// countingStatsd is a StatsdClient also providing the Incr method of the DogStatsD client.
+type countingStatsd struct {
+	mu     sync.Mutex
+	counts map[string][]string // tags of the counted metrics by name
+}
+
+func (*countingStatsd) Timing(string, time.Duration, []string, float64) error { return nil }
+
+func (c *countingStatsd) Incr(name string, tags []string, _ float64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[name] = append(c.counts[name], tags...)
+	return nil
+}
+
+// tagsSpan is a ddtrace.Span recording its tags.
+type tagsSpan struct {
+	ddtrace.Span
+	mu   sync.Mutex
+	tags map[string]interface{}
+}
+
+func (s *tagsSpan) SetTag(k string, v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tags[k] = v
+}
+
+func TestWAFErrorBehavior(t *testing.T) {
+	defer func(run func(*waf.Context, map[string]interface{}, time.Duration) ([]byte, []string, error)) {
+		wafRun = run
+	}(wafRun)
+	wafRun = func(*waf.Context, map[string]interface{}, time.Duration) ([]byte, []string, error) {
+		return nil, nil, errors.New("waf failure")
+	}
+
+	for _, tc := range []struct {
+		name     string
+		opts     []StartOption
+		status   int
+		behavior string
+	}{
+		{name: "default", status: http.StatusOK, behavior: "fail_open"},
+		{name: "fail-open", opts: []StartOption{WithWAFErrorBehavior(WAFFailOpen)}, status: http.StatusOK, behavior: "fail_open"},
+		{name: "fail-closed", opts: []StartOption{WithWAFErrorBehavior(WAFFailClosed)}, status: http.StatusForbidden, behavior: "fail_closed"},
+		{name: "invalid", opts: []StartOption{WithWAFErrorBehavior(WAFErrorBehavior(42))}, status: http.StatusOK, behavior: "fail_open"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			statsd := &countingStatsd{counts: map[string][]string{}}
+			Start(append(tc.opts, WithStatsdClient(statsd))...)
+			defer Stop()
+			if !Enabled() {
+				t.Skip("appsec disabled")
+			}
+			span := &tagsSpan{tags: map[string]interface{}{}}
+			h := httpsec.WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("Hello World!\n"))
+			}), span, nil)
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "1.2.3.4:1234"
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+
+			require.Equal(t, tc.status, res.Code)
+			require.Equal(t, "waf failure", span.tags[wafErrorTag])
+			require.NotEmpty(t, statsd.counts[wafErrorMetric])
+			require.Contains(t, statsd.counts[wafErrorMetric], "behavior:"+tc.behavior)
+		})
+	}
+}
+
+func TestBlockingRules(t *testing.T) {
+	rules := []byte(`{
+		"version": "2.2",
+		"rules": [
+			{"id": "default-block", "on_match": ["block"]},
+			{"id": "custom-block", "on_match": ["monitor", "custom_block"]},
+			{"id": "redirect", "on_match": ["redirect"]},
+			{"id": "monitor-only", "on_match": ["monitor"]},
+			{"id": "unknown-action", "on_match": ["unknown"]},
+			{"id": "no-action"}
+		],
+		"actions": [
+			{"id": "monitor", "type": "monitor", "parameters": {"tags": {"appsec.monitor": "true"}}},
+			{"id": "custom_block", "type": "block_request", "parameters": {"status_code": 403}},
+			{"id": "redirect", "type": "redirect_request", "parameters": {"location": "/blocked"}}
+		]
+	}`)
+	filtered, err := blockingRules(rules)
+	require.NoError(t, err)
+	var ruleset struct {
+		Rules []struct {
+			ID string `json:"id"`
+		} `json:"rules"`
+		Actions []json.RawMessage `json:"actions"`
+	}
+	require.NoError(t, json.Unmarshal(filtered, &ruleset))
+	var ids []string
+	for _, r := range ruleset.Rules {
+		ids = append(ids, r.ID)
+	}
+	require.Equal(t, []string{"default-block", "custom-block", "redirect"}, ids)
+	require.Len(t, ruleset.Actions, 3)
+
+	t.Run("monitor-only", func(t *testing.T) {
+		rules, err := os.ReadFile("testdata/monitor.json")
+		require.NoError(t, err)
+		_, err = blockingRules(rules)
+		require.Error(t, err)
+	})
+}
+
+func TestDisableRules(t *testing.T) {
+	ids := func(t *testing.T, rules json.RawMessage) (ids []string) {
+		var list []struct {
+			ID string `json:"id"`
+		}
+		require.NoError(t, json.Unmarshal(rules, &list))
+		for _, r := range list {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	t.Run("rules-and-custom-rules", func(t *testing.T) {
+		rules := []byte(`{"version":"2.2","rules":[{"id":"r1"},{"id":"r2"}],"custom_rules":[{"id":"c1"},{"id":"c2"}]}`)
+		filtered, err := disableRules(rules, []string{"r1", "c2", "unknown"})
+		require.NoError(t, err)
+		var ruleset map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal(filtered, &ruleset))
+		require.Equal(t, []string{"r2"}, ids(t, ruleset["rules"]))
+		require.Equal(t, []string{"c1"}, ids(t, ruleset["custom_rules"]))
+		require.JSONEq(t, `"2.2"`, string(ruleset["version"]))
+	})
+
+	t.Run("missing-rules", func(t *testing.T) {
+		filtered, err := disableRules([]byte(`{"version":"2.2","custom_rules":[{"id":"c1"}]}`), []string{"c1"})
+		require.NoError(t, err)
+		require.JSONEq(t, `{"version":"2.2","custom_rules":[]}`, string(filtered))
+	})
+
+	t.Run("invalid-rules", func(t *testing.T) {
+		_, err := disableRules([]byte(`{"rules":{}}`), []string{"r1"})
+		require.Error(t, err)
+	})
+}
@@ -15,6 +15,8 @@ import (
 	"math/big"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	rc "github.com/DataDog/datadog-agent/pkg/remoteconfig/state"
@@ -57,10 +59,14 @@ type Client struct {
 	endpoint   string
 	repository *rc.Repository
 	stop       chan struct{}
+	done       chan struct{} // closed when the poll loop returns, nil until the client is started
 
-	callbacks map[string][]Callback
+	callbacksMu sync.RWMutex // guards callbacks
+	callbacks   map[string][]Callback
 
 	lastError error
+	// connected is 1 when the last poll of the agent succeeded, and 0 otherwise. It is accessed atomically.
+	connected int32
 }
 
 // NewClient creates a new remoteconfig Client
@@ -86,7 +92,9 @@ func NewClient(config ClientConfig) (*Client, error) {
 
 // Start starts the client's update poll loop in a fresh goroutine
 func (c *Client) Start() {
+	c.done = make(chan struct{})
 	go func() {
+		defer close(c.done)
 		ticker := time.NewTicker(c.PollInterval)
 		defer ticker.Stop()
 
@@ -101,9 +109,36 @@ func (c *Client) Start() {
 	}()
 }
 
-// Stop stops the client's update poll loop
+// stopTimeout is the maximum duration Stop waits for the poll loop to return.
+var stopTimeout = 5 * time.Second
+
+// Stop stops the client's update poll loop. It waits for the update in progress, if any, to complete so that no
+// callback is called once it returns, but at most for a bounded time not to delay the shutdown of the service when
+// the agent is slow to respond.
 func (c *Client) Stop() {
 	close(c.stop)
+	if c.done == nil {
+		return
+	}
+	select {
+	case <-c.done:
+	case <-time.After(stopTimeout):
+		log.Warn("remoteconfig: the update in progress didn't complete within %s while stopping the client", stopTimeout)
+	}
+}
+
+// Connected reports whether the last poll of the agent succeeded. It is false until the first poll, which happens
+// after the poll interval.
+func (c *Client) Connected() bool {
+	return atomic.LoadInt32(&c.connected) == 1
+}
+
+func (c *Client) setConnected(connected bool) {
+	var v int32
+	if connected {
+		v = 1
+	}
+	atomic.StoreInt32(&c.connected, v)
 }
 
 func (c *Client) updateState() {
@@ -122,6 +157,7 @@ func (c *Client) updateState() {
 	resp, err := c.HTTP.Do(req)
 	if err != nil {
 		log.Debug("remoteconfig: http request error: %v", err)
+		c.setConnected(false)
 		return
 	}
 	// Flush and close the response body when returning (cf. https://pkg.go.dev/net/http#Client.Do)
@@ -132,8 +168,10 @@ func (c *Client) updateState() {
 
 	if sc := resp.StatusCode; sc != http.StatusOK {
 		log.Debug("remoteconfig: http request error: response status code is not 200 (OK) but %s", http.StatusText(sc))
+		c.setConnected(false)
 		return
 	}
+	c.setConnected(true)
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -157,9 +195,26 @@ func (c *Client) updateState() {
 // RegisterCallback allows registering a callback that will be invoked when the client
 // receives a configuration update for the specified product.
 func (c *Client) RegisterCallback(f Callback, product string) {
+	c.callbacksMu.Lock()
+	defer c.callbacksMu.Unlock()
 	c.callbacks[product] = append(c.callbacks[product], f)
 }
 
+// UnregisterCallbacks removes all the callbacks registered for the given product
+func (c *Client) UnregisterCallbacks(product string) {
+	c.callbacksMu.Lock()
+	defer c.callbacksMu.Unlock()
+	delete(c.callbacks, product)
+}
+
+// productCallbacks returns the callbacks registered for the given product. They are called without holding the lock
+// so that they can register or unregister callbacks.
+func (c *Client) productCallbacks(product string) []Callback {
+	c.callbacksMu.RLock()
+	defer c.callbacksMu.RUnlock()
+	return c.callbacks[product]
+}
+
 func (c *Client) applyUpdate(pbUpdate *clientGetConfigsResponse) error {
 	fileMap := make(map[string][]byte, len(pbUpdate.TargetFiles))
 	productUpdates := make(map[string]ProductUpdate, len(c.Products))
@@ -228,7 +283,7 @@ func (c *Client) applyUpdate(pbUpdate *clientGetConfigsResponse) error {
 	// Performs the callbacks registered for all updated products and update the application status in the repository
 	// (RCTE2)
 	for p := range updatedProducts {
-		for _, fn := range c.callbacks[p] {
+		for _, fn := range c.productCallbacks(p) {
 			for path, status := range fn(productUpdates[p]) {
 				c.repository.UpdateApplyStatus(path, status)
 			}
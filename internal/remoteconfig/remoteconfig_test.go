@@ -9,7 +9,10 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -43,6 +46,19 @@ func TestRCClient(t *testing.T) {
 		require.Equal(t, 1, len(client.callbacks))
 	})
 
+	t.Run("unregisterCallbacks", func(t *testing.T) {
+		client.callbacks = map[string][]Callback{}
+		nilCallback := func(ProductUpdate) map[string]rc.ApplyStatus { return nil }
+		defer func() { client.callbacks = map[string][]Callback{} }()
+		client.RegisterCallback(nilCallback, rc.ProductASMFeatures)
+		client.RegisterCallback(nilCallback, rc.ProductASMFeatures)
+		client.RegisterCallback(nilCallback, rc.ProductASMData)
+		client.UnregisterCallbacks(rc.ProductASMFeatures)
+		require.Equal(t, 0, len(client.callbacks[rc.ProductASMFeatures]))
+		require.Equal(t, 1, len(client.callbacks[rc.ProductASMData]))
+		require.Equal(t, 1, len(client.callbacks))
+	})
+
 	t.Run("apply-update", func(t *testing.T) {
 		client.callbacks = map[string][]Callback{}
 		cfgPath := "datadog/2/ASM_FEATURES/asm_features_activation/config"
@@ -61,6 +77,116 @@ func TestRCClient(t *testing.T) {
 	})
 }
 
+func TestConnected(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	cfg := DefaultClientConfig()
+	cfg.AgentURL = srv.URL
+	client, err := NewClient(cfg)
+	require.NoError(t, err)
+	require.False(t, client.Connected())
+
+	client.updateState()
+	require.True(t, client.Connected())
+
+	status = http.StatusNotFound
+	client.updateState()
+	require.False(t, client.Connected())
+
+	status = http.StatusOK
+	client.updateState()
+	require.True(t, client.Connected())
+	srv.Close()
+	client.updateState()
+	require.False(t, client.Connected())
+}
+
+func TestStop(t *testing.T) {
+	newPollingClient := func(t *testing.T) (client *Client, polling <-chan struct{}, release chan<- struct{}) {
+		pollingCh := make(chan struct{}, 1)
+		releaseCh := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case pollingCh <- struct{}{}:
+			default:
+			}
+			<-releaseCh
+			w.Write([]byte(`{}`))
+		}))
+		t.Cleanup(srv.Close)
+		cfg := DefaultClientConfig()
+		cfg.AgentURL = srv.URL
+		cfg.PollInterval = time.Millisecond
+		client, err := NewClient(cfg)
+		require.NoError(t, err)
+		return client, pollingCh, releaseCh
+	}
+
+	t.Run("not-started", func(t *testing.T) {
+		client, err := NewClient(DefaultClientConfig())
+		require.NoError(t, err)
+		client.Stop()
+	})
+
+	t.Run("update-in-progress", func(t *testing.T) {
+		client, polling, release := newPollingClient(t)
+		client.Start()
+		<-polling
+		stopped := make(chan struct{})
+		go func() {
+			client.Stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			t.Fatal("the client stopped before the update in progress completed")
+		case <-time.After(50 * time.Millisecond):
+		}
+		close(release)
+		<-stopped
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		defer func(timeout time.Duration) { stopTimeout = timeout }(stopTimeout)
+		stopTimeout = 10 * time.Millisecond
+		client, polling, release := newPollingClient(t)
+		defer close(release)
+		client.Start()
+		<-polling
+		client.Stop()
+	})
+}
+
+func TestCallbacks(t *testing.T) {
+	client, err := NewClient(DefaultClientConfig())
+	require.NoError(t, err)
+	noop := func(ProductUpdate) map[string]rc.ApplyStatus { return nil }
+
+	// the callbacks can be registered and unregistered while an update calls them
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			client.RegisterCallback(noop, "ASM")
+			client.UnregisterCallbacks("ASM")
+		}()
+		go func() {
+			defer wg.Done()
+			for _, fn := range client.productCallbacks("ASM") {
+				fn(nil)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Empty(t, client.productCallbacks("ASM"))
+}
+
 func TestPayloads(t *testing.T) {
 	t.Run("getConfigResponse", func(t *testing.T) {
 